@@ -1,63 +1,379 @@
 package main
 
 import (
+	"bufio"
 	"context"
 	"errors"
+	"fmt"
 	"github.com/Paincake/avito-tech/internal/config"
 	"github.com/Paincake/avito-tech/internal/database"
 	"github.com/Paincake/avito-tech/internal/database/postgres"
+	"github.com/Paincake/avito-tech/internal/dto"
+	"github.com/Paincake/avito-tech/internal/grpcserver"
+	"github.com/Paincake/avito-tech/internal/metrics"
+	"github.com/Paincake/avito-tech/internal/openapi"
 	"github.com/Paincake/avito-tech/internal/server"
+	"github.com/Paincake/avito-tech/internal/tracing"
 	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"golang.org/x/crypto/bcrypt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"io"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"time"
 )
 
 func main() {
 	e := echo.New()
 
-	configPath := os.Getenv("TEST_CONFIG_PATH")
+	configPath := os.Getenv("CONFIG_PATH")
 	if configPath == "" {
-		log.Fatal(errors.New("env variable not set"))
+		configPath = os.Getenv("TEST_CONFIG_PATH")
 	}
 	cfg, err := config.MustLoad(configPath)
 	if err != nil {
 		log.Fatal(err)
 	}
-	db, _ := postgres.New(cfg.Name, cfg.User, cfg.Password, cfg.Host, cfg.Port)
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err = runMigrate(cfg, os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "superadmin" {
+		if err = runSuperAdmin(cfg, os.Args[2:], os.Stdin); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	db, err := connectDatabase(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if cfg.AutoMigrate {
+		applied, err := db.MigrateUp()
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("applied migrations: %v", applied)
+	}
 	done := make(chan bool)
 	cache := server.NewMemoryCache(db, cfg.CacheKeyInvalidationTime, cfg.CacheSchedulerRate, done)
+	cache.SetMaxStaleness(cfg.CacheMaxStaleness)
+	cache.SetRetention(cfg.CacheRetention)
+	cache.SetLimits(cfg.CacheMaxEntries, cfg.CacheMaxBytes)
 	defer close(done)
-	ConfigureServer(db, cache, e, server.VerifyJWT, server.Logger)
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.TracingEndpoint)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer shutdownTracing(context.Background())
+	m := metrics.New()
+	cache.Metrics = m
+	m.RegisterCacheSize(cache.Size)
+	m.RegisterDB(db.DB())
+	if cfg.DBBreakerThreshold > 0 {
+		breaker := postgres.NewCircuitBreaker(cfg.DBBreakerThreshold, cfg.DBBreakerCooldown)
+		db.UseCircuitBreaker(breaker)
+		m.RegisterCircuitBreaker(func() bool { return breaker.State() == postgres.BreakerOpen })
+	}
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
 	defer stop()
+	if cfg.CacheWarmUp {
+		warmedUp := cache.WarmUp(ctx, cfg.CacheWarmUpTimeout)
+		go func() {
+			if err := <-warmedUp; err != nil {
+				log.Printf("cache warm-up failed, loading banners on demand: %s", err)
+			}
+		}()
+	}
+	_ = server.LogLevel.UnmarshalText([]byte(cfg.LogLevel))
+	cors := server.NewCORS(cfg.CORSAllowOrigins)
+	limiter := server.NewRateLimiter(rateLimitStore(ctx, db, cfg), rateLimits(cfg))
+	si := ConfigureServer(db, cache, *cfg, e, cors.Middleware(), middleware.RequestID(), tracing.Middleware, m.Middleware, server.VerifyJWT, server.Logger, limiter.Middleware)
+	e.GET("/metrics", m.Handler())
+	health := server.NewHealth(cfg.HealthCheckTimeout,
+		server.HealthCheck{Name: "database", Check: db.Ping},
+		server.HealthCheck{Name: "migrations", Check: db.CheckSchemaVersion},
+		server.HealthCheck{Name: "cache", Check: cache.Ready},
+	)
+	e.GET("/healthz", health.Liveness)
+	e.GET("/readyz", health.Readiness)
+	e.GET("/health", health.Report)
+	// In-flight requests run under baseCtx, which is cancelled once graceful
+	// shutdown is over so that their queries are aborted as well.
+	baseCtx, cancelBase := context.WithCancel(context.Background())
+	defer cancelBase()
+	e.Server.ReadTimeout = cfg.HTTPReadTimeout
+	e.Server.WriteTimeout = cfg.HTTPWriteTimeout
+	e.Server.IdleTimeout = cfg.HTTPIdleTimeout
+	e.Server.BaseContext = func(net.Listener) context.Context { return baseCtx }
+	reloader := config.NewReloader(configPath, cfg, slog.New(slog.NewJSONHandler(os.Stdout, nil)))
+	reloader.OnReload(func(cfg *config.Config) {
+		cache.SetExpiration(cfg.CacheKeyInvalidationTime, cfg.CacheSchedulerRate)
+		cache.SetMaxStaleness(cfg.CacheMaxStaleness)
+		cache.SetRetention(cfg.CacheRetention)
+		cache.SetLimits(cfg.CacheMaxEntries, cfg.CacheMaxBytes)
+		_ = server.LogLevel.UnmarshalText([]byte(cfg.LogLevel))
+		cors.SetOrigins(cfg.CORSAllowOrigins)
+		limiter.SetLimits(rateLimits(cfg))
+	})
+	go reloader.Watch(ctx, cfg.ConfigReloadInterval)
+	go server.NewWebhookDispatcher(db, *cfg).Run(ctx)
 	go func() {
-		if err := e.Start(":8080"); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		if err := start(e, cfg); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			e.Logger.Fatal("shutting down")
 		}
 	}()
+	grpcServer, err := startGRPC(si, limiter, cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
 	<-ctx.Done()
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
 	defer cancel()
-	if err := e.Shutdown(ctx); err != nil {
+	go func() {
+		<-ctx.Done()
+		grpcServer.Stop()
+	}()
+	grpcServer.GracefulStop()
+	err = e.Shutdown(ctx)
+	cancelBase()
+	if err != nil {
 		e.Logger.Fatal(err)
 	}
 }
 
-func ConfigureServer(repository database.BannerRepository, cache server.BannerCache, e *echo.Echo, middlewares ...echo.MiddlewareFunc) {
+// start serves HTTP on the configured address, or HTTPS when a TLS
+// certificate is configured.
+func start(e *echo.Echo, cfg *config.Config) error {
+	if cfg.TLSCertFile != "" {
+		return e.StartTLS(cfg.HTTPAddress, cfg.TLSCertFile, cfg.TLSKeyFile)
+	}
+	return e.Start(cfg.HTTPAddress)
+}
+
+// startGRPC serves the gRPC API on the configured address, with the TLS
+// certificate of the HTTP server when there is one, sharing the rate limits
+// of limiter with it.
+func startGRPC(handler *server.Server, limiter *server.RateLimiter, cfg *config.Config) (*grpc.Server, error) {
+	var opts []grpc.ServerOption
+	if cfg.TLSCertFile != "" {
+		creds, err := credentials.NewServerTLSFromFile(cfg.TLSCertFile, cfg.TLSKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, grpc.Creds(creds))
+	}
+	listener, err := net.Listen("tcp", cfg.GRPCAddress)
+	if err != nil {
+		return nil, err
+	}
+	grpcServer := grpcserver.New(handler, *cfg, limiter, opts...)
+	go func() {
+		if err := grpcServer.Serve(listener); err != nil {
+			log.Fatal(err)
+		}
+	}()
+	return grpcServer, nil
+}
+
+func rateLimits(cfg *config.Config) map[string]server.RateLimit {
+	return map[string]server.RateLimit{
+		server.RateLimitLatest: {Rate: cfg.RateLimitLatestRate, Burst: cfg.RateLimitLatestBurst},
+		server.RateLimitAdmin:  {Rate: cfg.RateLimitAdminRate, Burst: cfg.RateLimitAdminBurst},
+		server.RateLimitLogin:  {Rate: cfg.RateLimitLoginRate, Burst: cfg.RateLimitLoginBurst},
+	}
+}
+
+// rateLimitStore keeps the rate limits in memory, or in Postgres so that
+// they hold across replicas, pruning idle buckets until ctx is done.
+func rateLimitStore(ctx context.Context, db *postgres.Database, cfg *config.Config) server.RateLimitStore {
+	if cfg.RateLimitBackend != "postgres" {
+		return server.NewMemoryRateLimitStore()
+	}
+	go func() {
+		ticker := time.NewTicker(10 * time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if _, err := db.PruneTokenBuckets(ctx, time.Hour); err != nil {
+					log.Printf("pruning rate limit buckets: %s", err)
+				}
+			}
+		}
+	}()
+	return db
+}
+
+// connectDatabase connects to Postgres, retrying with exponential backoff so
+// the service survives the database starting after it, and gives up after
+// cfg.DBConnectAttempts attempts.
+func connectDatabase(cfg *config.Config) (*postgres.Database, error) {
+	backoff := cfg.DBConnectBackoff
+	var err error
+	for attempt := 1; ; attempt++ {
+		var db *postgres.Database
+		db, err = postgres.New(cfg.Name, cfg.User, cfg.Password, cfg.Host, cfg.Port)
+		if err == nil {
+			db.ConfigurePool(cfg.DBMaxOpenConns, cfg.DBMaxIdleConns, cfg.DBConnMaxLifetime)
+			return db, nil
+		}
+		if attempt >= cfg.DBConnectAttempts {
+			return nil, fmt.Errorf("database unreachable after %d attempts: %w", attempt, err)
+		}
+		log.Printf("database unreachable (attempt %d of %d), retrying in %s: %s", attempt, cfg.DBConnectAttempts, backoff, err)
+		time.Sleep(backoff)
+		backoff = min(backoff*2, 30*time.Second)
+	}
+}
+
+// runMigrate implements the "migrate up|down [steps]|status" subcommand.
+func runMigrate(cfg *config.Config, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: migrate up|down [steps]|status")
+	}
+	db, err := connectDatabase(cfg)
+	if err != nil {
+		return err
+	}
+	switch args[0] {
+	case "up":
+		applied, err := db.MigrateUp()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("applied migrations: %v\n", applied)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return fmt.Errorf("invalid number of steps: %s", args[1])
+			}
+		}
+		reverted, err := db.MigrateDown(steps)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("reverted migrations: %v\n", reverted)
+	case "status":
+		statuses, err := db.MigrationStatus()
+		for _, status := range statuses {
+			applied := "pending"
+			if status.Applied {
+				applied = "applied " + status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d_%s\t%s\n", status.Version, status.Name, applied)
+		}
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown migrate command: %s", args[0])
+	}
+	return nil
+}
+
+// runSuperAdmin implements the "superadmin <username>" subcommand, which
+// creates a super admin, or promotes the user of that name, in the default
+// tenant. The password is read from the first line of stdin, to keep it out
+// of the process list and the shell history.
+func runSuperAdmin(cfg *config.Config, args []string, stdin io.Reader) error {
+	if len(args) != 1 || args[0] == "" {
+		return errors.New("usage: superadmin <username>, with the password on stdin")
+	}
+	password, err := bufio.NewReader(stdin).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	password = strings.TrimRight(password, "\r\n")
+	if password == "" {
+		return errors.New("the password read from stdin is empty")
+	}
+	db, err := connectDatabase(cfg)
+	if err != nil {
+		return err
+	}
+	if err = saveSuperAdmin(context.Background(), db, args[0], password, cfg.BcryptCost); err != nil {
+		return err
+	}
+	fmt.Printf("%s is a super admin\n", args[0])
+	return nil
+}
+
+func saveSuperAdmin(ctx context.Context, db *postgres.Database, username, password string, cost int) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), cost)
+	if err != nil {
+		return err
+	}
+	return db.SaveUser(database.WithTenant(ctx, database.DefaultTenant), username, string(hashedPassword), database.SuperAdminRole)
+}
+
+// ConfigureServer registers the routes of the REST API on e and returns the
+// server behind them, for the gRPC API to share.
+func ConfigureServer(repository database.BannerRepository, cache server.BannerCache, options config.Config, e *echo.Echo, middlewares ...echo.MiddlewareFunc) *server.Server {
+	doc := openapi.MustLoad()
+	e.HTTPErrorHandler = server.ErrorHandler
 	e.Use(middlewares...)
-	si := server.Server{Repository: repository, Cache: cache}
+	e.Use(openapi.Validator(doc))
+	server.ConfigureAuth(options.JWTSecretKey, options.JWTTTL)
+	server.ConfigureAPIKeys(repository)
+	bus := server.NewChangeBus(options.StreamHistory, options.StreamClientBuffer)
+	e.Server.RegisterOnShutdown(bus.Close)
+	si := server.Server{Repository: repository, Cache: cache, Bus: bus}
 
 	wrapper := server.ServerInterfaceWrapper{
 		Handler: &si,
+		Options: options,
 	}
-	e.GET("/banner", wrapper.GetBanner)
-	e.POST("/banner", wrapper.PostBanner)
-	e.DELETE("/banner/:id", wrapper.DeleteBannerID)
-	e.PATCH("/banner/:id", wrapper.PatchBannerID)
-	e.GET("/user_banner", wrapper.GetUserBanner)
-	e.POST("/login", wrapper.Login)
-	e.POST("/signup", wrapper.Signup)
+	e.GET("/banner", wrapper.GetBanner, wrapper.Deadline("GetBanner"))
+	e.GET("/banner/export", wrapper.ExportBanners, wrapper.Deadline("ExportBanners"))
+	e.POST("/banner/import", wrapper.ImportBanners, wrapper.Deadline("ImportBanners"))
+	e.POST("/banner", wrapper.PostBanner, wrapper.Deadline("PostBanner"))
+	e.DELETE("/banner/:id", wrapper.DeleteBannerID, wrapper.Deadline("DeleteBannerID"))
+	e.PATCH("/banner/:id", wrapper.PatchBannerID, wrapper.Deadline("PatchBannerID"))
+	e.POST("/banner/:id/submit", wrapper.TransitionBanner(dto.TransitionSubmit), wrapper.Deadline("TransitionBanner"))
+	e.POST("/banner/:id/approve", wrapper.TransitionBanner(dto.TransitionApprove), wrapper.Deadline("TransitionBanner"))
+	e.POST("/banner/:id/reject", wrapper.TransitionBanner(dto.TransitionReject), wrapper.Deadline("TransitionBanner"))
+	e.POST("/banner/:id/publish", wrapper.TransitionBanner(dto.TransitionPublish), wrapper.Deadline("TransitionBanner"))
+	e.POST("/banner/:id/withdraw", wrapper.TransitionBanner(dto.TransitionWithdraw), wrapper.Deadline("TransitionBanner"))
+	e.GET("/banner/:id/reviews", wrapper.GetBannerReviews, wrapper.Deadline("GetBannerReviews"))
+	e.GET("/audit", wrapper.GetAudit, wrapper.Deadline("GetAudit"))
+	e.GET("/changes", wrapper.GetChanges, wrapper.Deadline("GetChanges"))
+	e.GET("/cache/stats", wrapper.GetCacheStats, wrapper.Deadline("GetCacheStats"))
+	e.DELETE("/cache", wrapper.FlushCache, wrapper.Deadline("FlushCache"))
+	e.POST("/features", wrapper.PostFeature, wrapper.Deadline("PostFeature"))
+	e.GET("/features", wrapper.GetFeatures, wrapper.Deadline("GetFeatures"))
+	e.POST("/tags", wrapper.PostTag, wrapper.Deadline("PostTag"))
+	e.GET("/tags", wrapper.GetTags, wrapper.Deadline("GetTags"))
+	e.POST("/tenants", wrapper.PostTenant, wrapper.Deadline("PostTenant"))
+	e.GET("/tenants", wrapper.GetTenants, wrapper.Deadline("GetTenants"))
+	e.POST("/tenants/:id/admins", wrapper.PostTenantAdmin, wrapper.Deadline("PostTenantAdmin"))
+	e.POST("/webhooks", wrapper.PostWebhook, wrapper.Deadline("PostWebhook"))
+	e.GET("/webhooks", wrapper.GetWebhooks, wrapper.Deadline("GetWebhooks"))
+	e.DELETE("/webhooks/:id", wrapper.DeleteWebhookID, wrapper.Deadline("DeleteWebhookID"))
+	e.GET("/webhooks/:id/deliveries", wrapper.GetWebhookDeliveries, wrapper.Deadline("GetWebhookDeliveries"))
+	e.POST("/api_keys", wrapper.PostAPIKey, wrapper.Deadline("PostAPIKey"))
+	e.GET("/api_keys", wrapper.GetAPIKeys, wrapper.Deadline("GetAPIKeys"))
+	e.DELETE("/api_keys/:id", wrapper.DeleteAPIKeyID, wrapper.Deadline("DeleteAPIKeyID"))
+	e.GET("/user_banner", wrapper.GetUserBanner, wrapper.Deadline("GetUserBanner"))
+	e.POST("/user_banner/batch", wrapper.GetUserBanners, wrapper.Deadline("GetUserBanners"))
+	e.GET("/user_banner/stream", wrapper.StreamUserBanner)
+	e.POST("/login", wrapper.Login, wrapper.Deadline("Login"))
+	e.POST("/signup", wrapper.Signup, wrapper.Deadline("Signup"))
+	e.GET("/openapi.json", openapi.Handler(doc))
+	e.GET("/docs", openapi.SwaggerUI)
+	return &si
 }
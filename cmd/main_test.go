@@ -2,6 +2,8 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"github.com/Paincake/avito-tech/internal/config"
@@ -10,53 +12,25 @@ import (
 	"github.com/Paincake/avito-tech/internal/dto"
 	"github.com/Paincake/avito-tech/internal/server"
 	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
 	"github.com/labstack/gommon/log"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
 	"math/rand/v2"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"reflect"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 )
 
 const (
-	TableCreationDDL = `
-	CREATE TABLE IF NOT EXISTS api_users (
-		username varchar PRIMARY KEY,
-		password varchar,
-		role varchar
-	);
-CREATE TABLE IF NOT EXISTS features (
-    feature_id serial PRIMARY KEY ,
-    description text
-);
-
-CREATE TABLE IF NOT EXISTS tags (
-    tag_id serial PRIMARY KEY ,
-    description text
-);
-
-CREATE TABLE IF NOT EXISTS banners(
-    banner_id serial PRIMARY KEY ,
-    feature_id int REFERENCES features(feature_id) ON DELETE CASCADE,
-    content_title text,
-    content_text text,
-    content_url text,
-    is_active bool,
-    created_at timestamptz,
-    updated_at timestamptz
-);
-
-CREATE TABLE IF NOT EXISTS banner_tags (
-    banner_id int REFERENCES banners(banner_id),
-    tag_id int REFERENCES tags(tag_id),
-    PRIMARY KEY (banner_id, tag_id)
-)
-`
 	TableDeletionDDL = `
+	TRUNCATE TABLE banner_reviews CASCADE;
+	TRUNCATE TABLE audit_log;
 	TRUNCATE TABLE banner_tags CASCADE;
 	TRUNCATE TABLE banners CASCADE;
 	TRUNCATE TABLE features CASCADE;
@@ -117,7 +91,7 @@ func TestPostBanner_ShouldGet201(t *testing.T) {
 	body, _ := json.Marshal(dto.Banner{
 		Tags:      []int64{1},
 		FeatureId: 1,
-		Content:   dto.Content{"a", "v", "c"},
+		Content:   dto.Content{Title: "a", Text: "v", Url: "c"},
 		IsActive:  false,
 		CreatedAt: time.Now().Format(time.RFC3339),
 		UpdatedAt: time.Now().Format(time.RFC3339),
@@ -127,6 +101,17 @@ func TestPostBanner_ShouldGet201(t *testing.T) {
 	req.Header.Set("Token", token)
 	router.ServeHTTP(recorder, req)
 	assert.Equal(t, http.StatusCreated, recorder.Result().StatusCode)
+
+	// keep the fixture the other tests expect
+	var created struct {
+		BannerID int64 `json:"banner_id"`
+	}
+	json.NewDecoder(recorder.Result().Body).Decode(&created)
+	req = httptest.NewRequest("DELETE", fmt.Sprintf("/banner/%d", created.BannerID), nil)
+	req.Header.Set("Token", token)
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusNoContent, recorder.Result().StatusCode)
 }
 
 func TestPostBanner_ShouldThrow403(t *testing.T) {
@@ -134,7 +119,7 @@ func TestPostBanner_ShouldThrow403(t *testing.T) {
 	body, _ := json.Marshal(dto.Banner{
 		Tags:      []int64{1},
 		FeatureId: 1,
-		Content:   dto.Content{"a", "v", "c"},
+		Content:   dto.Content{Title: "a", Text: "v", Url: "c"},
 		IsActive:  false,
 		CreatedAt: time.Now().Format(time.RFC3339),
 		UpdatedAt: time.Now().Format(time.RFC3339),
@@ -146,6 +131,27 @@ func TestPostBanner_ShouldThrow403(t *testing.T) {
 	assert.Equal(t, http.StatusForbidden, recorder.Result().StatusCode)
 }
 
+func TestGetUserBanners_ShouldAnswerEveryItemInOrder(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/user_banner/batch", bytes.NewBufferString(
+		`{"items": [{"feature_id": 2, "tag_id": 2}, {"feature_id": 3, "tag_id": 1}, {"feature_id": 1, "tag_id": 1, "use_last_revision": true}]}`))
+	token, _ := server.CreateJWT("user")
+	req.Header.Set("Token", token)
+	router.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	var body struct {
+		Items []dto.UserBannerBatchResult `json:"items"`
+	}
+	assert.NoError(t, json.NewDecoder(recorder.Body).Decode(&body))
+	if assert.Len(t, body.Items, 3) {
+		assert.True(t, body.Items[0].Found)
+		assert.Equal(t, int64(2), body.Items[0].FeatureId)
+		assert.False(t, body.Items[1].Found)
+		assert.True(t, body.Items[2].Found)
+	}
+}
+
 func TestGetUserBannerWithoutParams_ShouldThrow400(t *testing.T) {
 	recorder := httptest.NewRecorder()
 	req := httptest.NewRequest("GET", "/user_banner", nil)
@@ -164,34 +170,37 @@ func TestGetBannersWithoutParams_ShouldReturnGivenValues(t *testing.T) {
 	ti, _ := time.Parse(time.RFC3339, "2024-04-12T09:23:51.447097Z")
 	examples := []dto.Banner{
 		{
-			[]int64{1, 2},
-			1,
-			dto.Content{
+			Tags:      []int64{1, 2},
+			FeatureId: 1,
+			Content: dto.Content{
 				Title: "a", Text: "b", Url: "c",
 			},
-			true,
-			ti.Format(time.RFC3339),
-			ti.Format(time.RFC3339),
+			IsActive:  true,
+			CreatedAt: ti.Format(time.RFC3339),
+			UpdatedAt: ti.Format(time.RFC3339),
+			Status:    dto.StatusPublished,
 		},
 		{
-			[]int64{2, 3},
-			2,
-			dto.Content{
+			Tags:      []int64{2, 3},
+			FeatureId: 2,
+			Content: dto.Content{
 				Title: "a", Text: "b", Url: "c",
 			},
-			true,
-			ti.Format(time.RFC3339),
-			ti.Format(time.RFC3339),
+			IsActive:  true,
+			CreatedAt: ti.Format(time.RFC3339),
+			UpdatedAt: ti.Format(time.RFC3339),
+			Status:    dto.StatusPublished,
 		},
 		{
-			[]int64{3},
-			3,
-			dto.Content{
+			Tags:      []int64{3},
+			FeatureId: 3,
+			Content: dto.Content{
 				Title: "a", Text: "b", Url: "c",
 			},
-			false,
-			ti.Format(time.RFC3339),
-			ti.Format(time.RFC3339),
+			IsActive:  false,
+			CreatedAt: ti.Format(time.RFC3339),
+			UpdatedAt: ti.Format(time.RFC3339),
+			Status:    dto.StatusPublished,
 		},
 	}
 
@@ -216,14 +225,15 @@ func TestGetBannersWithParams_ShouldReturnGivenValues(t *testing.T) {
 	ti, _ := time.Parse(time.RFC3339, "2024-04-12T09:23:51.447097Z")
 	examples := []dto.Banner{
 		{
-			[]int64{2, 3},
-			2,
-			dto.Content{
+			Tags:      []int64{2, 3},
+			FeatureId: 2,
+			Content: dto.Content{
 				Title: "a", Text: "b", Url: "c",
 			},
-			true,
-			ti.Format(time.RFC3339),
-			ti.Format(time.RFC3339),
+			IsActive:  true,
+			CreatedAt: ti.Format(time.RFC3339),
+			UpdatedAt: ti.Format(time.RFC3339),
+			Status:    dto.StatusPublished,
 		},
 	}
 
@@ -239,6 +249,321 @@ func TestGetBannersWithParams_ShouldReturnGivenValues(t *testing.T) {
 	}
 }
 
+func TestGetBannersWithInvalidLimit_ShouldThrow400(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/banner?limit=0", nil)
+	token, _ := server.CreateJWT("admin")
+	req.Header.Set("Token", token)
+	router.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusBadRequest, recorder.Result().StatusCode)
+}
+
+func TestGetBannersWithCursor_ShouldReturnNextPage(t *testing.T) {
+	token, _ := server.CreateJWT("admin")
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/banner?tag_id=2&limit=1&sort=feature_id&order=desc", nil)
+	req.Header.Set("Token", token)
+	router.ServeHTTP(recorder, req)
+	assert.Equal(t, "2", recorder.Header().Get(server.TotalCountHeader))
+	var first []dto.Banner
+	json.NewDecoder(recorder.Result().Body).Decode(&first)
+	cursor := recorder.Header().Get(server.NextCursorHeader)
+	assert.NotEmpty(t, cursor)
+
+	recorder = httptest.NewRecorder()
+	req = httptest.NewRequest("GET", "/banner?tag_id=2&limit=1&sort=feature_id&order=desc&cursor="+cursor, nil)
+	req.Header.Set("Token", token)
+	router.ServeHTTP(recorder, req)
+	var second []dto.Banner
+	json.NewDecoder(recorder.Result().Body).Decode(&second)
+	if assert.Len(t, first, 1) && assert.Len(t, second, 1) {
+		assert.Equal(t, int64(2), first[0].FeatureId)
+		assert.Equal(t, int64(1), second[0].FeatureId)
+	}
+}
+
+func TestGetBannersWithFilters_ShouldReturnMatching(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/banner?tag_id=1&tag_id=3&is_active=true&created_to=2024-04-13T00:00:00Z", nil)
+	token, _ := server.CreateJWT("admin")
+	req.Header.Set("Token", token)
+	router.ServeHTTP(recorder, req)
+	var banners []dto.Banner
+	json.NewDecoder(recorder.Result().Body).Decode(&banners)
+	if assert.Len(t, banners, 2) {
+		assert.Equal(t, int64(1), banners[0].FeatureId)
+		assert.Equal(t, int64(2), banners[1].FeatureId)
+	}
+}
+
+func TestExportImportDryRun_ShouldRoundTrip(t *testing.T) {
+	token, _ := server.CreateJWT("admin")
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/banner/export?format=ndjson&tag_id=2", nil)
+	req.Header.Set("Token", token)
+	router.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusOK, recorder.Result().StatusCode)
+	exported := recorder.Body.String()
+
+	recorder = httptest.NewRecorder()
+	req = httptest.NewRequest("POST", "/banner/import?format=ndjson&dry_run=true", bytes.NewBufferString(exported+"{broken\n"))
+	req.Header.Set("Token", token)
+	router.ServeHTTP(recorder, req)
+	var report dto.ImportReport
+	json.NewDecoder(recorder.Result().Body).Decode(&report)
+	assert.True(t, report.DryRun)
+	assert.Equal(t, 2, report.Updated)
+	if assert.Len(t, report.Errors, 1) {
+		assert.Equal(t, 3, report.Errors[0].Line)
+	}
+}
+
+func TestBannerWorkflow_ShouldPublishAfterReview(t *testing.T) {
+	author, _ := server.CreateUserJWT("alice", "admin")
+	reviewer, _ := server.CreateUserJWT("bob", "admin")
+	user, _ := server.CreateJWT("user")
+	do := func(method, target, token string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(method, target, bytes.NewBufferString(`{"comment": "ok"}`))
+		req.Header.Set("Token", token)
+		router.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	body, _ := json.Marshal(dto.Banner{
+		Tags:      []int64{3},
+		FeatureId: 1,
+		Content:   dto.Content{Title: "w", Text: "w", Url: "w"},
+		IsActive:  true,
+		CreatedAt: time.Now().Format(time.RFC3339),
+		UpdatedAt: time.Now().Format(time.RFC3339),
+	})
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/banner", bytes.NewBuffer(body))
+	req.Header.Set("Token", author)
+	router.ServeHTTP(recorder, req)
+	var created struct {
+		BannerID int64 `json:"banner_id"`
+	}
+	json.NewDecoder(recorder.Result().Body).Decode(&created)
+	banner := fmt.Sprintf("/banner/%d", created.BannerID)
+
+	assert.Equal(t, http.StatusNotFound, do("GET", "/user_banner?feature_id=1&tag_id=3&use_last_revision=true", user).Code)
+	assert.Equal(t, http.StatusConflict, do("POST", banner+"/publish", author).Code)
+	assert.Equal(t, http.StatusOK, do("POST", banner+"/submit", author).Code)
+	assert.Equal(t, http.StatusConflict, do("POST", banner+"/approve", author).Code)
+	assert.Equal(t, http.StatusConflict, do("POST", banner+"/withdraw", reviewer).Code, "only published banners are withdrawn")
+	assert.Equal(t, http.StatusOK, do("POST", banner+"/approve", reviewer).Code)
+	assert.Equal(t, http.StatusOK, do("POST", banner+"/publish", author).Code)
+	assert.Equal(t, http.StatusConflict, do("POST", banner+"/reject", reviewer).Code, "only banners in review are rejected")
+	assert.Equal(t, http.StatusOK, do("GET", "/user_banner?feature_id=1&tag_id=3&use_last_revision=true", user).Code)
+
+	var reviews []dto.BannerReview
+	json.NewDecoder(do("GET", banner+"/reviews", author).Result().Body).Decode(&reviews)
+	if assert.Len(t, reviews, 3) {
+		assert.Equal(t, "bob", reviews[1].Reviewer)
+		assert.Equal(t, dto.StatusApproved, reviews[1].ToStatus)
+	}
+
+	// an edit has to be reviewed again before users see it
+	recorder = httptest.NewRecorder()
+	req = httptest.NewRequest("PATCH", banner, bytes.NewBuffer(body))
+	req.Header.Set("Token", author)
+	router.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, http.StatusNotFound, do("GET", "/user_banner?feature_id=1&tag_id=3&use_last_revision=true", user).Code)
+	assert.Equal(t, http.StatusOK, do("POST", banner+"/submit", author).Code)
+}
+
+func TestAudit_ShouldRecordBannerUpdate(t *testing.T) {
+	token, _ := server.CreateUserJWT("carol", "admin")
+	body, _ := json.Marshal(dto.Banner{
+		Tags:      []int64{1, 2},
+		FeatureId: 1,
+		Content:   dto.Content{Title: "audited", Text: "b", Url: "c"},
+		IsActive:  true,
+		CreatedAt: time.Now().Format(time.RFC3339),
+		UpdatedAt: time.Now().Format(time.RFC3339),
+	})
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest("PATCH", "/banner/1", bytes.NewBuffer(body))
+	req.Header.Set("Token", token)
+	req.Header.Set(echo.HeaderXRequestID, "audit-test")
+	router.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	req = httptest.NewRequest("GET", "/audit?actor=carol&target_type=banner&target_id=1", nil)
+	req.Header.Set("Token", token)
+	router.ServeHTTP(recorder, req)
+	var entries []dto.AuditEntry
+	json.NewDecoder(recorder.Result().Body).Decode(&entries)
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, server.AuditActionUpdate, entries[0].Action)
+		assert.Equal(t, "audit-test", entries[0].RequestId)
+		assert.Contains(t, entries[0].Diff, "content")
+		assert.NotContains(t, entries[0].Diff, "feature_id")
+	}
+}
+
+// serve serves a request of the bearer of token.
+func serve(method, target, token, body string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(method, target, bytes.NewBufferString(body))
+	req.Header.Set("Token", token)
+	router.ServeHTTP(recorder, req)
+	return recorder
+}
+
+// newTenantAdmin creates a tenant with an admin called username, and returns
+// the tenant and the token the admin logs in with.
+func newTenantAdmin(t *testing.T, username string) (int64, string) {
+	superAdmin, _ := server.CreateUserJWT("root", database.SuperAdminRole)
+	recorder := serve("POST", "/tenants", superAdmin, fmt.Sprintf(`{"name": "tenant-%s"}`, username))
+	assert.Equal(t, http.StatusCreated, recorder.Code)
+	var tenant struct {
+		TenantID int64 `json:"tenant_id"`
+	}
+	json.NewDecoder(recorder.Result().Body).Decode(&tenant)
+	credentials := fmt.Sprintf(`{"username": %q, "password": "secret"}`, username)
+	assert.Equal(t, http.StatusCreated, serve("POST", fmt.Sprintf("/tenants/%d/admins", tenant.TenantID), superAdmin, credentials).Code)
+
+	recorder = httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/login", nil)
+	req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(username+":secret")))
+	req.Header.Set(server.TenantHeader, fmt.Sprint(tenant.TenantID))
+	router.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	var login struct {
+		Token string `json:"token"`
+	}
+	json.NewDecoder(recorder.Result().Body).Decode(&login)
+	return tenant.TenantID, login.Token
+}
+
+func TestTenants_ShouldNotReachOtherTenants(t *testing.T) {
+	username := fmt.Sprintf("tenant-admin-%d", time.Now().UnixNano())
+	_, token := newTenantAdmin(t, username)
+	// the same username in the default tenant does not clash
+	credentials := fmt.Sprintf(`{"username": %q, "password": "secret"}`, username)
+	assert.Equal(t, http.StatusCreated, serve("POST", "/signup", "", credentials).Code)
+
+	// banner 1 belongs to the default tenant
+	var banners []dto.Banner
+	json.NewDecoder(serve("GET", "/banner", token, "").Result().Body).Decode(&banners)
+	assert.Empty(t, banners)
+	assert.Equal(t, http.StatusNotFound, serve("GET", "/user_banner?feature_id=1&tag_id=1&use_last_revision=true", token, "").Code)
+	assert.Equal(t, http.StatusNotFound, serve("GET", "/banner/1/reviews", token, "").Code)
+	assert.Equal(t, http.StatusNotFound, serve("DELETE", "/banner/1", token, "").Code)
+	body, _ := json.Marshal(dto.Banner{
+		Tags:      []int64{1},
+		FeatureId: 1,
+		Content:   dto.Content{Title: "x", Text: "x", Url: "x"},
+		CreatedAt: time.Now().Format(time.RFC3339),
+		UpdatedAt: time.Now().Format(time.RFC3339),
+	})
+	assert.Equal(t, http.StatusNotFound, serve("PATCH", "/banner/1", token, string(body)).Code)
+	assert.NotEqual(t, http.StatusCreated, serve("POST", "/banner", token, string(body)).Code, "feature 1 is not the tenant's")
+
+	admin, _ := server.CreateUserJWT("dave", "admin")
+	assert.Equal(t, http.StatusOK, serve("GET", "/banner/1/reviews", admin, "").Code, "banner 1 is still there")
+}
+
+func TestTenants_ShouldServeBannersOfTheirOwnFeaturesAndTags(t *testing.T) {
+	_, token := newTenantAdmin(t, fmt.Sprintf("tenant-admin-%d", time.Now().UnixNano()))
+	var feature struct {
+		FeatureID int64 `json:"feature_id"`
+	}
+	recorder := serve("POST", "/features", token, `{"description": "checkout"}`)
+	assert.Equal(t, http.StatusCreated, recorder.Code)
+	json.NewDecoder(recorder.Result().Body).Decode(&feature)
+	var tag struct {
+		TagID int64 `json:"tag_id"`
+	}
+	recorder = serve("POST", "/tags", token, `{"description": "new users"}`)
+	assert.Equal(t, http.StatusCreated, recorder.Code)
+	json.NewDecoder(recorder.Result().Body).Decode(&tag)
+
+	body, _ := json.Marshal(dto.Banner{
+		Tags:      []int64{tag.TagID},
+		FeatureId: feature.FeatureID,
+		Content:   dto.Content{Title: "welcome", Text: "x", Url: "x"},
+		IsActive:  true,
+		CreatedAt: time.Now().Format(time.RFC3339),
+		UpdatedAt: time.Now().Format(time.RFC3339),
+	})
+	assert.Equal(t, http.StatusCreated, serve("POST", "/banner", token, string(body)).Code)
+	var banners []dto.Banner
+	json.NewDecoder(serve("GET", fmt.Sprintf("/banner?feature_id=%d", feature.FeatureID), token, "").Result().Body).Decode(&banners)
+	if assert.Len(t, banners, 1) {
+		assert.Equal(t, "welcome", banners[0].Content.Title)
+		assert.Equal(t, []int64{tag.TagID}, banners[0].Tags)
+	}
+
+	admin, _ := server.CreateUserJWT("dave", "admin")
+	var features []dto.Feature
+	json.NewDecoder(serve("GET", "/features", admin, "").Result().Body).Decode(&features)
+	assert.NotContains(t, features, dto.Feature{Id: feature.FeatureID, Description: "checkout"}, "features are per tenant")
+}
+
+func TestAPIKeys_ShouldActForTheirTenantUntilRevoked(t *testing.T) {
+	_, token := newTenantAdmin(t, fmt.Sprintf("tenant-admin-%d", time.Now().UnixNano()))
+	recorder := serve("POST", "/api_keys", token, `{"name": "importer", "role": "admin"}`)
+	assert.Equal(t, http.StatusCreated, recorder.Code)
+	var key dto.APIKey
+	json.NewDecoder(recorder.Result().Body).Decode(&key)
+	withKey := func(method, target, body string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(method, target, bytes.NewBufferString(body))
+		req.Header.Set(server.APIKeyHeader, key.Key)
+		router.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	assert.Equal(t, http.StatusCreated, withKey("POST", "/features", `{"description": "imported"}`).Code)
+	var features []dto.Feature
+	json.NewDecoder(serve("GET", "/features", token, "").Result().Body).Decode(&features)
+	if assert.Len(t, features, 1, "the key acts for its tenant") {
+		assert.Equal(t, "imported", features[0].Description)
+	}
+	var keys []dto.APIKey
+	json.NewDecoder(serve("GET", "/api_keys", token, "").Result().Body).Decode(&keys)
+	if assert.Len(t, keys, 1) {
+		assert.Empty(t, keys[0].Key, "keys are only shown when issued")
+	}
+
+	assert.Equal(t, http.StatusNoContent, serve("DELETE", fmt.Sprintf("/api_keys/%d", key.Id), token, "").Code)
+	assert.Equal(t, http.StatusUnauthorized, withKey("GET", "/features", "").Code)
+}
+
+func TestSuperAdmin_ShouldBeCreatedOrPromoted(t *testing.T) {
+	assert.Error(t, runSuperAdmin(&config.Config{}, nil, strings.NewReader("secret\n")))
+	assert.Error(t, runSuperAdmin(&config.Config{}, []string{"root"}, strings.NewReader("\n")))
+
+	roleOf := func(username string) string {
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/login", nil)
+		req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(username+":secret")))
+		router.ServeHTTP(recorder, req)
+		var login struct {
+			Token string `json:"token"`
+		}
+		json.NewDecoder(recorder.Result().Body).Decode(&login)
+		claims, _ := server.ParseToken(login.Token)
+		return claims.Role
+	}
+	suffix := time.Now().UnixNano()
+	created := fmt.Sprintf("root-%d", suffix)
+	assert.NoError(t, saveSuperAdmin(context.Background(), db.(*postgres.Database), created, "secret", bcrypt.MinCost))
+	assert.Equal(t, database.SuperAdminRole, roleOf(created))
+
+	promoted := fmt.Sprintf("promoted-%d", suffix)
+	assert.Equal(t, http.StatusCreated, serve("POST", "/signup", "", fmt.Sprintf(`{"username": %q, "password": "secret"}`, promoted)).Code)
+	assert.Equal(t, database.UserRole, roleOf(promoted))
+	assert.NoError(t, saveSuperAdmin(context.Background(), db.(*postgres.Database), promoted, "secret", bcrypt.MinCost))
+	assert.Equal(t, database.SuperAdminRole, roleOf(promoted))
+}
+
 func setup() {
 	var err error
 	configPath := os.Getenv("TEST_CONFIG_PATH")
@@ -249,11 +574,15 @@ func setup() {
 	if err != nil {
 		log.Fatal(err)
 	}
-	db, err = postgres.New("test_database", cfg.User, cfg.Password, cfg.Host, cfg.Port)
+	pg, err := postgres.New("test_database", cfg.User, cfg.Password, cfg.Host, cfg.Port)
 	if err != nil {
 		panic(err)
 	}
-	err = db.RunMigrations(TableCreationDDL, TableFillDDL)
+	if _, err = pg.MigrateUp(); err != nil {
+		panic(err)
+	}
+	db = pg
+	err = db.RunMigrations(context.Background(), TableFillDDL)
 	if err != nil {
 		panic(err)
 	}
@@ -261,13 +590,13 @@ func setup() {
 	e := echo.New()
 	done = make(chan bool)
 	cache := server.NewMemoryCache(db, 2.5, 1, done)
-	ConfigureServer(db, cache, e, server.VerifyJWT)
+	ConfigureServer(db, cache, *cfg, e, middleware.RequestID(), server.VerifyJWT)
 	router = e
 
 }
 
 func teardown() {
-	err := db.RunMigrations(TableDeletionDDL)
+	err := db.RunMigrations(context.Background(), TableDeletionDDL)
 	if err != nil {
 		panic(err)
 	}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"github.com/Paincake/avito-tech/internal/openapi"
+	"github.com/Paincake/avito-tech/internal/server"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// echoPathToOpenAPI turns /banner/:id into /banner/{id}.
+func echoPathToOpenAPI(path string) string {
+	parts := strings.Split(path, "/")
+	for i, part := range parts {
+		if strings.HasPrefix(part, ":") {
+			parts[i] = "{" + part[1:] + "}"
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+func TestOpenAPI_ShouldDescribeEveryRoute(t *testing.T) {
+	doc := openapi.MustLoad()
+	registered := make(map[string]bool)
+	for _, route := range router.Routes() {
+		path := echoPathToOpenAPI(route.Path)
+		registered[route.Method+" "+path] = true
+		item := doc.Paths.Find(path)
+		if assert.NotNil(t, item, "route %s %s is not in the spec", route.Method, route.Path) {
+			assert.NotNil(t, item.GetOperation(route.Method), "route %s %s is not in the spec", route.Method, route.Path)
+		}
+	}
+	for path, item := range doc.Paths.Map() {
+		for method := range item.Operations() {
+			assert.True(t, registered[method+" "+path], "spec operation %s %s is not served", method, path)
+		}
+	}
+}
+
+func init() {
+	for _, contentType := range []string{"text/html", "text/csv", "application/x-ndjson"} {
+		openapi3filter.RegisterBodyDecoder(contentType, openapi3filter.FileBodyDecoder)
+	}
+}
+
+// serveValidated serves req and fails the test unless the response matches
+// the spec.
+func serveValidated(t *testing.T, doc *openapi3.T, req *http.Request) *httptest.ResponseRecorder {
+	t.Helper()
+	specRouter, err := gorillamux.NewRouter(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	route, pathParams, err := specRouter.FindRoute(req)
+	if err != nil {
+		t.Fatalf("%s %s: %s", req.Method, req.URL, err)
+	}
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+	response := recorder.Result()
+	body, _ := io.ReadAll(response.Body)
+	err = openapi3filter.ValidateResponse(context.Background(), &openapi3filter.ResponseValidationInput{
+		RequestValidationInput: &openapi3filter.RequestValidationInput{
+			Request:    req,
+			PathParams: pathParams,
+			Route:      route,
+		},
+		Status:  response.StatusCode,
+		Header:  response.Header,
+		Body:    io.NopCloser(bytes.NewReader(body)),
+		Options: &openapi3filter.Options{IncludeResponseStatus: true},
+	})
+	assert.NoError(t, err, "%s %s -> %d %s", req.Method, req.URL, response.StatusCode, body)
+	return recorder
+}
+
+func TestOpenAPI_ResponsesShouldMatchSpec(t *testing.T) {
+	doc := openapi.MustLoad()
+	admin, _ := server.CreateUserJWT("dave", "admin")
+	user, _ := server.CreateJWT("user")
+	superAdmin, _ := server.CreateUserJWT("root", "superadmin")
+	requests := []struct {
+		method, target, token, body string
+	}{
+		{"GET", "/banner", admin, ""},
+		{"GET", "/banner?limit=1&sort=updated_at&order=desc", admin, ""},
+		{"GET", "/banner?limit=0", admin, ""},
+		{"GET", "/banner", user, ""},
+		{"POST", "/banner", admin, `{"tag_ids": [5], "feature_id": 3, "content": {"title": "t", "text": "t", "url": "u"}, "is_active": true, "created_at": "2024-04-12T09:23:51Z", "updated_at": "2024-04-12T09:23:51Z"}`},
+		{"POST", "/banner", admin, `{"feature_id": 3}`},
+		{"PATCH", "/banner/100000", admin, `{"tag_ids": [5], "feature_id": 3, "content": {"title": "t", "text": "t", "url": "u"}, "is_active": true, "created_at": "2024-04-12T09:23:51Z", "updated_at": "2024-04-12T09:23:51Z"}`},
+		{"GET", "/banner/export?format=csv", admin, ""},
+		{"POST", "/banner/import?format=ndjson&dry_run=true", admin, "{broken\n"},
+		{"POST", "/banner/1/approve", admin, ""},
+		{"GET", "/banner/1/reviews", admin, ""},
+		{"GET", "/audit", admin, ""},
+		{"GET", "/changes?since=0&limit=5", admin, ""},
+		{"GET", "/changes?since=-1", admin, ""},
+		{"GET", "/cache/stats", superAdmin, ""},
+		{"GET", "/cache/stats", admin, ""},
+		{"DELETE", "/cache?feature_id=1&tag_id=1", admin, ""},
+		{"DELETE", "/cache?feature_id=0", admin, ""},
+		{"GET", "/tenants", superAdmin, ""},
+		{"GET", "/tenants", admin, ""},
+		{"POST", "/tenants", superAdmin, `{"name": ""}`},
+		{"POST", "/tenants/100000/admins", superAdmin, `{"username": "erin", "password": "secret"}`},
+		{"GET", "/webhooks", admin, ""},
+		{"POST", "/webhooks", admin, `{"url": "ftp://cdn", "secret": "s", "events": ["created"]}`},
+		{"DELETE", "/webhooks/100000", admin, ""},
+		{"GET", "/webhooks/100000/deliveries", admin, ""},
+		{"GET", "/user_banner?feature_id=1&tag_id=1", user, ""},
+		{"GET", "/user_banner?feature_id=1000&tag_id=1000&use_last_revision=true", user, ""},
+		{"GET", "/user_banner", user, ""},
+		{"POST", "/user_banner/batch", user, `{"items": [{"feature_id": 1, "tag_id": 1}, {"feature_id": 1000, "tag_id": 1000, "use_last_revision": true}]}`},
+		{"POST", "/user_banner/batch", user, `{"items": []}`},
+		{"POST", "/login", "", ""},
+		{"POST", "/signup", "", `{"username": ""}`},
+		{"GET", "/openapi.json", "", ""},
+		{"GET", "/docs", "", ""},
+	}
+	for _, r := range requests {
+		req := httptest.NewRequest(r.method, r.target, strings.NewReader(r.body))
+		if r.token != "" {
+			req.Header.Set("Token", r.token)
+		}
+		serveValidated(t, doc, req)
+	}
+
+	req := httptest.NewRequest("POST", "/login", nil)
+	req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte("nobody")))
+	serveValidated(t, doc, req)
+}
@@ -2,33 +2,173 @@ package config
 
 import (
 	"errors"
+	"fmt"
 	"github.com/ilyakaznacheev/cleanenv"
+	"golang.org/x/crypto/bcrypt"
 	"os"
+	"strings"
+	"time"
 )
 
+var logLevels = map[string]bool{"debug": true, "info": true, "warn": true, "error": true}
+
+var rateLimitBackends = map[string]bool{"memory": true, "postgres": true}
+
 type Config struct {
-	Env                      string  `env:"ENV" env_default:"local"`
-	Port                     string  `env:"DB_PORT" env_default:"5432"`
-	Host                     string  `env:"DB_HOST" env_default:"localhost"`
-	Name                     string  `env:"DB_NAME" env_default:"postgres"`
-	User                     string  `env:"DB_USER" env_default:"user"`
-	Password                 string  `env:"DB_PASSWORD" env_default:"password"`
-	CacheSchedulerRate       int64   `env:"SCHEDULER_RATE_MINUTE" env_default:"1"`
-	CacheKeyInvalidationTime float64 `env:"CACHE_KEY_INVALIDATION_MINUTES" env_default:"5"`
+	Env                      string        `env:"ENV" env-default:"local"`
+	Port                     string        `env:"DB_PORT" env-default:"5432"`
+	Host                     string        `env:"DB_HOST" env-default:"localhost"`
+	Name                     string        `env:"DB_NAME" env-default:"postgres"`
+	User                     string        `env:"DB_USER" env-default:"user"`
+	Password                 string        `env:"DB_PASSWORD" env-default:"password"`
+	CacheSchedulerRate       int64         `env:"SCHEDULER_RATE_MINUTE" env-default:"1"`
+	CacheKeyInvalidationTime float64       `env:"CACHE_KEY_INVALIDATION_MINUTES" env-default:"5"`
+	CacheMaxStaleness        float64       `env:"CACHE_MAX_STALENESS_MINUTES" env-default:"10"`
+	CacheRetention           float64       `env:"CACHE_RETENTION_MINUTES" env-default:"1440"`
+	CacheMaxEntries          int           `env:"CACHE_MAX_ENTRIES" env-default:"100000"`
+	CacheMaxBytes            int64         `env:"CACHE_MAX_BYTES" env-default:"67108864"`
+	CacheWarmUp              bool          `env:"CACHE_WARM_UP" env-default:"false"`
+	CacheWarmUpTimeout       time.Duration `env:"CACHE_WARM_UP_TIMEOUT" env-default:"30s"`
+	AutoMigrate              bool          `env:"AUTO_MIGRATE" env-default:"false"`
+	TracingEndpoint          string        `env:"OTLP_TRACES_ENDPOINT" env-default:""`
+	DBConnectAttempts        int           `env:"DB_CONNECT_ATTEMPTS" env-default:"5"`
+	DBConnectBackoff         time.Duration `env:"DB_CONNECT_BACKOFF" env-default:"1s"`
+	DBMaxOpenConns           int           `env:"DB_MAX_OPEN_CONNS" env-default:"25"`
+	DBMaxIdleConns           int           `env:"DB_MAX_IDLE_CONNS" env-default:"5"`
+	DBConnMaxLifetime        time.Duration `env:"DB_CONN_MAX_LIFETIME" env-default:"30m"`
+	DBBreakerThreshold       int           `env:"DB_BREAKER_THRESHOLD" env-default:"5"`
+	DBBreakerCooldown        time.Duration `env:"DB_BREAKER_COOLDOWN" env-default:"10s"`
+	HealthCheckTimeout       time.Duration `env:"HEALTH_CHECK_TIMEOUT" env-default:"2s"`
+	LogLevel                 string        `env:"LOG_LEVEL" env-default:"info"`
+	CORSAllowOrigins         []string      `env:"CORS_ALLOW_ORIGINS" env-default:""`
+	ConfigReloadInterval     time.Duration `env:"CONFIG_RELOAD_INTERVAL" env-default:"0s"`
+
+	HTTPAddress      string        `env:"HTTP_ADDRESS" env-default:":8080"`
+	GRPCAddress      string        `env:"GRPC_ADDRESS" env-default:":9090"`
+	HTTPReadTimeout  time.Duration `env:"HTTP_READ_TIMEOUT" env-default:"10s"`
+	HTTPWriteTimeout time.Duration `env:"HTTP_WRITE_TIMEOUT" env-default:"150s"`
+	HTTPIdleTimeout  time.Duration `env:"HTTP_IDLE_TIMEOUT" env-default:"60s"`
+	ShutdownTimeout  time.Duration `env:"SHUTDOWN_TIMEOUT" env-default:"10s"`
+	TLSCertFile      string        `env:"TLS_CERT_FILE" env-default:""`
+	TLSKeyFile       string        `env:"TLS_KEY_FILE" env-default:""`
+
+	RequestTimeout time.Duration            `env:"REQUEST_TIMEOUT" env-default:"5s"`
+	RouteTimeouts  map[string]time.Duration `env:"ROUTE_TIMEOUTS" env-default:"ExportBanners:2m,ImportBanners:2m"`
+
+	JWTSecretKey     string        `env:"JWT_SECRET_KEY"`
+	JWTTTL           time.Duration `env:"JWT_TTL" env-default:"24h"`
+	BcryptCost       int           `env:"BCRYPT_COST" env-default:"8"`
+	DefaultPageLimit int           `env:"DEFAULT_PAGE_LIMIT" env-default:"50"`
+	MaxPageLimit     int           `env:"MAX_PAGE_LIMIT" env-default:"100"`
+
+	StreamHeartbeatInterval time.Duration `env:"STREAM_HEARTBEAT_INTERVAL" env-default:"15s"`
+	StreamHistory           int           `env:"STREAM_HISTORY" env-default:"1024"`
+	StreamClientBuffer      int           `env:"STREAM_CLIENT_BUFFER" env-default:"64"`
+
+	WebhookPollInterval time.Duration `env:"WEBHOOK_POLL_INTERVAL" env-default:"1s"`
+	WebhookTimeout      time.Duration `env:"WEBHOOK_TIMEOUT" env-default:"10s"`
+	WebhookMaxAttempts  int           `env:"WEBHOOK_MAX_ATTEMPTS" env-default:"10"`
+	WebhookBackoff      time.Duration `env:"WEBHOOK_BACKOFF" env-default:"5s"`
+	WebhookMaxBackoff   time.Duration `env:"WEBHOOK_MAX_BACKOFF" env-default:"1h"`
+	WebhookBatch        int           `env:"WEBHOOK_BATCH" env-default:"20"`
+
+	RateLimitBackend     string  `env:"RATE_LIMIT_BACKEND" env-default:"memory"`
+	RateLimitLatestRate  float64 `env:"RATE_LIMIT_LATEST_RATE" env-default:"5"`
+	RateLimitLatestBurst int     `env:"RATE_LIMIT_LATEST_BURST" env-default:"10"`
+	RateLimitAdminRate   float64 `env:"RATE_LIMIT_ADMIN_RATE" env-default:"10"`
+	RateLimitAdminBurst  int     `env:"RATE_LIMIT_ADMIN_BURST" env-default:"20"`
+	RateLimitLoginRate   float64 `env:"RATE_LIMIT_LOGIN_RATE" env-default:"0.2"`
+	RateLimitLoginBurst  int     `env:"RATE_LIMIT_LOGIN_BURST" env-default:"5"`
 }
 
+// MustLoad reads the config file at configPath, overridden by environment
+// variables, or the environment alone when configPath is empty, and
+// validates the result.
 func MustLoad(configPath string) (*Config, error) {
+	var cfg Config
 	if configPath == "" {
-		return &Config{}, errors.New("not a valid config file path")
+		if err := cleanenv.ReadEnv(&cfg); err != nil {
+			return &Config{}, fmt.Errorf("error reading config from environment: %s", err)
+		}
+	} else {
+		if _, err := os.Stat(configPath); err != nil {
+			return &Config{}, fmt.Errorf("error reading config file: %s", err)
+		}
+		if err := cleanenv.ReadConfig(configPath, &cfg); err != nil {
+			return &Config{}, fmt.Errorf("error reading config file %s: %s", configPath, err)
+		}
 	}
-	if _, err := os.Stat(configPath); err != nil {
-		return &Config{}, errors.New("error reading config file")
+	if err := cfg.Validate(); err != nil {
+		return &Config{}, fmt.Errorf("invalid config: %w", err)
 	}
+	return &cfg, nil
+}
 
-	var cfg Config
-	err := cleanenv.ReadConfig(configPath, &cfg)
-	if err != nil {
-		return &Config{}, errors.New("error reading config file")
+// Validate reports every invalid setting at once, naming the environment
+// variable to fix.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
 	}
-	return &cfg, nil
+	check(c.HTTPAddress != "", "HTTP_ADDRESS must not be empty")
+	check(c.HTTPReadTimeout >= 0, "HTTP_READ_TIMEOUT must not be negative")
+	check(c.HTTPWriteTimeout >= 0, "HTTP_WRITE_TIMEOUT must not be negative")
+	check(c.HTTPIdleTimeout >= 0, "HTTP_IDLE_TIMEOUT must not be negative")
+	check(c.ShutdownTimeout > 0, "SHUTDOWN_TIMEOUT must be positive")
+	check((c.TLSCertFile == "") == (c.TLSKeyFile == ""), "TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	check(c.RequestTimeout >= 0, "REQUEST_TIMEOUT must not be negative")
+	for route, timeout := range c.RouteTimeouts {
+		check(timeout >= 0, "ROUTE_TIMEOUTS entry %s must not be negative", route)
+	}
+	check(c.DBConnectAttempts >= 1, "DB_CONNECT_ATTEMPTS must be at least 1")
+	check(c.DBConnectBackoff > 0, "DB_CONNECT_BACKOFF must be positive")
+	check(c.DBMaxOpenConns >= 0, "DB_MAX_OPEN_CONNS must not be negative")
+	check(c.DBMaxIdleConns >= 0, "DB_MAX_IDLE_CONNS must not be negative")
+	check(c.DBMaxOpenConns == 0 || c.DBMaxIdleConns <= c.DBMaxOpenConns,
+		"DB_MAX_IDLE_CONNS (%d) must not exceed DB_MAX_OPEN_CONNS (%d)", c.DBMaxIdleConns, c.DBMaxOpenConns)
+	check(c.DBConnMaxLifetime >= 0, "DB_CONN_MAX_LIFETIME must not be negative")
+	check(c.DBBreakerThreshold >= 0, "DB_BREAKER_THRESHOLD must not be negative")
+	check(c.DBBreakerCooldown > 0, "DB_BREAKER_COOLDOWN must be positive")
+	check(c.HealthCheckTimeout > 0, "HEALTH_CHECK_TIMEOUT must be positive")
+	check(c.CacheSchedulerRate > 0, "SCHEDULER_RATE_MINUTE must be positive")
+	check(c.CacheKeyInvalidationTime > 0, "CACHE_KEY_INVALIDATION_MINUTES must be positive")
+	check(c.CacheRetention >= 0, "CACHE_RETENTION_MINUTES must not be negative")
+	check(c.CacheMaxEntries >= 0, "CACHE_MAX_ENTRIES must not be negative")
+	check(c.CacheMaxBytes >= 0, "CACHE_MAX_BYTES must not be negative")
+	check(c.CacheWarmUpTimeout > 0, "CACHE_WARM_UP_TIMEOUT must be positive")
+	check(logLevels[strings.ToLower(c.LogLevel)], "LOG_LEVEL must be one of debug, info, warn, error")
+	check(c.ConfigReloadInterval >= 0, "CONFIG_RELOAD_INTERVAL must not be negative")
+	check(c.JWTSecretKey != "", "JWT_SECRET_KEY must be set")
+	check(c.JWTTTL >= 0, "JWT_TTL must not be negative")
+	check(c.BcryptCost >= bcrypt.MinCost && c.BcryptCost <= bcrypt.MaxCost,
+		"BCRYPT_COST must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+	check(c.MaxPageLimit >= 1, "MAX_PAGE_LIMIT must be at least 1")
+	check(c.DefaultPageLimit >= 1 && c.DefaultPageLimit <= c.MaxPageLimit,
+		"DEFAULT_PAGE_LIMIT must be between 1 and MAX_PAGE_LIMIT (%d)", c.MaxPageLimit)
+	check(c.StreamHeartbeatInterval > 0, "STREAM_HEARTBEAT_INTERVAL must be positive")
+	check(c.StreamHistory >= 0, "STREAM_HISTORY must not be negative")
+	check(c.StreamClientBuffer >= 1, "STREAM_CLIENT_BUFFER must be at least 1")
+	check(c.WebhookPollInterval > 0, "WEBHOOK_POLL_INTERVAL must be positive")
+	check(c.WebhookTimeout > 0, "WEBHOOK_TIMEOUT must be positive")
+	check(c.WebhookMaxAttempts >= 1, "WEBHOOK_MAX_ATTEMPTS must be at least 1")
+	check(c.WebhookBackoff > 0, "WEBHOOK_BACKOFF must be positive")
+	check(c.WebhookMaxBackoff >= c.WebhookBackoff, "WEBHOOK_MAX_BACKOFF must not be less than WEBHOOK_BACKOFF")
+	check(c.WebhookBatch >= 1, "WEBHOOK_BATCH must be at least 1")
+	check(rateLimitBackends[c.RateLimitBackend], "RATE_LIMIT_BACKEND must be memory or postgres")
+	for _, limit := range []struct {
+		name  string
+		rate  float64
+		burst int
+	}{
+		{"LATEST", c.RateLimitLatestRate, c.RateLimitLatestBurst},
+		{"ADMIN", c.RateLimitAdminRate, c.RateLimitAdminBurst},
+		{"LOGIN", c.RateLimitLoginRate, c.RateLimitLoginBurst},
+	} {
+		check(limit.rate >= 0, "RATE_LIMIT_%s_RATE must not be negative", limit.name)
+		check(limit.rate == 0 || limit.burst >= 1, "RATE_LIMIT_%s_BURST must be at least 1", limit.name)
+	}
+	return errors.Join(errs...)
 }
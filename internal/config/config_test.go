@@ -0,0 +1,47 @@
+package config
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestMustLoad_ShouldReadEnvironmentWithoutFile(t *testing.T) {
+	t.Setenv("JWT_SECRET_KEY", "secret")
+	t.Setenv("HTTP_ADDRESS", ":9090")
+	t.Setenv("ROUTE_TIMEOUTS", "GetUserBanner:300ms")
+	cfg, err := MustLoad("")
+	assert.NoError(t, err)
+	assert.Equal(t, ":9090", cfg.HTTPAddress)
+	assert.Equal(t, 8, cfg.BcryptCost)
+	assert.Equal(t, 50, cfg.DefaultPageLimit)
+	assert.Equal(t, "300ms", cfg.RouteTimeouts["GetUserBanner"].String())
+}
+
+func TestMustLoad_ShouldAcceptCacheBoundsBelowExpiration(t *testing.T) {
+	t.Setenv("JWT_SECRET_KEY", "secret")
+	t.Setenv("CACHE_KEY_INVALIDATION_MINUTES", "5")
+	t.Setenv("CACHE_MAX_STALENESS_MINUTES", "0")
+	t.Setenv("CACHE_RETENTION_MINUTES", "1")
+	cfg, err := MustLoad("")
+	assert.NoError(t, err, "stale serving is disabled and retention clamped, not misconfigured")
+	assert.Equal(t, float64(0), cfg.CacheMaxStaleness)
+	assert.Equal(t, float64(1), cfg.CacheRetention)
+}
+
+func TestMustLoad_ShouldReportEveryInvalidSetting(t *testing.T) {
+	t.Setenv("JWT_SECRET_KEY", "")
+	t.Setenv("BCRYPT_COST", "2")
+	t.Setenv("DEFAULT_PAGE_LIMIT", "500")
+	t.Setenv("RATE_LIMIT_BACKEND", "redis")
+	t.Setenv("RATE_LIMIT_LOGIN_BURST", "0")
+	t.Setenv("WEBHOOK_MAX_BACKOFF", "1s")
+	t.Setenv("CACHE_RETENTION_MINUTES", "-1")
+	_, err := MustLoad("")
+	assert.ErrorContains(t, err, "JWT_SECRET_KEY must be set")
+	assert.ErrorContains(t, err, "BCRYPT_COST must be between 4 and 31")
+	assert.ErrorContains(t, err, "DEFAULT_PAGE_LIMIT must be between 1 and MAX_PAGE_LIMIT (100)")
+	assert.ErrorContains(t, err, "RATE_LIMIT_BACKEND must be memory or postgres")
+	assert.ErrorContains(t, err, "RATE_LIMIT_LOGIN_BURST must be at least 1")
+	assert.ErrorContains(t, err, "WEBHOOK_MAX_BACKOFF must not be less than WEBHOOK_BACKOFF")
+	assert.ErrorContains(t, err, "CACHE_RETENTION_MINUTES must not be negative")
+}
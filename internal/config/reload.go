@@ -0,0 +1,180 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// reloadable are the settings, by environment variable, that running
+// components pick up on reload. Changes to any other setting are reported
+// and ignored until the next restart.
+var reloadable = map[string]bool{
+	"CACHE_KEY_INVALIDATION_MINUTES": true,
+	"CACHE_MAX_STALENESS_MINUTES":    true,
+	"CACHE_RETENTION_MINUTES":        true,
+	"CACHE_MAX_ENTRIES":              true,
+	"CACHE_MAX_BYTES":                true,
+	"SCHEDULER_RATE_MINUTE":          true,
+	"LOG_LEVEL":                      true,
+	"CORS_ALLOW_ORIGINS":             true,
+	"RATE_LIMIT_LATEST_RATE":         true,
+	"RATE_LIMIT_LATEST_BURST":        true,
+	"RATE_LIMIT_ADMIN_RATE":          true,
+	"RATE_LIMIT_ADMIN_BURST":         true,
+	"RATE_LIMIT_LOGIN_RATE":          true,
+	"RATE_LIMIT_LOGIN_BURST":         true,
+}
+
+// secret settings are masked in logged diffs.
+var secret = map[string]bool{
+	"DB_PASSWORD":    true,
+	"JWT_SECRET_KEY": true,
+}
+
+// Change is a setting that differs between two configs.
+type Change struct {
+	Name       string
+	Before     any
+	After      any
+	Reloadable bool
+}
+
+func (c Change) String() string {
+	if secret[c.Name] {
+		return c.Name + ": changed"
+	}
+	return fmt.Sprintf("%s: %v -> %v", c.Name, c.Before, c.After)
+}
+
+// Diff lists the settings that differ between before and after.
+func Diff(before, after *Config) []Change {
+	var changes []Change
+	b, a := reflect.ValueOf(before).Elem(), reflect.ValueOf(after).Elem()
+	for i := 0; i < b.NumField(); i++ {
+		if reflect.DeepEqual(b.Field(i).Interface(), a.Field(i).Interface()) {
+			continue
+		}
+		name := b.Type().Field(i).Tag.Get("env")
+		changes = append(changes, Change{
+			Name:       name,
+			Before:     b.Field(i).Interface(),
+			After:      a.Field(i).Interface(),
+			Reloadable: reloadable[name],
+		})
+	}
+	return changes
+}
+
+// Reloader re-reads the config file and hands the reloadable settings of a
+// valid config to the registered components. An invalid config is rejected
+// and the current one is kept.
+type Reloader struct {
+	path    string
+	logger  *slog.Logger
+	mu      sync.Mutex
+	current *Config
+	apply   []func(cfg *Config)
+}
+
+func NewReloader(path string, cfg *Config, logger *slog.Logger) *Reloader {
+	return &Reloader{path: path, current: cfg, logger: logger}
+}
+
+// OnReload registers fn to be called with the new config after every
+// reload that changed a reloadable setting.
+func (r *Reloader) OnReload(fn func(cfg *Config)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.apply = append(r.apply, fn)
+}
+
+// Current returns the config in effect.
+func (r *Reloader) Current() *Config {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.current
+}
+
+// Reload reads the config and applies its reloadable settings. It returns
+// the changes found, including the ones that need a restart.
+func (r *Reloader) Reload() ([]Change, error) {
+	loaded, err := MustLoad(r.path)
+	if err != nil {
+		r.logger.Error("config reload rejected, keeping current config", slog.String("err", err.Error()))
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	changes := Diff(r.current, loaded)
+	next := *r.current
+	n, l := reflect.ValueOf(&next).Elem(), reflect.ValueOf(loaded).Elem()
+	applied := false
+	for _, change := range changes {
+		if !change.Reloadable {
+			r.logger.Warn("config change requires a restart", slog.String("change", change.String()))
+			continue
+		}
+		for i := 0; i < n.NumField(); i++ {
+			if n.Type().Field(i).Tag.Get("env") == change.Name {
+				n.Field(i).Set(l.Field(i))
+			}
+		}
+		r.logger.Info("config changed", slog.String("change", change.String()))
+		applied = true
+	}
+	if !applied {
+		r.logger.Info("config reloaded, nothing to apply")
+		return changes, nil
+	}
+	r.current = &next
+	for _, fn := range r.apply {
+		fn(r.current)
+	}
+	return changes, nil
+}
+
+// Watch reloads the config on SIGHUP and, with a positive interval, when
+// the modification time of the config file changes, until ctx is done.
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+	var poll <-chan time.Time
+	var modTime time.Time
+	if interval > 0 && r.path != "" {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		poll = ticker.C
+		modTime = r.modTime()
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangup:
+			r.logger.Info("SIGHUP received, reloading config")
+			_, _ = r.Reload()
+		case <-poll:
+			if current := r.modTime(); !current.Equal(modTime) {
+				modTime = current
+				r.logger.Info("config file changed, reloading config")
+				_, _ = r.Reload()
+			}
+		}
+	}
+}
+
+func (r *Reloader) modTime() time.Time {
+	info, err := os.Stat(r.path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
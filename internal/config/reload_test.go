@@ -0,0 +1,65 @@
+package config
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeConfig(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReloader_ShouldApplyReloadableSettingsOnly(t *testing.T) {
+	for _, name := range []string{"JWT_SECRET_KEY", "LOG_LEVEL", "HTTP_ADDRESS", "CACHE_KEY_INVALIDATION_MINUTES"} {
+		t.Setenv(name, "")
+		os.Unsetenv(name)
+	}
+	path := filepath.Join(t.TempDir(), "config.env")
+	writeConfig(t, path, "JWT_SECRET_KEY=secret\nLOG_LEVEL=info\nHTTP_ADDRESS=:8080\n")
+	cfg, err := MustLoad(path)
+	assert.NoError(t, err)
+
+	reloader := NewReloader(path, cfg, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	var applied *Config
+	reloader.OnReload(func(cfg *Config) { applied = cfg })
+
+	writeConfig(t, path, "JWT_SECRET_KEY=rotated\nLOG_LEVEL=debug\nHTTP_ADDRESS=:9090\nCACHE_KEY_INVALIDATION_MINUTES=1\n")
+	changes, err := reloader.Reload()
+	assert.NoError(t, err)
+	assert.Len(t, changes, 4)
+	assert.NotNil(t, applied)
+	assert.Equal(t, "debug", applied.LogLevel)
+	assert.Equal(t, 1.0, applied.CacheKeyInvalidationTime)
+	assert.Equal(t, ":8080", applied.HTTPAddress)
+	assert.Equal(t, "secret", applied.JWTSecretKey)
+	assert.Equal(t, applied, reloader.Current())
+}
+
+func TestReloader_ShouldKeepConfigWhenReloadIsInvalid(t *testing.T) {
+	for _, name := range []string{"JWT_SECRET_KEY", "LOG_LEVEL"} {
+		t.Setenv(name, "")
+		os.Unsetenv(name)
+	}
+	path := filepath.Join(t.TempDir(), "config.env")
+	writeConfig(t, path, "JWT_SECRET_KEY=secret\nLOG_LEVEL=info\n")
+	cfg, err := MustLoad(path)
+	assert.NoError(t, err)
+
+	reloader := NewReloader(path, cfg, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	writeConfig(t, path, "JWT_SECRET_KEY=secret\nLOG_LEVEL=loud\n")
+	_, err = reloader.Reload()
+	assert.ErrorContains(t, err, "LOG_LEVEL must be one of")
+	assert.Equal(t, cfg, reloader.Current())
+}
+
+func TestChange_ShouldMaskSecrets(t *testing.T) {
+	change := Change{Name: "JWT_SECRET_KEY", Before: "a", After: "b"}
+	assert.Equal(t, "JWT_SECRET_KEY: changed", change.String())
+}
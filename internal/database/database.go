@@ -1,6 +1,8 @@
 package database
 
 import (
+	"context"
+	"encoding/json"
 	"github.com/Paincake/avito-tech/internal/dto"
 	"strconv"
 	"strings"
@@ -10,17 +12,49 @@ import (
 const (
 	AdminRole = "admin"
 	UserRole  = "user"
+	// SuperAdminRole manages tenants and has no access to their banners.
+	SuperAdminRole = "superadmin"
 )
 
 type BannerRepository interface {
-	InsertBanner(banner dto.Banner) (int64, error)
-	UpdateBannerById(id int64, banner dto.Banner) error
-	DeleteBannerById(id int64) error
-	SelectUserBanner(params dto.GetUserBannerParams) (UserBanner, error)
-	SelectBanners(params dto.GetBannerParams) ([]Banner, error)
-	Login(username string, password string) (string, error)
-	Signup(username string, password string) error
-	RunMigrations(query ...string) error
+	InsertBanner(ctx context.Context, banner dto.Banner, author string) (int64, error)
+	UpdateBannerById(ctx context.Context, id int64, banner dto.Banner, editor string) error
+	DeleteBannerById(ctx context.Context, id int64) error
+	SelectBannerById(ctx context.Context, id int64) (Banner, error)
+	UpdateBannerStatus(ctx context.Context, id int64, review BannerReview) error
+	SelectBannerReviews(ctx context.Context, id int64) ([]BannerReview, error)
+	InsertAuditEntry(ctx context.Context, entry AuditEntry) error
+	SelectAuditEntries(ctx context.Context, params dto.GetAuditParams) ([]AuditEntry, error)
+	InsertBannerChange(ctx context.Context, change BannerChange) error
+	SelectBannerChanges(ctx context.Context, params dto.GetChangesParams) ([]BannerChange, error)
+	InsertWebhook(ctx context.Context, webhook dto.Webhook, author string) (int64, error)
+	SelectWebhooks(ctx context.Context) ([]Webhook, error)
+	SelectWebhookById(ctx context.Context, id int64) (Webhook, error)
+	DeleteWebhookById(ctx context.Context, id int64) error
+	InsertWebhookDeliveries(ctx context.Context, event string, payload []byte) error
+	InsertAPIKey(ctx context.Context, key dto.APIKey, hash string, author string) (int64, error)
+	SelectAPIKeys(ctx context.Context) ([]APIKey, error)
+	SelectAPIKeyById(ctx context.Context, id int64) (APIKey, error)
+	SelectAPIKeyByHash(ctx context.Context, hash string) (APIKey, error)
+	DeleteAPIKeyById(ctx context.Context, id int64) error
+	SelectWebhookDeliveries(ctx context.Context, params dto.GetWebhookDeliveriesParams) ([]WebhookDelivery, error)
+	SelectUserBanner(ctx context.Context, params dto.GetUserBannerParams) (UserBanner, error)
+	SelectUserBanners(ctx context.Context, keys []dto.UserBannerKey, useActive bool) (map[dto.UserBannerKey]UserBanner, error)
+	SelectActiveUserBanners(ctx context.Context) (map[dto.UserBannerKey]UserBanner, error)
+	SelectBanners(ctx context.Context, params dto.GetBannerParams) ([]Banner, error)
+	CountBanners(ctx context.Context, params dto.GetBannerParams) (int64, error)
+	Login(ctx context.Context, username string, password string) (User, error)
+	Signup(ctx context.Context, username string, password string) error
+	InsertUser(ctx context.Context, username string, password string, role string) error
+	InsertFeature(ctx context.Context, description string) (int64, error)
+	SelectFeatures(ctx context.Context) ([]Feature, error)
+	InsertTag(ctx context.Context, description string) (int64, error)
+	SelectTags(ctx context.Context) ([]Tag, error)
+	InsertTenant(ctx context.Context, name string) (int64, error)
+	SelectTenants(ctx context.Context) ([]Tenant, error)
+	SelectTenantById(ctx context.Context, id int64) (Tenant, error)
+	RunMigrations(ctx context.Context, query ...string) error
+	InTransaction(ctx context.Context, fn func(repository BannerRepository) error) error
 }
 
 type Banner struct {
@@ -33,16 +67,21 @@ type Banner struct {
 	IsActive     bool      `db:"is_active"`
 	CreatedAt    time.Time `db:"created_at"`
 	UpdatedAt    time.Time `db:"updated_at"`
+	Status       string    `db:"status"`
+	Author       string    `db:"author"`
+	Editor       string    `db:"editor"`
 }
 
 func ConvertBannerToDto(banner Banner) (dto.Banner, error) {
-	var ids []int64
-	for _, id := range strings.Split(banner.TagIDs[1:len(banner.TagIDs)-1], ",") {
-		validID, err := strconv.ParseInt(id, 10, 64)
-		if err != nil {
-			return dto.Banner{}, err
+	ids := make([]int64, 0)
+	if tagIDs := strings.Trim(banner.TagIDs, "{}"); tagIDs != "" {
+		for _, id := range strings.Split(tagIDs, ",") {
+			validID, err := strconv.ParseInt(id, 10, 64)
+			if err != nil {
+				return dto.Banner{}, err
+			}
+			ids = append(ids, validID)
 		}
-		ids = append(ids, validID)
 	}
 	return dto.Banner{
 		Tags:      ids,
@@ -55,9 +94,31 @@ func ConvertBannerToDto(banner Banner) (dto.Banner, error) {
 		IsActive:  banner.IsActive,
 		CreatedAt: banner.CreatedAt.Format(time.RFC3339),
 		UpdatedAt: banner.UpdatedAt.Format(time.RFC3339),
+		Status:    banner.Status,
 	}, nil
 }
 
+// BannerCursor returns the keyset position of banner for the given sort field.
+func BannerCursor(banner Banner, sort, order string) dto.BannerCursor {
+	var value string
+	switch sort {
+	case dto.SortByCreatedAt:
+		value = banner.CreatedAt.Format(time.RFC3339Nano)
+	case dto.SortByUpdatedAt:
+		value = banner.UpdatedAt.Format(time.RFC3339Nano)
+	case dto.SortByFeatureId:
+		value = strconv.FormatInt(banner.FeatureID, 10)
+	default:
+		value = strconv.FormatInt(banner.BannerID, 10)
+	}
+	return dto.BannerCursor{
+		Sort:  sort,
+		Order: order,
+		Value: value,
+		ID:    banner.BannerID,
+	}
+}
+
 type UserBanner struct {
 	Title     string    `db:"content_title"`
 	Text      string    `db:"content_text"`
@@ -74,8 +135,210 @@ func ConvertUserBannerToDto(banner UserBanner) dto.Content {
 	}
 }
 
+// BannerReview is a recorded status transition of a banner.
+type BannerReview struct {
+	BannerID   int64     `db:"banner_id"`
+	FromStatus string    `db:"from_status"`
+	ToStatus   string    `db:"to_status"`
+	Reviewer   string    `db:"reviewer"`
+	Comment    string    `db:"comment"`
+	CreatedAt  time.Time `db:"created_at"`
+}
+
+func ConvertBannerReviewToDto(review BannerReview) dto.BannerReview {
+	return dto.BannerReview{
+		FromStatus: review.FromStatus,
+		ToStatus:   review.ToStatus,
+		Reviewer:   review.Reviewer,
+		Comment:    review.Comment,
+		CreatedAt:  review.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+// AuditEntry is a row of the append-only audit log. Before, After and Diff
+// hold JSON documents.
+type AuditEntry struct {
+	ID         int64     `db:"audit_id"`
+	Actor      string    `db:"actor"`
+	Action     string    `db:"action"`
+	TargetType string    `db:"target_type"`
+	TargetID   string    `db:"target_id"`
+	Before     []byte    `db:"before"`
+	After      []byte    `db:"after"`
+	Diff       []byte    `db:"diff"`
+	RequestID  string    `db:"request_id"`
+	CreatedAt  time.Time `db:"created_at"`
+}
+
+func ConvertAuditEntryToDto(entry AuditEntry) (dto.AuditEntry, error) {
+	diff := make(map[string]dto.FieldChanges)
+	if len(entry.Diff) > 0 {
+		if err := json.Unmarshal(entry.Diff, &diff); err != nil {
+			return dto.AuditEntry{}, err
+		}
+	}
+	return dto.AuditEntry{
+		Id:         entry.ID,
+		Actor:      entry.Actor,
+		Action:     entry.Action,
+		TargetType: entry.TargetType,
+		TargetId:   entry.TargetID,
+		Before:     rawJSON(entry.Before),
+		After:      rawJSON(entry.After),
+		Diff:       diff,
+		RequestId:  entry.RequestID,
+		CreatedAt:  entry.CreatedAt.Format(time.RFC3339),
+	}, nil
+}
+
+func rawJSON(raw []byte) json.RawMessage {
+	if len(raw) == 0 {
+		return json.RawMessage("null")
+	}
+	return raw
+}
+
+// BannerChange is an entry of the change feed. Banner holds the JSON
+// snapshot of the banner after the change and is empty for deletions.
+type BannerChange struct {
+	Sequence  int64     `db:"sequence"`
+	BannerID  int64     `db:"banner_id"`
+	Event     string    `db:"event"`
+	Banner    []byte    `db:"banner"`
+	ChangedAt time.Time `db:"changed_at"`
+}
+
+func ConvertBannerChangeToDto(change BannerChange) dto.BannerChange {
+	return dto.BannerChange{
+		Sequence:  change.Sequence,
+		BannerId:  change.BannerID,
+		Event:     change.Event,
+		Banner:    rawJSON(change.Banner),
+		ChangedAt: change.ChangedAt.Format(time.RFC3339Nano),
+	}
+}
+
+// Feature is a feature of a tenant that banners are shown for.
+type Feature struct {
+	ID          int64  `db:"feature_id"`
+	Description string `db:"description"`
+}
+
+// Tag is a user tag of a tenant that banners are shown to.
+type Tag struct {
+	ID          int64  `db:"tag_id"`
+	Description string `db:"description"`
+}
+
+func ConvertFeatureToDto(feature Feature) dto.Feature {
+	return dto.Feature{Id: feature.ID, Description: feature.Description}
+}
+
+func ConvertTagToDto(tag Tag) dto.Tag {
+	return dto.Tag{Id: tag.ID, Description: tag.Description}
+}
+
+// Webhook is a subscription to banner events. Events holds the Postgres
+// array of event types.
+type Webhook struct {
+	ID        int64     `db:"webhook_id"`
+	URL       string    `db:"url"`
+	Secret    string    `db:"secret"`
+	Events    string    `db:"events"`
+	CreatedBy string    `db:"created_by"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+// WebhookEvents splits the Postgres array of event types of a webhook.
+func WebhookEvents(webhook Webhook) []string {
+	events := make([]string, 0)
+	if trimmed := strings.Trim(webhook.Events, "{}"); trimmed != "" {
+		events = append(events, strings.Split(trimmed, ",")...)
+	}
+	return events
+}
+
+func ConvertWebhookToDto(webhook Webhook) dto.Webhook {
+	return dto.Webhook{
+		Id:        webhook.ID,
+		Url:       webhook.URL,
+		Events:    WebhookEvents(webhook),
+		CreatedBy: webhook.CreatedBy,
+		CreatedAt: webhook.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+// APIKey authenticates a service as a user of a tenant with role. The key
+// itself is not kept, only its hash.
+type APIKey struct {
+	ID        int64     `db:"api_key_id"`
+	TenantID  int64     `db:"tenant_id"`
+	Name      string    `db:"name"`
+	Role      string    `db:"role"`
+	CreatedBy string    `db:"created_by"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+func ConvertAPIKeyToDto(key APIKey) dto.APIKey {
+	return dto.APIKey{
+		Id:        key.ID,
+		Name:      key.Name,
+		Role:      key.Role,
+		CreatedBy: key.CreatedBy,
+		CreatedAt: key.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+// WebhookDelivery is a row of the webhook outbox. URL and Secret are those
+// of the webhook and only set on claimed deliveries.
+type WebhookDelivery struct {
+	ID             int64      `db:"delivery_id"`
+	WebhookID      int64      `db:"webhook_id"`
+	Event          string     `db:"event"`
+	Payload        []byte     `db:"payload"`
+	Status         string     `db:"status"`
+	Attempts       int        `db:"attempts"`
+	NextAttemptAt  time.Time  `db:"next_attempt_at"`
+	ResponseStatus int        `db:"response_status"`
+	LastError      string     `db:"last_error"`
+	CreatedAt      time.Time  `db:"created_at"`
+	DeliveredAt    *time.Time `db:"delivered_at"`
+	URL            string     `db:"url"`
+	Secret         string     `db:"secret"`
+}
+
+func ConvertWebhookDeliveryToDto(delivery WebhookDelivery) dto.WebhookDelivery {
+	result := dto.WebhookDelivery{
+		Id:             delivery.ID,
+		WebhookId:      delivery.WebhookID,
+		Event:          delivery.Event,
+		Payload:        rawJSON(delivery.Payload),
+		Status:         delivery.Status,
+		Attempts:       delivery.Attempts,
+		ResponseStatus: delivery.ResponseStatus,
+		LastError:      delivery.LastError,
+		CreatedAt:      delivery.CreatedAt.Format(time.RFC3339),
+	}
+	if delivery.Status == dto.DeliveryPending {
+		result.NextAttemptAt = delivery.NextAttemptAt.Format(time.RFC3339)
+	}
+	if delivery.DeliveredAt != nil {
+		result.DeliveredAt = delivery.DeliveredAt.Format(time.RFC3339)
+	}
+	return result
+}
+
 type User struct {
 	Username string `db:"username" required:"true"`
 	Password string `db:"password" required:"true"`
 	Role     string `db:"role" required:"true"`
+	TenantID int64  `db:"tenant_id"`
+}
+
+func ConvertTenantToDto(tenant Tenant) dto.Tenant {
+	return dto.Tenant{
+		Id:        tenant.ID,
+		Name:      tenant.Name,
+		CreatedAt: tenant.CreatedAt.Format(time.RFC3339),
+	}
 }
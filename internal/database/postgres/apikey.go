@@ -0,0 +1,86 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"github.com/Paincake/avito-tech/internal/database"
+	"github.com/Paincake/avito-tech/internal/dto"
+	"time"
+)
+
+func (d *Database) InsertAPIKey(ctx context.Context, key dto.APIKey, hash string, author string) (int64, error) {
+	tenant, err := database.TenantOf(ctx)
+	if err != nil {
+		return -1, err
+	}
+	var id int64
+	err = d.db.GetContext(ctx, &id,
+		`INSERT INTO api_keys (tenant_id, name, key_hash, role, created_by, created_at) VALUES ($1, $2, $3, $4, $5, $6)
+			   RETURNING api_key_id`,
+		tenant, key.Name, hash, key.Role, author, time.Now())
+	if err != nil {
+		return -1, wrapError(err, "inserting API key")
+	}
+	return id, nil
+}
+
+func (d *Database) SelectAPIKeys(ctx context.Context) ([]database.APIKey, error) {
+	tenant, err := database.TenantOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]database.APIKey, 0)
+	err = d.db.SelectContext(ctx, &keys,
+		`SELECT api_key_id, tenant_id, name, role, coalesce(created_by, '') AS created_by, created_at FROM api_keys
+			   WHERE tenant_id = $1 ORDER BY api_key_id`,
+		tenant)
+	if err != nil {
+		return nil, wrapError(err, "selecting API keys")
+	}
+	return keys, nil
+}
+
+func (d *Database) SelectAPIKeyById(ctx context.Context, id int64) (database.APIKey, error) {
+	tenant, err := database.TenantOf(ctx)
+	if err != nil {
+		return database.APIKey{}, err
+	}
+	var key database.APIKey
+	err = d.db.GetContext(ctx, &key,
+		`SELECT api_key_id, tenant_id, name, role, coalesce(created_by, '') AS created_by, created_at FROM api_keys
+			   WHERE api_key_id = $1 AND tenant_id = $2`,
+		id, tenant)
+	if err != nil {
+		return database.APIKey{}, wrapError(err, "selecting API key")
+	}
+	return key, nil
+}
+
+// SelectAPIKeyByHash finds the key a request authenticates with. It is the
+// one call not scoped to the tenant of ctx: the key names its tenant.
+func (d *Database) SelectAPIKeyByHash(ctx context.Context, hash string) (database.APIKey, error) {
+	var key database.APIKey
+	err := d.db.GetContext(ctx, &key,
+		`SELECT api_key_id, tenant_id, name, role, coalesce(created_by, '') AS created_by, created_at FROM api_keys
+			   WHERE key_hash = $1`,
+		hash)
+	if err != nil {
+		return database.APIKey{}, wrapError(err, "selecting API key")
+	}
+	return key, nil
+}
+
+func (d *Database) DeleteAPIKeyById(ctx context.Context, id int64) error {
+	tenant, err := database.TenantOf(ctx)
+	if err != nil {
+		return err
+	}
+	result, err := d.db.ExecContext(ctx, `DELETE FROM api_keys WHERE api_key_id = $1 AND tenant_id = $2`, id, tenant)
+	if err != nil {
+		return wrapError(err, "deleting API key")
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return EntityNotFound{Err: fmt.Errorf("API key %d not found", id)}
+	}
+	return nil
+}
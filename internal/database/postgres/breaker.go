@@ -0,0 +1,159 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/jackc/pgx/v5/pgconn"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is the cause of the Unavailable errors returned while the
+// circuit breaker rejects queries.
+var ErrCircuitOpen = errors.New("database circuit breaker is open")
+
+// Unavailable is returned without querying Postgres while it is considered
+// down.
+type Unavailable struct {
+	Err error
+}
+
+func (e Unavailable) Error() string {
+	return e.Err.Error()
+}
+
+func (e Unavailable) Unwrap() error {
+	return e.Err
+}
+
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+)
+
+// CircuitBreaker stops sending queries to a database that keeps failing.
+// After threshold consecutive failures it opens and rejects every query for
+// cooldown, then lets a single probe through: the breaker closes if the probe
+// succeeds and opens again otherwise. A nil breaker lets everything through.
+type CircuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	state     string
+	failures  int
+	openedAt  time.Time
+	probing   bool
+	now       func() time.Time
+}
+
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{threshold: threshold, cooldown: cooldown, state: BreakerClosed, now: time.Now}
+}
+
+// State is BreakerClosed, BreakerOpen or BreakerHalfOpen.
+func (b *CircuitBreaker) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// allow fails with Unavailable when the query must not be sent.
+func (b *CircuitBreaker) allow() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BreakerOpen:
+		if b.now().Sub(b.openedAt) < b.cooldown {
+			return Unavailable{Err: ErrCircuitOpen}
+		}
+		b.state = BreakerHalfOpen
+		b.probing = true
+		return nil
+	case BreakerHalfOpen:
+		if b.probing {
+			return Unavailable{Err: ErrCircuitOpen}
+		}
+		b.probing = true
+	}
+	return nil
+}
+
+// record counts the outcome of a query let through by allow.
+func (b *CircuitBreaker) record(err error) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	probe := b.state == BreakerHalfOpen
+	b.probing = false
+	switch {
+	case errors.Is(err, context.Canceled):
+		// says nothing about the database
+	case unhealthy(err):
+		b.failures++
+		if probe || b.failures >= b.threshold {
+			b.state = BreakerOpen
+			b.openedAt = b.now()
+		}
+	default:
+		b.failures = 0
+		b.state = BreakerClosed
+	}
+}
+
+// unhealthy reports whether err means the database could not serve the
+// query, as opposed to rejecting it.
+func unhealthy(err error) bool {
+	if err == nil || errors.Is(err, sql.ErrNoRows) || errors.Is(err, sql.ErrTxDone) {
+		return false
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		// connection exception, insufficient resources, operator intervention
+		switch pgErr.Code[:min(2, len(pgErr.Code))] {
+		case "08", "53", "57":
+			return true
+		}
+		return false
+	}
+	return true
+}
+
+// breakerQueryer sends statements through a CircuitBreaker.
+type breakerQueryer struct {
+	queryer
+	breaker *CircuitBreaker
+}
+
+func (q breakerQueryer) GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	if err := q.breaker.allow(); err != nil {
+		return err
+	}
+	err := q.queryer.GetContext(ctx, dest, query, args...)
+	q.breaker.record(err)
+	return err
+}
+
+func (q breakerQueryer) SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	if err := q.breaker.allow(); err != nil {
+		return err
+	}
+	err := q.queryer.SelectContext(ctx, dest, query, args...)
+	q.breaker.record(err)
+	return err
+}
+
+func (q breakerQueryer) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	if err := q.breaker.allow(); err != nil {
+		return nil, err
+	}
+	result, err := q.queryer.ExecContext(ctx, query, args...)
+	q.breaker.record(err)
+	return result, err
+}
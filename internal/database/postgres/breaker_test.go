@@ -0,0 +1,67 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+type failingQueryer struct {
+	queryer
+	err   error
+	calls int
+}
+
+func (q *failingQueryer) GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	q.calls++
+	return q.err
+}
+
+func TestCircuitBreaker_ShouldOpenAfterConsecutiveFailuresAndProbeAfterCooldown(t *testing.T) {
+	now := time.Now()
+	breaker := NewCircuitBreaker(2, time.Minute)
+	breaker.now = func() time.Time { return now }
+	db := &failingQueryer{err: errors.New("connection refused")}
+	q := breakerQueryer{queryer: db, breaker: breaker}
+
+	assert.Error(t, q.GetContext(context.Background(), nil, "SELECT 1"))
+	assert.Equal(t, BreakerClosed, breaker.State())
+	assert.Error(t, q.GetContext(context.Background(), nil, "SELECT 1"))
+	assert.Equal(t, BreakerOpen, breaker.State())
+
+	var unavailable Unavailable
+	assert.ErrorAs(t, q.GetContext(context.Background(), nil, "SELECT 1"), &unavailable)
+	assert.Equal(t, 2, db.calls, "an open breaker does not query")
+
+	now = now.Add(time.Minute)
+	assert.Error(t, q.GetContext(context.Background(), nil, "SELECT 1"))
+	assert.Equal(t, 3, db.calls, "a probe is let through after the cooldown")
+	assert.Equal(t, BreakerOpen, breaker.State(), "a failed probe opens the breaker again")
+
+	now = now.Add(time.Minute)
+	db.err = sql.ErrNoRows
+	assert.ErrorIs(t, q.GetContext(context.Background(), nil, "SELECT 1"), sql.ErrNoRows)
+	assert.Equal(t, BreakerClosed, breaker.State(), "a row not found is an answer")
+}
+
+func TestCircuitBreaker_ShouldLetSingleProbeThrough(t *testing.T) {
+	breaker := NewCircuitBreaker(1, 0)
+	assert.NoError(t, breaker.allow())
+	breaker.record(errors.New("timeout"))
+	assert.NoError(t, breaker.allow())
+	assert.Equal(t, BreakerHalfOpen, breaker.State())
+	assert.Error(t, breaker.allow(), "the probe is still running")
+	breaker.record(context.Canceled)
+	assert.NoError(t, breaker.allow(), "a cancelled probe does not count")
+}
+
+func TestUnhealthy_ShouldIgnoreRejectedStatements(t *testing.T) {
+	assert.False(t, unhealthy(nil))
+	assert.False(t, unhealthy(&pgconn.PgError{Code: uniqueViolation}))
+	assert.True(t, unhealthy(&pgconn.PgError{Code: "57P01"}))
+	assert.True(t, unhealthy(context.DeadlineExceeded))
+}
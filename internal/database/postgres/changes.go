@@ -0,0 +1,58 @@
+package postgres
+
+import (
+	"context"
+	"github.com/Paincake/avito-tech/internal/database"
+	"github.com/Paincake/avito-tech/internal/dto"
+	"time"
+)
+
+// changeLockKey is the pg_advisory_xact_lock key serialising writers of the
+// change feed.
+const changeLockKey = 7243513912
+
+// InsertBannerChange appends change to the feed. It must be called on a
+// transactional repository: the lock taken here is held until commit, so
+// sequence numbers become visible in order and readers resuming after the
+// last one they saw miss nothing.
+func (d *Database) InsertBannerChange(ctx context.Context, change database.BannerChange) error {
+	tenant, err := database.TenantOf(ctx)
+	if err != nil {
+		return err
+	}
+	if _, err := d.db.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", changeLockKey); err != nil {
+		return wrapError(err, "locking banner changes")
+	}
+	_, err = d.db.ExecContext(ctx,
+		`INSERT INTO banner_changes (banner_id, event, banner, changed_at, tenant_id) VALUES ($1, $2, $3, $4, $5)`,
+		change.BannerID,
+		change.Event,
+		nullJSON(change.Banner),
+		time.Now(),
+		tenant)
+	if err != nil {
+		return wrapError(err, "inserting banner change")
+	}
+	return nil
+}
+
+// SelectBannerChanges returns the changes of the tenant after a sequence
+// number. Sequence numbers are shared by all tenants, so a tenant's feed has
+// gaps but stays ordered.
+func (d *Database) SelectBannerChanges(ctx context.Context, params dto.GetChangesParams) ([]database.BannerChange, error) {
+	tenant, err := database.TenantOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+	changes := make([]database.BannerChange, 0)
+	err = d.db.SelectContext(ctx, &changes,
+		`SELECT sequence, banner_id, event, banner, changed_at FROM banner_changes
+			   WHERE sequence > $1 AND tenant_id = $3 ORDER BY sequence LIMIT $2`,
+		params.Since,
+		params.Limit,
+		tenant)
+	if err != nil {
+		return nil, wrapError(err, "selecting banner changes")
+	}
+	return changes, nil
+}
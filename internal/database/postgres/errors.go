@@ -0,0 +1,90 @@
+package postgres
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const (
+	uniqueViolation     = "23505"
+	foreignKeyViolation = "23503"
+)
+
+// EntityNotFound is returned when the banner a query targets does not exist.
+type EntityNotFound struct {
+	Err error
+}
+
+func (b EntityNotFound) Error() string {
+	if b.Err == nil {
+		return "entity not found"
+	}
+	return b.Err.Error()
+}
+
+func (b EntityNotFound) Unwrap() error {
+	return b.Err
+}
+
+// EntityConflict is returned when a write collides with an existing row,
+// e.g. signing up with a taken username.
+type EntityConflict struct {
+	Err error
+}
+
+func (e EntityConflict) Error() string {
+	return e.Err.Error()
+}
+
+func (e EntityConflict) Unwrap() error {
+	return e.Err
+}
+
+// InvalidReference is returned when a write refers to a feature or tag that
+// does not exist.
+type InvalidReference struct {
+	Err error
+}
+
+func (e InvalidReference) Error() string {
+	return e.Err.Error()
+}
+
+func (e InvalidReference) Unwrap() error {
+	return e.Err
+}
+
+// InvalidCredentials is returned by Login for an unknown user or a wrong
+// password, without telling the two apart.
+type InvalidCredentials struct {
+	Err error
+}
+
+func (e InvalidCredentials) Error() string {
+	return e.Err.Error()
+}
+
+func (e InvalidCredentials) Unwrap() error {
+	return e.Err
+}
+
+// wrapError annotates err with what the query was doing and turns the
+// Postgres errors callers can act on into the typed errors above.
+func wrapError(err error, doing string) error {
+	wrapped := fmt.Errorf("error %s: %w", doing, err)
+	if errors.Is(err, sql.ErrNoRows) {
+		return EntityNotFound{Err: wrapped}
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case uniqueViolation:
+			return EntityConflict{Err: wrapped}
+		case foreignKeyViolation:
+			return InvalidReference{Err: wrapped}
+		}
+	}
+	return wrapped
+}
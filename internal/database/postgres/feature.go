@@ -0,0 +1,64 @@
+package postgres
+
+import (
+	"context"
+	"github.com/Paincake/avito-tech/internal/database"
+)
+
+func (d *Database) InsertFeature(ctx context.Context, description string) (int64, error) {
+	tenant, err := database.TenantOf(ctx)
+	if err != nil {
+		return -1, err
+	}
+	var id int64
+	err = d.db.GetContext(ctx, &id,
+		`INSERT INTO features (description, tenant_id) VALUES ($1, $2) RETURNING feature_id`, description, tenant)
+	if err != nil {
+		return -1, wrapError(err, "inserting feature")
+	}
+	return id, nil
+}
+
+func (d *Database) SelectFeatures(ctx context.Context) ([]database.Feature, error) {
+	tenant, err := database.TenantOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+	features := make([]database.Feature, 0)
+	err = d.db.SelectContext(ctx, &features,
+		`SELECT feature_id, coalesce(description, '') AS description FROM features WHERE tenant_id = $1 ORDER BY feature_id`,
+		tenant)
+	if err != nil {
+		return nil, wrapError(err, "selecting features")
+	}
+	return features, nil
+}
+
+func (d *Database) InsertTag(ctx context.Context, description string) (int64, error) {
+	tenant, err := database.TenantOf(ctx)
+	if err != nil {
+		return -1, err
+	}
+	var id int64
+	err = d.db.GetContext(ctx, &id,
+		`INSERT INTO tags (description, tenant_id) VALUES ($1, $2) RETURNING tag_id`, description, tenant)
+	if err != nil {
+		return -1, wrapError(err, "inserting tag")
+	}
+	return id, nil
+}
+
+func (d *Database) SelectTags(ctx context.Context) ([]database.Tag, error) {
+	tenant, err := database.TenantOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tags := make([]database.Tag, 0)
+	err = d.db.SelectContext(ctx, &tags,
+		`SELECT tag_id, coalesce(description, '') AS description FROM tags WHERE tenant_id = $1 ORDER BY tag_id`,
+		tenant)
+	if err != nil {
+		return nil, wrapError(err, "selecting tags")
+	}
+	return tags, nil
+}
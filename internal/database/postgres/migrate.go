@@ -0,0 +1,338 @@
+package postgres
+
+import (
+	"context"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLockKey is the pg_advisory_lock key held while migrations run, so
+// replicas starting at the same time do not apply them concurrently.
+const migrationLockKey = 7243513911
+
+// Migration is a numbered schema change read from migrations/NNNN_name.up.sql
+// and the matching .down.sql file.
+type Migration struct {
+	Version  int
+	Name     string
+	Up       string
+	Down     string
+	Checksum string
+}
+
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+}
+
+type appliedMigration struct {
+	Version   int       `db:"version"`
+	Name      string    `db:"name"`
+	Checksum  string    `db:"checksum"`
+	AppliedAt time.Time `db:"applied_at"`
+}
+
+// Migrations returns the embedded migrations ordered by version.
+func Migrations() ([]Migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		name := entry.Name()
+		direction := ""
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(name, ".down.sql"):
+			direction = "down"
+		default:
+			return nil, fmt.Errorf("unexpected migration file %s", name)
+		}
+		base := strings.TrimSuffix(name, "."+direction+".sql")
+		number, title, ok := strings.Cut(base, "_")
+		if !ok {
+			return nil, fmt.Errorf("migration file %s is not named NNNN_name.%s.sql", name, direction)
+		}
+		version, err := strconv.Atoi(number)
+		if err != nil {
+			return nil, fmt.Errorf("migration file %s has invalid version: %s", name, err)
+		}
+		content, err := migrationFiles.ReadFile(path.Join("migrations", name))
+		if err != nil {
+			return nil, err
+		}
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: title}
+			byVersion[version] = migration
+		} else if migration.Name != title {
+			return nil, fmt.Errorf("migration %d has conflicting names %s and %s", version, migration.Name, title)
+		}
+		if direction == "up" {
+			migration.Up = string(content)
+			sum := sha256.Sum256(content)
+			migration.Checksum = hex.EncodeToString(sum[:])
+		} else {
+			migration.Down = string(content)
+		}
+	}
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" {
+			return nil, fmt.Errorf("migration %d has no up script", migration.Version)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// LatestMigrationVersion is the schema version the binary expects.
+func LatestMigrationVersion() (int, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return 0, err
+	}
+	if len(migrations) == 0 {
+		return 0, nil
+	}
+	return migrations[len(migrations)-1].Version, nil
+}
+
+// SchemaVersion returns the highest applied migration version, or 0 if no
+// migration has been applied yet. It does not take the migration lock.
+func (d *Database) SchemaVersion(ctx context.Context) (int, error) {
+	var exists bool
+	err := d.db.GetContext(ctx, &exists, `SELECT to_regclass('schema_migrations') IS NOT NULL`)
+	if err != nil {
+		return 0, fmt.Errorf("error selecting schema version: %s", err)
+	}
+	if !exists {
+		return 0, nil
+	}
+	var version int
+	err = d.db.GetContext(ctx, &version, `SELECT coalesce(max(version), 0) FROM schema_migrations`)
+	if err != nil {
+		return 0, fmt.Errorf("error selecting schema version: %s", err)
+	}
+	return version, nil
+}
+
+// CheckSchemaVersion fails unless the database schema is at the version the
+// binary expects.
+func (d *Database) CheckSchemaVersion(ctx context.Context) error {
+	expected, err := LatestMigrationVersion()
+	if err != nil {
+		return err
+	}
+	version, err := d.SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	if version != expected {
+		return fmt.Errorf("schema version is %d, expected %d", version, expected)
+	}
+	return nil
+}
+
+// withMigrationLock runs fn on a single connection holding the migration
+// advisory lock, after making sure schema_migrations exists.
+func (d *Database) withMigrationLock(fn func(conn *sqlx.Conn) error) error {
+	if d.conn == nil {
+		return fmt.Errorf("migrations can not run inside a transaction")
+	}
+	ctx := context.Background()
+	conn, err := d.conn.Connx(ctx)
+	if err != nil {
+		return fmt.Errorf("error acquiring connection: %s", err)
+	}
+	defer conn.Close()
+	if _, err = conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLockKey); err != nil {
+		return fmt.Errorf("error acquiring migration lock: %s", err)
+	}
+	defer conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, migrationLockKey)
+
+	_, err = conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version int PRIMARY KEY,
+		name varchar NOT NULL,
+		checksum varchar NOT NULL,
+		applied_at timestamptz NOT NULL
+	)`)
+	if err != nil {
+		return fmt.Errorf("error creating schema_migrations: %s", err)
+	}
+	return fn(conn)
+}
+
+func selectAppliedMigrations(q queryer) (map[int]appliedMigration, error) {
+	var rows []appliedMigration
+	err := q.SelectContext(context.Background(), &rows,
+		`SELECT version, name, checksum, applied_at FROM schema_migrations ORDER BY version`)
+	if err != nil {
+		return nil, fmt.Errorf("error selecting applied migrations: %s", err)
+	}
+	applied := make(map[int]appliedMigration, len(rows))
+	for _, row := range rows {
+		applied[row.Version] = row
+	}
+	return applied, nil
+}
+
+// verifyMigrations fails if the database contains migrations the binary does
+// not know about or whose script has changed since it was applied.
+func verifyMigrations(migrations []Migration, applied map[int]appliedMigration) error {
+	known := make(map[int]Migration, len(migrations))
+	for _, migration := range migrations {
+		known[migration.Version] = migration
+	}
+	for version, row := range applied {
+		migration, ok := known[version]
+		if !ok {
+			return fmt.Errorf("database has unknown migration %d_%s applied", version, row.Name)
+		}
+		if migration.Checksum != row.Checksum {
+			return fmt.Errorf("migration %d_%s was changed after it had been applied", version, row.Name)
+		}
+	}
+	return nil
+}
+
+func applyMigration(conn *sqlx.Conn, script string, record func(tx *sqlx.Tx) error) error {
+	ctx := context.Background()
+	tx, err := conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if _, err = tx.ExecContext(ctx, script); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	if err = record(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// MigrateUp applies every pending migration in its own transaction and
+// returns the versions it applied.
+func (d *Database) MigrateUp() ([]int, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+	var done []int
+	err = d.withMigrationLock(func(conn *sqlx.Conn) error {
+		applied, err := selectAppliedMigrations(conn)
+		if err != nil {
+			return err
+		}
+		if err = verifyMigrations(migrations, applied); err != nil {
+			return err
+		}
+		for _, migration := range migrations {
+			if _, ok := applied[migration.Version]; ok {
+				continue
+			}
+			err = applyMigration(conn, migration.Up, func(tx *sqlx.Tx) error {
+				_, err := tx.Exec(`INSERT INTO schema_migrations (version, name, checksum, applied_at) VALUES ($1, $2, $3, $4)`,
+					migration.Version, migration.Name, migration.Checksum, time.Now())
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("error applying migration %d_%s: %s", migration.Version, migration.Name, err)
+			}
+			done = append(done, migration.Version)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// MigrateDown reverts the last steps applied migrations and returns the
+// versions it reverted.
+func (d *Database) MigrateDown(steps int) ([]int, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+	var done []int
+	err = d.withMigrationLock(func(conn *sqlx.Conn) error {
+		applied, err := selectAppliedMigrations(conn)
+		if err != nil {
+			return err
+		}
+		if err = verifyMigrations(migrations, applied); err != nil {
+			return err
+		}
+		for i := len(migrations) - 1; i >= 0 && len(done) < steps; i-- {
+			migration := migrations[i]
+			if _, ok := applied[migration.Version]; !ok {
+				continue
+			}
+			if migration.Down == "" {
+				return fmt.Errorf("migration %d_%s can not be reverted", migration.Version, migration.Name)
+			}
+			err = applyMigration(conn, migration.Down, func(tx *sqlx.Tx) error {
+				_, err := tx.Exec(`DELETE FROM schema_migrations WHERE version = $1`, migration.Version)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("error reverting migration %d_%s: %s", migration.Version, migration.Name, err)
+			}
+			done = append(done, migration.Version)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// MigrationStatus lists every known migration and whether it is applied. It
+// only reads: it neither takes the migration lock nor creates
+// schema_migrations, whose absence means nothing has been applied yet.
+func (d *Database) MigrationStatus() ([]MigrationStatus, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+	var exists bool
+	err = d.db.GetContext(context.Background(), &exists, `SELECT to_regclass('schema_migrations') IS NOT NULL`)
+	if err != nil {
+		return nil, fmt.Errorf("error selecting applied migrations: %s", err)
+	}
+	applied := map[int]appliedMigration{}
+	if exists {
+		if applied, err = selectAppliedMigrations(d.db); err != nil {
+			return nil, err
+		}
+	}
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	for _, migration := range migrations {
+		row, ok := applied[migration.Version]
+		statuses = append(statuses, MigrationStatus{
+			Version:   migration.Version,
+			Name:      migration.Name,
+			Applied:   ok,
+			AppliedAt: row.AppliedAt,
+		})
+	}
+	return statuses, verifyMigrations(migrations, applied)
+}
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS banner_tags;
+DROP TABLE IF EXISTS banners;
+DROP TABLE IF EXISTS tags;
+DROP TABLE IF EXISTS features;
+DROP TABLE IF EXISTS api_users;
@@ -0,0 +1,32 @@
+CREATE TABLE IF NOT EXISTS api_users (
+    username varchar PRIMARY KEY,
+    password varchar,
+    role varchar
+);
+
+CREATE TABLE IF NOT EXISTS features (
+    feature_id serial PRIMARY KEY,
+    description text
+);
+
+CREATE TABLE IF NOT EXISTS tags (
+    tag_id serial PRIMARY KEY,
+    description text
+);
+
+CREATE TABLE IF NOT EXISTS banners (
+    banner_id serial PRIMARY KEY,
+    feature_id int REFERENCES features(feature_id) ON DELETE CASCADE,
+    content_title text,
+    content_text text,
+    content_url text,
+    is_active bool,
+    created_at timestamptz,
+    updated_at timestamptz
+);
+
+CREATE TABLE IF NOT EXISTS banner_tags (
+    banner_id int REFERENCES banners(banner_id),
+    tag_id int REFERENCES tags(tag_id),
+    PRIMARY KEY (banner_id, tag_id)
+);
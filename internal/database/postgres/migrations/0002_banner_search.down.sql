@@ -0,0 +1 @@
+DROP INDEX IF EXISTS banners_content_fts;
@@ -0,0 +1,2 @@
+CREATE INDEX IF NOT EXISTS banners_content_fts ON banners
+    USING gin (to_tsvector('simple', coalesce(content_title, '') || ' ' || coalesce(content_text, '')));
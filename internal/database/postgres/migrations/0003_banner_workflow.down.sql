@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS banner_reviews;
+ALTER TABLE banners DROP COLUMN IF EXISTS author;
+ALTER TABLE banners DROP COLUMN IF EXISTS status;
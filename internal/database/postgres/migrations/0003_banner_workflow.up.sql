@@ -0,0 +1,12 @@
+ALTER TABLE banners ADD COLUMN IF NOT EXISTS status varchar NOT NULL DEFAULT 'published';
+ALTER TABLE banners ADD COLUMN IF NOT EXISTS author varchar;
+
+CREATE TABLE IF NOT EXISTS banner_reviews (
+    review_id serial PRIMARY KEY,
+    banner_id int REFERENCES banners(banner_id) ON DELETE CASCADE,
+    from_status varchar NOT NULL,
+    to_status varchar NOT NULL,
+    reviewer varchar NOT NULL,
+    comment text NOT NULL DEFAULT '',
+    created_at timestamptz NOT NULL
+);
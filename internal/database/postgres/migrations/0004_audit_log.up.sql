@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS audit_log (
+    audit_id bigserial PRIMARY KEY,
+    actor varchar NOT NULL,
+    action varchar NOT NULL,
+    target_type varchar NOT NULL,
+    target_id varchar NOT NULL,
+    before jsonb,
+    after jsonb,
+    diff jsonb,
+    request_id varchar NOT NULL DEFAULT '',
+    created_at timestamptz NOT NULL
+);
+CREATE INDEX IF NOT EXISTS audit_log_target ON audit_log (target_type, target_id);
+CREATE INDEX IF NOT EXISTS audit_log_actor ON audit_log (actor, created_at);
+
+-- the audit log is append-only
+CREATE OR REPLACE RULE audit_log_no_update AS ON UPDATE TO audit_log DO INSTEAD NOTHING;
+CREATE OR REPLACE RULE audit_log_no_delete AS ON DELETE TO audit_log DO INSTEAD NOTHING;
@@ -0,0 +1,26 @@
+CREATE TABLE IF NOT EXISTS webhooks (
+    webhook_id bigserial PRIMARY KEY,
+    url varchar NOT NULL,
+    secret varchar NOT NULL,
+    events varchar[] NOT NULL,
+    created_by varchar NOT NULL DEFAULT '',
+    created_at timestamptz NOT NULL
+);
+
+-- the outbox: deliveries are written in the transaction of the banner change
+-- and kept as the delivery log once sent
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    delivery_id bigserial PRIMARY KEY,
+    webhook_id bigint NOT NULL REFERENCES webhooks(webhook_id) ON DELETE CASCADE,
+    event varchar NOT NULL,
+    payload jsonb NOT NULL,
+    status varchar NOT NULL DEFAULT 'pending',
+    attempts int NOT NULL DEFAULT 0,
+    next_attempt_at timestamptz NOT NULL,
+    response_status int NOT NULL DEFAULT 0,
+    last_error text NOT NULL DEFAULT '',
+    created_at timestamptz NOT NULL,
+    delivered_at timestamptz
+);
+CREATE INDEX IF NOT EXISTS webhook_deliveries_due ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook ON webhook_deliveries (webhook_id, delivery_id);
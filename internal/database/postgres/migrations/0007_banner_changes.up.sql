@@ -0,0 +1,10 @@
+-- changes are numbered in commit order: writers take a transaction-level
+-- advisory lock before inserting, so a reader never sees a sequence number
+-- after one that is not yet committed
+CREATE TABLE IF NOT EXISTS banner_changes (
+    sequence bigserial PRIMARY KEY,
+    banner_id bigint NOT NULL,
+    event varchar NOT NULL,
+    banner jsonb,
+    changed_at timestamptz NOT NULL
+);
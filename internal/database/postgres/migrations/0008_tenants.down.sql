@@ -0,0 +1,21 @@
+DROP INDEX IF EXISTS banner_changes_tenant;
+DROP INDEX IF EXISTS webhooks_tenant;
+DROP INDEX IF EXISTS audit_log_tenant;
+
+ALTER TABLE banner_tags DROP CONSTRAINT IF EXISTS banner_tags_tenant_tag_fkey;
+ALTER TABLE banner_tags DROP CONSTRAINT IF EXISTS banner_tags_tenant_banner_fkey;
+ALTER TABLE banners DROP CONSTRAINT IF EXISTS banners_tenant_feature_fkey;
+ALTER TABLE banners DROP CONSTRAINT IF EXISTS banners_tenant_key;
+ALTER TABLE tags DROP CONSTRAINT IF EXISTS tags_tenant_key;
+ALTER TABLE features DROP CONSTRAINT IF EXISTS features_tenant_key;
+
+ALTER TABLE banner_changes DROP COLUMN IF EXISTS tenant_id;
+ALTER TABLE webhooks DROP COLUMN IF EXISTS tenant_id;
+ALTER TABLE audit_log DROP COLUMN IF EXISTS tenant_id;
+ALTER TABLE banner_tags DROP COLUMN IF EXISTS tenant_id;
+ALTER TABLE banners DROP COLUMN IF EXISTS tenant_id;
+ALTER TABLE tags DROP COLUMN IF EXISTS tenant_id;
+ALTER TABLE features DROP COLUMN IF EXISTS tenant_id;
+ALTER TABLE api_users DROP COLUMN IF EXISTS tenant_id;
+
+DROP TABLE IF EXISTS tenants;
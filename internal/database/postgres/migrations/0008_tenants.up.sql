@@ -0,0 +1,33 @@
+CREATE TABLE IF NOT EXISTS tenants (
+    tenant_id serial PRIMARY KEY,
+    name varchar NOT NULL UNIQUE,
+    created_at timestamptz NOT NULL DEFAULT now()
+);
+
+-- rows written before tenants existed belong to the default tenant
+INSERT INTO tenants (tenant_id, name) VALUES (1, 'default') ON CONFLICT DO NOTHING;
+SELECT setval('tenants_tenant_id_seq', (SELECT max(tenant_id) FROM tenants));
+
+ALTER TABLE api_users ADD COLUMN IF NOT EXISTS tenant_id int NOT NULL DEFAULT 1 REFERENCES tenants(tenant_id);
+ALTER TABLE features ADD COLUMN IF NOT EXISTS tenant_id int NOT NULL DEFAULT 1 REFERENCES tenants(tenant_id);
+ALTER TABLE tags ADD COLUMN IF NOT EXISTS tenant_id int NOT NULL DEFAULT 1 REFERENCES tenants(tenant_id);
+ALTER TABLE banners ADD COLUMN IF NOT EXISTS tenant_id int NOT NULL DEFAULT 1 REFERENCES tenants(tenant_id);
+ALTER TABLE banner_tags ADD COLUMN IF NOT EXISTS tenant_id int NOT NULL DEFAULT 1 REFERENCES tenants(tenant_id);
+ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS tenant_id int NOT NULL DEFAULT 1 REFERENCES tenants(tenant_id);
+ALTER TABLE webhooks ADD COLUMN IF NOT EXISTS tenant_id int NOT NULL DEFAULT 1 REFERENCES tenants(tenant_id);
+ALTER TABLE banner_changes ADD COLUMN IF NOT EXISTS tenant_id int NOT NULL DEFAULT 1 REFERENCES tenants(tenant_id);
+
+-- a banner may only refer to the feature and tags of its own tenant
+ALTER TABLE features ADD CONSTRAINT features_tenant_key UNIQUE (tenant_id, feature_id);
+ALTER TABLE tags ADD CONSTRAINT tags_tenant_key UNIQUE (tenant_id, tag_id);
+ALTER TABLE banners ADD CONSTRAINT banners_tenant_key UNIQUE (tenant_id, banner_id);
+ALTER TABLE banners ADD CONSTRAINT banners_tenant_feature_fkey
+    FOREIGN KEY (tenant_id, feature_id) REFERENCES features (tenant_id, feature_id);
+ALTER TABLE banner_tags ADD CONSTRAINT banner_tags_tenant_banner_fkey
+    FOREIGN KEY (tenant_id, banner_id) REFERENCES banners (tenant_id, banner_id);
+ALTER TABLE banner_tags ADD CONSTRAINT banner_tags_tenant_tag_fkey
+    FOREIGN KEY (tenant_id, tag_id) REFERENCES tags (tenant_id, tag_id);
+
+CREATE INDEX IF NOT EXISTS audit_log_tenant ON audit_log (tenant_id, audit_id);
+CREATE INDEX IF NOT EXISTS webhooks_tenant ON webhooks (tenant_id);
+CREATE INDEX IF NOT EXISTS banner_changes_tenant ON banner_changes (tenant_id, sequence);
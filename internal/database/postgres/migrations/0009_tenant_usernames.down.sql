@@ -0,0 +1,2 @@
+ALTER TABLE api_users DROP CONSTRAINT IF EXISTS api_users_pkey;
+ALTER TABLE api_users ADD PRIMARY KEY (username);
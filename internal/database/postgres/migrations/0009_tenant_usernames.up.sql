@@ -0,0 +1,3 @@
+-- usernames are unique within a tenant only, logins name the tenant
+ALTER TABLE api_users DROP CONSTRAINT IF EXISTS api_users_pkey;
+ALTER TABLE api_users ADD PRIMARY KEY (tenant_id, username);
@@ -0,0 +1,3 @@
+-- the last user to change or submit a banner, who may not approve it either
+ALTER TABLE banners ADD COLUMN IF NOT EXISTS editor varchar;
+UPDATE banners SET editor = author WHERE editor IS NULL;
@@ -0,0 +1,11 @@
+-- API keys authenticate services of a tenant; only the hash of a key is kept
+CREATE TABLE IF NOT EXISTS api_keys (
+    api_key_id serial PRIMARY KEY,
+    tenant_id int NOT NULL REFERENCES tenants(tenant_id),
+    name varchar NOT NULL,
+    key_hash varchar NOT NULL UNIQUE,
+    role varchar NOT NULL,
+    created_by varchar,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    UNIQUE (tenant_id, name)
+);
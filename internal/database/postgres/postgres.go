@@ -1,6 +1,9 @@
 package postgres
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"github.com/Paincake/avito-tech/internal/database"
 	"github.com/Paincake/avito-tech/internal/dto"
@@ -11,14 +14,6 @@ import (
 	"time"
 )
 
-type EntityNotFound struct {
-	Err error
-}
-
-func (b EntityNotFound) Error() string {
-	return b.Err.Error()
-}
-
 type OptionalTagIdParam struct {
 	Value int64
 }
@@ -27,8 +22,17 @@ type OptionalFeatureIdParam struct {
 	Value int64
 }
 
+// queryer is the part of sqlx shared by *sqlx.DB and *sqlx.Tx.
+type queryer interface {
+	GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error
+	SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
 type Database struct {
-	db *sqlx.DB
+	db      queryer
+	conn    *sqlx.DB
+	breaker *CircuitBreaker
 }
 
 func New(dbname, username, password, host, port string) (*Database, error) {
@@ -42,12 +46,81 @@ func New(dbname, username, password, host, port string) (*Database, error) {
 	if err != nil {
 		return nil, fmt.Errorf("%w", err)
 	}
-	return &Database{db: db}, nil
+	return &Database{db: tracedQueryer{queryer: db}, conn: db}, nil
+}
+
+// ConfigurePool sets the connection pool limits; zero values keep the
+// database/sql defaults.
+func (d *Database) ConfigurePool(maxOpen, maxIdle int, maxLifetime time.Duration) {
+	d.conn.SetMaxOpenConns(maxOpen)
+	d.conn.SetMaxIdleConns(maxIdle)
+	d.conn.SetConnMaxLifetime(maxLifetime)
+}
+
+// UseCircuitBreaker sends every query, including the ones of transactions,
+// through breaker.
+func (d *Database) UseCircuitBreaker(breaker *CircuitBreaker) {
+	d.breaker = breaker
+	d.db = d.wrap(d.conn)
+}
+
+// wrap instruments the statements sent through q.
+func (d *Database) wrap(q queryer) queryer {
+	if d.breaker == nil {
+		return tracedQueryer{queryer: q}
+	}
+	return breakerQueryer{queryer: tracedQueryer{queryer: q}, breaker: d.breaker}
+}
+
+// begin starts a transaction unless the circuit breaker is open.
+func (d *Database) begin(ctx context.Context) (*sqlx.Tx, error) {
+	if err := d.breaker.allow(); err != nil {
+		return nil, wrapError(err, "starting transaction")
+	}
+	tx, err := d.conn.BeginTxx(ctx, nil)
+	d.breaker.record(err)
+	if err != nil {
+		return nil, wrapError(err, "starting transaction")
+	}
+	return tx, nil
+}
+
+// DB returns the underlying connection pool, e.g. to export its statistics.
+func (d *Database) DB() *sql.DB {
+	return d.conn.DB
+}
+
+// Ping checks that the database can be reached.
+func (d *Database) Ping(ctx context.Context) error {
+	return d.conn.PingContext(ctx)
+}
+
+// InTransaction runs fn against a repository bound to a single transaction,
+// which is committed if fn returns nil and rolled back otherwise. Calls made
+// on an already transactional repository join the outer transaction.
+func (d *Database) InTransaction(ctx context.Context, fn func(repository database.BannerRepository) error) error {
+	if d.conn == nil {
+		return fn(d)
+	}
+	ctx, span := tracer.Start(ctx, "postgres.Transaction")
+	defer span.End()
+	tx, err := d.begin(ctx)
+	if err != nil {
+		return err
+	}
+	if err = fn(&Database{db: d.wrap(tx), breaker: d.breaker}); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return wrapError(err, "committing transaction")
+	}
+	return nil
 }
 
-func (d *Database) RunMigrations(query ...string) error {
+func (d *Database) RunMigrations(ctx context.Context, query ...string) error {
 	for _, q := range query {
-		_, err := d.db.Exec(q)
+		_, err := d.db.ExecContext(ctx, q)
 		if err != nil {
 			return err
 		}
@@ -55,12 +128,16 @@ func (d *Database) RunMigrations(query ...string) error {
 	return nil
 }
 
-func (d *Database) InsertBanner(banner dto.Banner) (int64, error) {
+func (d *Database) InsertBanner(ctx context.Context, banner dto.Banner, author string) (int64, error) {
+	tenant, err := database.TenantOf(ctx)
+	if err != nil {
+		return -1, err
+	}
 	var lastInserted int64
-	err := d.db.Get(&lastInserted,
-		`INSERT INTO banners (feature_id, content_title, content_text, content_url, is_active, created_at, updated_at)
+	err = d.db.GetContext(ctx, &lastInserted,
+		`INSERT INTO banners (feature_id, content_title, content_text, content_url, is_active, created_at, updated_at, status, author, editor, tenant_id)
 			   VALUES
-			   ($1, $2, $3, $4, $5, $6, $7)
+			   ($1, $2, $3, $4, $5, $6, $7, $8, $9, $9, $10)
 				RETURNING banner_id`,
 		banner.FeatureId,
 		banner.Content.Title,
@@ -68,124 +145,471 @@ func (d *Database) InsertBanner(banner dto.Banner) (int64, error) {
 		banner.Content.Url,
 		banner.IsActive,
 		time.Now(),
-		time.Now())
+		time.Now(),
+		dto.StatusDraft,
+		author,
+		tenant)
 	if err != nil {
-		return -1, fmt.Errorf("error inserting a banner: %s", err)
+		return -1, wrapError(err, "inserting a banner")
 	}
-	_, err = d.db.Query(`INSERT INTO banner_tags VALUES ($1, unnest($2::INTEGER[]))`, lastInserted, banner.Tags)
+	_, err = d.db.ExecContext(ctx, `INSERT INTO banner_tags (banner_id, tag_id, tenant_id) VALUES ($1, unnest($2::INTEGER[]), $3)`,
+		lastInserted, banner.Tags, tenant)
 	if err != nil {
-		return -1, fmt.Errorf("error inserting banner tags: %s", err)
+		return -1, wrapError(err, "inserting banner tags")
 	}
 	return lastInserted, nil
 }
 
-func (d *Database) UpdateBannerById(id int64, banner dto.Banner) error {
-	result, err := d.db.Exec(
-		`UPDATE banners SET feature_id = $1, content_title = $2, content_text=$3,content_url=$4,is_active=$5,updated_at=$6 WHERE banner_id = $7`,
+// UpdateBannerById replaces a banner and sends it back to draft, as its new
+// content has not been reviewed. editor becomes the last editor of the banner.
+func (d *Database) UpdateBannerById(ctx context.Context, id int64, banner dto.Banner, editor string) error {
+	tenant, err := database.TenantOf(ctx)
+	if err != nil {
+		return err
+	}
+	result, err := d.db.ExecContext(ctx,
+		`UPDATE banners SET feature_id = $1, content_title = $2, content_text=$3,content_url=$4,is_active=$5,updated_at=$6,status=$7,editor=$10
+			   WHERE banner_id = $8 AND tenant_id = $9`,
 		banner.FeatureId,
 		banner.Content.Title,
 		banner.Content.Text,
 		banner.Content.Url,
 		banner.IsActive,
 		time.Now(),
-		id)
+		dto.StatusDraft,
+		id,
+		tenant,
+		editor)
 	if err != nil {
-		return fmt.Errorf("error updating banner: %s", err)
+		return wrapError(err, "updating banner")
 	}
-	if affected, err := result.RowsAffected(); affected == 0 {
-		return EntityNotFound{Err: err}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return EntityNotFound{Err: fmt.Errorf("banner %d not found", id)}
 	}
 	if len(banner.Tags) > 0 {
-		_, err := d.db.Query(`INSERT INTO banner_tags VALUES ($1, unnest($2::INTEGER[]))`, id, banner.Tags)
+		_, err := d.db.ExecContext(ctx, `DELETE FROM banner_tags WHERE banner_id = $1`, id)
+		if err != nil {
+			return wrapError(err, "deleting banner tags")
+		}
+		_, err = d.db.ExecContext(ctx, `INSERT INTO banner_tags (banner_id, tag_id, tenant_id) VALUES ($1, unnest($2::INTEGER[]), $3)`,
+			id, banner.Tags, tenant)
 		if err != nil {
-			return fmt.Errorf("error inserting banner tags: %s", err)
+			return wrapError(err, "inserting banner tags")
 		}
 	}
 	return nil
 }
 
-func (d *Database) DeleteBannerById(id int64) error {
-	result, err := d.db.Exec(`DELETE FROM banners WHERE banner_id = $1`, id)
+func (d *Database) DeleteBannerById(ctx context.Context, id int64) error {
+	tenant, err := database.TenantOf(ctx)
+	if err != nil {
+		return err
+	}
+	result, err := d.db.ExecContext(ctx, `DELETE FROM banners WHERE banner_id = $1 AND tenant_id = $2`, id, tenant)
 	if err != nil {
-		return fmt.Errorf("error deleting banner: %s", err)
+		return wrapError(err, "deleting banner")
 	}
-	affected, err := result.RowsAffected()
-	if affected == 0 {
-		return EntityNotFound{err}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return EntityNotFound{Err: fmt.Errorf("banner %d not found", id)}
 	}
 	return nil
 }
 
-func (d *Database) SelectUserBanner(params dto.GetUserBannerParams) (database.UserBanner, error) {
+func (d *Database) SelectUserBanner(ctx context.Context, params dto.GetUserBannerParams) (database.UserBanner, error) {
+	tenant, err := database.TenantOf(ctx)
+	if err != nil {
+		return database.UserBanner{}, err
+	}
 	var banner database.UserBanner
-	err := d.db.Get(&banner,
+	err = d.db.GetContext(ctx, &banner,
 		`SELECT b.content_title, b.content_text, b.content_url, b.created_at, b.updated_at FROM banners b 
                     JOIN banner_tags bt ON bt.banner_id = b.banner_id
 					WHERE b.feature_id= $1 AND bt.tag_id = $2
 					AND b.is_active = (CASE WHEN $3 = true THEN true ELSE b.is_active END)
-					`, params.FeatureId, params.TagId, params.UseActive)
+					AND b.status = $4
+					AND b.tenant_id = $5
+					`, params.FeatureId, params.TagId, params.UseActive, dto.StatusPublished, tenant)
 
 	if err != nil {
-		if err.Error() == "sql: no rows in result set" {
-			return banner, EntityNotFound{Err: err}
-		}
-		return database.UserBanner{}, fmt.Errorf("error selecting user banner: %s", err)
+		return database.UserBanner{}, wrapError(err, "selecting user banner")
 	}
 	empty := banner == database.UserBanner{}
 	if empty {
-		return banner, EntityNotFound{Err: err}
+		return banner, EntityNotFound{Err: fmt.Errorf("no banner for feature %d and tag %d", params.FeatureId, params.TagId)}
 	}
 	return banner, nil
 }
 
-func (d *Database) SelectBanners(params dto.GetBannerParams) ([]database.Banner, error) {
-	var banners []database.Banner
-	err := d.db.Select(&banners,
-		`SELECT b.banner_id, tags.tag_ids, b.feature_id, b.content_title, b.content_text, b.content_url, b.is_active, b.created_at, b.updated_at FROM banners b
-			   JOIN banner_tags bt ON bt.banner_id = b.banner_id
+// SelectUserBanners loads the published banners of several feature and tag
+// pairs in a single query. Pairs without a banner are absent from the result.
+func (d *Database) SelectUserBanners(ctx context.Context, keys []dto.UserBannerKey, useActive bool) (map[dto.UserBannerKey]database.UserBanner, error) {
+	tenant, err := database.TenantOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+	featureIds := make([]int64, 0, len(keys))
+	tagIds := make([]int64, 0, len(keys))
+	for _, key := range keys {
+		featureIds = append(featureIds, key.FeatureId)
+		tagIds = append(tagIds, key.TagId)
+	}
+	var rows []userBannerRow
+	err = d.db.SelectContext(ctx, &rows,
+		`SELECT k.feature_id, k.tag_id, b.content_title, b.content_text, b.content_url, b.created_at, b.updated_at
+					FROM unnest($1::int[], $2::int[]) AS k(feature_id, tag_id)
+					JOIN banners b ON b.feature_id = k.feature_id
+					JOIN banner_tags bt ON bt.banner_id = b.banner_id AND bt.tag_id = k.tag_id
+					WHERE b.is_active = (CASE WHEN $3 = true THEN true ELSE b.is_active END)
+					AND b.status = $4
+					AND b.tenant_id = $5
+					`, featureIds, tagIds, useActive, dto.StatusPublished, tenant)
+	if err != nil {
+		return nil, wrapError(err, "selecting user banners")
+	}
+	return userBannersByKey(rows), nil
+}
 
-			   JOIN 
-					(
-						SELECT b.banner_id, array_agg(bt.tag_id) as tag_ids FROM banners b
-					 	JOIN banner_tags bt on b.banner_id = bt.banner_id
-              		 	GROUP BY b.banner_id, b.feature_id
-						HAVING b.feature_id = (CASE WHEN $1 = $6::int THEN b.feature_id ELSE $1 END) 
-					) tags ON tags.banner_id = b.banner_id
+// SelectActiveUserBanners loads the banner shown to users for every feature
+// and tag pair of the tenant, e.g. to warm up a cache.
+func (d *Database) SelectActiveUserBanners(ctx context.Context) (map[dto.UserBannerKey]database.UserBanner, error) {
+	tenant, err := database.TenantOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var rows []userBannerRow
+	err = d.db.SelectContext(ctx, &rows,
+		`SELECT b.feature_id, bt.tag_id, b.content_title, b.content_text, b.content_url, b.created_at, b.updated_at
+					FROM banners b
+					JOIN banner_tags bt ON bt.banner_id = b.banner_id
+					WHERE b.is_active = true AND b.status = $1 AND b.tenant_id = $2
+					`, dto.StatusPublished, tenant)
+	if err != nil {
+		return nil, wrapError(err, "selecting active user banners")
+	}
+	return userBannersByKey(rows), nil
+}
+
+type userBannerRow struct {
+	FeatureId int64 `db:"feature_id"`
+	TagId     int64 `db:"tag_id"`
+	database.UserBanner
+}
 
- 			   WHERE
-					bt.tag_id = (CASE WHEN $2 = $6::int THEN bt.tag_id ELSE $2 END) AND 
-					b.is_active = (CASE WHEN $3 = true THEN true ELSE b.is_active END)
- 			   
-			   GROUP BY b.banner_id, tags.tag_ids
-			   LIMIT $4 OFFSET $5`,
+func userBannersByKey(rows []userBannerRow) map[dto.UserBannerKey]database.UserBanner {
+	banners := make(map[dto.UserBannerKey]database.UserBanner, len(rows))
+	for _, row := range rows {
+		key := dto.UserBannerKey{FeatureId: row.FeatureId, TagId: row.TagId}
+		if _, ok := banners[key]; !ok {
+			banners[key] = row.UserBanner
+		}
+	}
+	return banners
+}
+
+var sortColumns = map[string]string{
+	dto.SortById:        "b.banner_id",
+	dto.SortByCreatedAt: "b.created_at",
+	dto.SortByUpdatedAt: "b.updated_at",
+	dto.SortByFeatureId: "b.feature_id",
+}
+
+// bannerFilter is the WHERE condition shared by SelectBanners and CountBanners,
+// its arguments are built by bannerFilterArgs.
+const bannerFilter = `
+					b.feature_id = (CASE WHEN $1 = $4::int THEN b.feature_id ELSE $1 END) AND
+					($2::int[] IS NULL OR EXISTS (SELECT 1 FROM banner_tags bt WHERE bt.banner_id = b.banner_id AND bt.tag_id = ANY($2))) AND
+					b.is_active = (CASE WHEN $3 = true THEN true ELSE b.is_active END) AND
+					($5::bool IS NULL OR b.is_active = $5) AND
+					($6::timestamptz IS NULL OR b.created_at >= $6) AND
+					($7::timestamptz IS NULL OR b.created_at <= $7) AND
+					($8::timestamptz IS NULL OR b.updated_at >= $8) AND
+					($9::timestamptz IS NULL OR b.updated_at <= $9) AND
+					($10 = '' OR ` + contentTSVector + ` @@ plainto_tsquery('simple', $10)) AND
+					b.tenant_id = $11`
+
+// contentTSVector must match the expression of the banners_content_fts index.
+const contentTSVector = `to_tsvector('simple', coalesce(b.content_title, '') || ' ' || coalesce(b.content_text, ''))`
+
+func nullTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func bannerFilterArgs(params dto.GetBannerParams, tenant int64) []any {
+	var tagIds []int64
+	if len(params.TagIds) > 0 {
+		tagIds = params.TagIds
+	}
+	return []any{
 		params.FeatureId,
-		params.TagId,
+		tagIds,
 		params.UseActive,
-		params.Limit,
-		params.Offset,
 		dto.DefaultIdValue,
+		params.IsActive,
+		nullTime(params.CreatedFrom),
+		nullTime(params.CreatedTo),
+		nullTime(params.UpdatedFrom),
+		nullTime(params.UpdatedTo),
+		params.Query,
+		tenant,
+	}
+}
+
+func (d *Database) SelectBanners(ctx context.Context, params dto.GetBannerParams) ([]database.Banner, error) {
+	tenant, err := database.TenantOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+	column, ok := sortColumns[params.Sort]
+	if !ok {
+		column = sortColumns[dto.SortById]
+	}
+	direction, comparison := "ASC", ">"
+	if params.Order == dto.OrderDesc {
+		direction, comparison = "DESC", "<"
+	}
+	args := append(bannerFilterArgs(params, tenant), params.Limit, params.Offset)
+	keyset := ""
+	if params.Cursor != nil {
+		value, err := params.Cursor.TypedValue()
+		if err != nil {
+			return nil, wrapError(err, "selecting banners")
+		}
+		keyset = fmt.Sprintf(" AND (%s, b.banner_id) %s ($14, $15)", column, comparison)
+		args = append(args, value, params.Cursor.ID)
+	}
+
+	var banners []database.Banner
+	err = d.db.SelectContext(ctx, &banners,
+		`SELECT b.banner_id, tags.tag_ids, b.feature_id, b.content_title, b.content_text, b.content_url, b.is_active, b.created_at, b.updated_at, b.status, coalesce(b.author, '') AS author, coalesce(b.editor, b.author, '') AS editor FROM banners b
+			   JOIN 
+					(
+						SELECT bt.banner_id, array_agg(bt.tag_id ORDER BY bt.tag_id) as tag_ids FROM banner_tags bt
+              		 	GROUP BY bt.banner_id
+					) tags ON tags.banner_id = b.banner_id
+			   WHERE`+bannerFilter+keyset+
+			fmt.Sprintf(`
+			   ORDER BY %s %s, b.banner_id %s
+			   LIMIT $12 OFFSET $13`, column, direction, direction),
+		args...,
 	)
 	if err != nil {
-		return nil, fmt.Errorf("error selecting banners: %s", err)
+		return nil, wrapError(err, "selecting banners")
 	}
 	return banners, nil
 }
-func (d *Database) Login(username string, password string) (string, error) {
+
+func (d *Database) CountBanners(ctx context.Context, params dto.GetBannerParams) (int64, error) {
+	tenant, err := database.TenantOf(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	err = d.db.GetContext(ctx, &total,
+		`SELECT count(*) FROM banners b
+			   WHERE EXISTS (SELECT 1 FROM banner_tags t WHERE t.banner_id = b.banner_id) AND`+bannerFilter,
+		bannerFilterArgs(params, tenant)...,
+	)
+	if err != nil {
+		return 0, wrapError(err, "counting banners")
+	}
+	return total, nil
+}
+
+func (d *Database) SelectBannerById(ctx context.Context, id int64) (database.Banner, error) {
+	tenant, err := database.TenantOf(ctx)
+	if err != nil {
+		return database.Banner{}, err
+	}
+	var banner database.Banner
+	err = d.db.GetContext(ctx, &banner,
+		`SELECT b.banner_id, coalesce(tags.tag_ids, '{}') AS tag_ids, b.feature_id, b.content_title, b.content_text, b.content_url, b.is_active, b.created_at, b.updated_at, b.status, coalesce(b.author, '') AS author, coalesce(b.editor, b.author, '') AS editor FROM banners b
+			   LEFT JOIN
+					(
+						SELECT bt.banner_id, array_agg(bt.tag_id ORDER BY bt.tag_id) as tag_ids FROM banner_tags bt
+						GROUP BY bt.banner_id
+					) tags ON tags.banner_id = b.banner_id
+			   WHERE b.banner_id = $1 AND b.tenant_id = $2`, id, tenant)
+	if err != nil {
+		return database.Banner{}, wrapError(err, "selecting banner")
+	}
+	return banner, nil
+}
+
+// UpdateBannerStatus moves the banner from review.FromStatus to review.ToStatus
+// and records the review. Submitting a banner makes the reviewer its last
+// editor. It fails with EntityNotFound if the banner is no longer in
+// review.FromStatus.
+func (d *Database) UpdateBannerStatus(ctx context.Context, id int64, review database.BannerReview) error {
+	tenant, err := database.TenantOf(ctx)
+	if err != nil {
+		return err
+	}
+	return d.InTransaction(ctx, func(repository database.BannerRepository) error {
+		tx := repository.(*Database)
+		var submitter string
+		if review.ToStatus == dto.StatusInReview {
+			submitter = review.Reviewer
+		}
+		result, err := tx.db.ExecContext(ctx, `UPDATE banners SET status = $1, editor = coalesce(nullif($5, ''), editor)
+			   WHERE banner_id = $2 AND status = $3 AND tenant_id = $4`,
+			review.ToStatus, id, review.FromStatus, tenant, submitter)
+		if err != nil {
+			return wrapError(err, "updating banner status")
+		}
+		if affected, err := result.RowsAffected(); affected == 0 {
+			return EntityNotFound{Err: fmt.Errorf("banner %d not found in status %s: %v", id, review.FromStatus, err)}
+		}
+		_, err = tx.db.ExecContext(ctx, `INSERT INTO banner_reviews (banner_id, from_status, to_status, reviewer, comment, created_at)
+			   VALUES ($1, $2, $3, $4, $5, $6)`,
+			id, review.FromStatus, review.ToStatus, review.Reviewer, review.Comment, time.Now())
+		if err != nil {
+			return wrapError(err, "inserting banner review")
+		}
+		return nil
+	})
+}
+
+func (d *Database) SelectBannerReviews(ctx context.Context, id int64) ([]database.BannerReview, error) {
+	tenant, err := database.TenantOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reviews := make([]database.BannerReview, 0)
+	err = d.db.SelectContext(ctx, &reviews,
+		`SELECT r.banner_id, r.from_status, r.to_status, r.reviewer, r.comment, r.created_at FROM banner_reviews r
+			   JOIN banners b ON b.banner_id = r.banner_id
+			   WHERE r.banner_id = $1 AND b.tenant_id = $2 ORDER BY r.created_at, r.review_id`, id, tenant)
+	if err != nil {
+		return nil, wrapError(err, "selecting banner reviews")
+	}
+	return reviews, nil
+}
+
+func (d *Database) InsertAuditEntry(ctx context.Context, entry database.AuditEntry) error {
+	tenant, err := database.TenantOf(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = d.db.ExecContext(ctx,
+		`INSERT INTO audit_log (actor, action, target_type, target_id, before, after, diff, request_id, created_at, tenant_id)
+			   VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		entry.Actor,
+		entry.Action,
+		entry.TargetType,
+		entry.TargetID,
+		nullJSON(entry.Before),
+		nullJSON(entry.After),
+		nullJSON(entry.Diff),
+		entry.RequestID,
+		time.Now(),
+		tenant)
+	if err != nil {
+		return wrapError(err, "inserting audit entry")
+	}
+	return nil
+}
+
+func nullJSON(raw []byte) *string {
+	if len(raw) == 0 {
+		return nil
+	}
+	value := string(raw)
+	return &value
+}
+
+func (d *Database) SelectAuditEntries(ctx context.Context, params dto.GetAuditParams) ([]database.AuditEntry, error) {
+	tenant, err := database.TenantOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]database.AuditEntry, 0)
+	err = d.db.SelectContext(ctx, &entries,
+		`SELECT audit_id, actor, action, target_type, target_id, before, after, diff, request_id, created_at FROM audit_log
+			   WHERE
+					($1 = '' OR actor = $1) AND
+					($2 = '' OR target_type = $2) AND
+					($3 = '' OR target_id = $3) AND
+					($4::timestamptz IS NULL OR created_at >= $4) AND
+					($5::timestamptz IS NULL OR created_at <= $5) AND
+					tenant_id = $8
+			   ORDER BY audit_id DESC
+			   LIMIT $6 OFFSET $7`,
+		params.Actor,
+		params.TargetType,
+		params.TargetId,
+		nullTime(params.From),
+		nullTime(params.To),
+		params.Limit,
+		params.Offset,
+		tenant)
+	if err != nil {
+		return nil, wrapError(err, "selecting audit entries")
+	}
+	return entries, nil
+}
+
+// Login checks the password of a user of the tenant, usernames being unique
+// within a tenant only.
+func (d *Database) Login(ctx context.Context, username string, password string) (database.User, error) {
+	tenant, err := database.TenantOf(ctx)
+	if err != nil {
+		return database.User{}, err
+	}
 	var user database.User
-	err := d.db.Get(&user, "SELECT username, password, role FROM api_users WHERE username = $1", username)
+	err = d.db.GetContext(ctx, &user, "SELECT username, password, role, tenant_id FROM api_users WHERE tenant_id = $1 AND username = $2", tenant, username)
+	if errors.Is(err, sql.ErrNoRows) {
+		return database.User{}, InvalidCredentials{Err: fmt.Errorf("unknown user %s", username)}
+	}
 	if err != nil {
-		return "", err
+		return database.User{}, wrapError(err, "selecting user")
 	}
 	if err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
-		return "", err
+		return database.User{}, InvalidCredentials{Err: fmt.Errorf("wrong password for user %s", username)}
+	}
+	return user, nil
+}
+
+func (d *Database) Signup(ctx context.Context, username string, password string) error {
+	tenant, err := database.TenantOf(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = d.db.ExecContext(ctx, "INSERT INTO api_users (username, password, tenant_id) VALUES ($1, $2, $3)", username, password, tenant)
+	if err != nil {
+		return wrapError(err, "inserting user")
+	}
+	return nil
+}
+
+// SaveUser adds a user with the given role to the tenant, or sets the
+// password and role of the user when there already is one.
+func (d *Database) SaveUser(ctx context.Context, username string, password string, role string) error {
+	tenant, err := database.TenantOf(ctx)
+	if err != nil {
+		return err
 	}
-	return user.Role, nil
+	_, err = d.db.ExecContext(ctx, `INSERT INTO api_users (username, password, role, tenant_id) VALUES ($1, $2, $3, $4)
+			   ON CONFLICT (tenant_id, username) DO UPDATE SET password = excluded.password, role = excluded.role`,
+		username, password, role, tenant)
+	if err != nil {
+		return wrapError(err, "saving user")
+	}
+	return nil
 }
 
-func (d *Database) Signup(username string, password string) error {
-	_, err := d.db.Query("INSERT INTO api_users (username, password) VALUES ($1, $2)", username, password)
+// InsertUser adds a user with the given role to the tenant.
+func (d *Database) InsertUser(ctx context.Context, username string, password string, role string) error {
+	tenant, err := database.TenantOf(ctx)
 	if err != nil {
 		return err
 	}
+	_, err = d.db.ExecContext(ctx, "INSERT INTO api_users (username, password, role, tenant_id) VALUES ($1, $2, $3, $4)",
+		username, password, role, tenant)
+	if err != nil {
+		return wrapError(err, "inserting user")
+	}
 	return nil
 }
@@ -1,8 +1,12 @@
 package postgres
 
 import (
+	"context"
+	"database/sql"
 	"fmt"
+	"github.com/Paincake/avito-tech/internal/database"
 	"github.com/Paincake/avito-tech/internal/dto"
+	"strings"
 	"testing"
 )
 
@@ -22,10 +26,10 @@ func TestNew_ShouldReturnError(t *testing.T) {
 
 func TestDatabase_SelectBanners(t *testing.T) {
 	params := dto.GetBannerParams{
-		FeatureId: -1, TagId: -1, Limit: 1,
+		FeatureId: -1, Limit: 1,
 	}
 	db, _ := New("avito", "avito", "avito", "localhost", "5432")
-	banners, err := db.SelectBanners(params)
+	banners, err := db.SelectBanners(database.WithTenant(context.Background(), database.DefaultTenant), params)
 	if err != nil {
 		t.Fatalf("%s", err)
 	}
@@ -41,9 +45,61 @@ func TestDatabase_SelectUserBanner(t *testing.T) {
 	}
 
 	db, _ := New("avito", "avito", "avito", "localhost", "5432")
-	res, err := db.SelectUserBanner(params)
+	res, err := db.SelectUserBanner(database.WithTenant(context.Background(), database.DefaultTenant), params)
 	if err != nil {
 		t.Fatalf("%s", err)
 	}
 	fmt.Printf("%v\n", res)
 }
+
+func TestMigrations_ShouldBeOrderedWithDownScripts(t *testing.T) {
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	for i, migration := range migrations {
+		if migration.Version != i+1 {
+			t.Fatalf("migration %s has version %d, want %d", migration.Name, migration.Version, i+1)
+		}
+		if migration.Down == "" || migration.Checksum == "" {
+			t.Fatalf("migration %d_%s has no down script or checksum", migration.Version, migration.Name)
+		}
+	}
+}
+
+// freshQueryer is a database without schema_migrations that fails every
+// statement other than the existence check.
+type freshQueryer struct{}
+
+func (freshQueryer) GetContext(_ context.Context, dest interface{}, query string, _ ...interface{}) error {
+	if !strings.Contains(query, "to_regclass") {
+		return fmt.Errorf("unexpected query %q", query)
+	}
+	*dest.(*bool) = false
+	return nil
+}
+
+func (freshQueryer) SelectContext(_ context.Context, _ interface{}, query string, _ ...interface{}) error {
+	return fmt.Errorf("unexpected query %q", query)
+}
+
+func (freshQueryer) ExecContext(_ context.Context, query string, _ ...interface{}) (sql.Result, error) {
+	return nil, fmt.Errorf("unexpected statement %q", query)
+}
+
+func TestDatabase_MigrationStatusShouldOnlyReadFreshDatabase(t *testing.T) {
+	db := &Database{db: freshQueryer{}}
+	statuses, err := db.MigrationStatus()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	migrations, _ := Migrations()
+	if len(statuses) != len(migrations) {
+		t.Fatalf("got %d statuses, want %d", len(statuses), len(migrations))
+	}
+	for _, status := range statuses {
+		if status.Applied {
+			t.Fatalf("migration %d_%s reported as applied", status.Version, status.Name)
+		}
+	}
+}
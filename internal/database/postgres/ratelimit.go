@@ -0,0 +1,57 @@
+package postgres
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// TakeToken refills the token bucket of key at rate tokens per second up to
+// burst and takes a token from it if there is one, returning the tokens left.
+// The bucket row is locked for the duration, so replicas sharing the
+// database share the limit.
+func (d *Database) TakeToken(ctx context.Context, key string, rate float64, burst int) (bool, float64, error) {
+	tx, err := d.begin(ctx)
+	if err != nil {
+		return false, 0, err
+	}
+	defer tx.Rollback()
+	q := d.wrap(tx)
+	_, err = q.ExecContext(ctx, `INSERT INTO rate_limit_buckets (key, tokens, updated_at) VALUES ($1, $2, now())
+		ON CONFLICT (key) DO NOTHING`, key, burst)
+	if err != nil {
+		return false, 0, wrapError(err, "inserting rate limit bucket")
+	}
+	var bucket struct {
+		Tokens  float64 `db:"tokens"`
+		Elapsed float64 `db:"elapsed"`
+	}
+	err = q.GetContext(ctx, &bucket, `SELECT tokens, EXTRACT(EPOCH FROM now() - updated_at)::double precision AS elapsed
+		FROM rate_limit_buckets WHERE key = $1 FOR UPDATE`, key)
+	if err != nil {
+		return false, 0, wrapError(err, "selecting rate limit bucket")
+	}
+	tokens := math.Min(float64(burst), bucket.Tokens+math.Max(bucket.Elapsed, 0)*rate)
+	allowed := tokens >= 1
+	if allowed {
+		tokens--
+	}
+	_, err = q.ExecContext(ctx, "UPDATE rate_limit_buckets SET tokens = $2, updated_at = now() WHERE key = $1", key, tokens)
+	if err != nil {
+		return false, 0, wrapError(err, "updating rate limit bucket")
+	}
+	if err = tx.Commit(); err != nil {
+		return false, 0, wrapError(err, "committing transaction")
+	}
+	return allowed, tokens, nil
+}
+
+// PruneTokenBuckets deletes the buckets not used for idle, which are full
+// again for any limit refilling within idle.
+func (d *Database) PruneTokenBuckets(ctx context.Context, idle time.Duration) (int64, error) {
+	result, err := d.db.ExecContext(ctx, "DELETE FROM rate_limit_buckets WHERE updated_at < now() - $1 * interval '1 second'", idle.Seconds())
+	if err != nil {
+		return 0, wrapError(err, "deleting rate limit buckets")
+	}
+	return result.RowsAffected()
+}
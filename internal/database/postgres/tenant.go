@@ -0,0 +1,35 @@
+package postgres
+
+import (
+	"context"
+	"github.com/Paincake/avito-tech/internal/database"
+	"time"
+)
+
+func (d *Database) InsertTenant(ctx context.Context, name string) (int64, error) {
+	var id int64
+	err := d.db.GetContext(ctx, &id,
+		`INSERT INTO tenants (name, created_at) VALUES ($1, $2) RETURNING tenant_id`, name, time.Now())
+	if err != nil {
+		return -1, wrapError(err, "inserting tenant")
+	}
+	return id, nil
+}
+
+func (d *Database) SelectTenants(ctx context.Context) ([]database.Tenant, error) {
+	tenants := make([]database.Tenant, 0)
+	err := d.db.SelectContext(ctx, &tenants, `SELECT tenant_id, name, created_at FROM tenants ORDER BY tenant_id`)
+	if err != nil {
+		return nil, wrapError(err, "selecting tenants")
+	}
+	return tenants, nil
+}
+
+func (d *Database) SelectTenantById(ctx context.Context, id int64) (database.Tenant, error) {
+	var tenant database.Tenant
+	err := d.db.GetContext(ctx, &tenant, `SELECT tenant_id, name, created_at FROM tenants WHERE tenant_id = $1`, id)
+	if err != nil {
+		return database.Tenant{}, wrapError(err, "selecting tenant")
+	}
+	return tenant, nil
+}
@@ -0,0 +1,56 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/Paincake/avito-tech/internal/database/postgres")
+
+// tracedQueryer records a client span for every statement sent to Postgres.
+type tracedQueryer struct {
+	queryer
+}
+
+func startQuerySpan(ctx context.Context, operation, query string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "postgres."+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "postgresql"),
+			attribute.String("db.statement", query),
+		))
+}
+
+func endQuerySpan(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func (q tracedQueryer) GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	ctx, span := startQuerySpan(ctx, "Get", query)
+	err := q.queryer.GetContext(ctx, dest, query, args...)
+	endQuerySpan(span, err)
+	return err
+}
+
+func (q tracedQueryer) SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	ctx, span := startQuerySpan(ctx, "Select", query)
+	err := q.queryer.SelectContext(ctx, dest, query, args...)
+	endQuerySpan(span, err)
+	return err
+}
+
+func (q tracedQueryer) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	ctx, span := startQuerySpan(ctx, "Exec", query)
+	result, err := q.queryer.ExecContext(ctx, query, args...)
+	endQuerySpan(span, err)
+	return result, err
+}
@@ -0,0 +1,165 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"github.com/Paincake/avito-tech/internal/database"
+	"github.com/Paincake/avito-tech/internal/dto"
+	"time"
+)
+
+func (d *Database) InsertWebhook(ctx context.Context, webhook dto.Webhook, author string) (int64, error) {
+	tenant, err := database.TenantOf(ctx)
+	if err != nil {
+		return -1, err
+	}
+	var id int64
+	err = d.db.GetContext(ctx, &id,
+		`INSERT INTO webhooks (url, secret, events, created_by, created_at, tenant_id) VALUES ($1, $2, $3, $4, $5, $6)
+			   RETURNING webhook_id`,
+		webhook.Url,
+		webhook.Secret,
+		webhook.Events,
+		author,
+		time.Now(),
+		tenant)
+	if err != nil {
+		return -1, wrapError(err, "inserting webhook")
+	}
+	return id, nil
+}
+
+func (d *Database) SelectWebhooks(ctx context.Context) ([]database.Webhook, error) {
+	tenant, err := database.TenantOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+	webhooks := make([]database.Webhook, 0)
+	err = d.db.SelectContext(ctx, &webhooks,
+		`SELECT webhook_id, url, secret, events, created_by, created_at FROM webhooks WHERE tenant_id = $1 ORDER BY webhook_id`,
+		tenant)
+	if err != nil {
+		return nil, wrapError(err, "selecting webhooks")
+	}
+	return webhooks, nil
+}
+
+func (d *Database) SelectWebhookById(ctx context.Context, id int64) (database.Webhook, error) {
+	tenant, err := database.TenantOf(ctx)
+	if err != nil {
+		return database.Webhook{}, err
+	}
+	var webhook database.Webhook
+	err = d.db.GetContext(ctx, &webhook,
+		`SELECT webhook_id, url, secret, events, created_by, created_at FROM webhooks WHERE webhook_id = $1 AND tenant_id = $2`,
+		id, tenant)
+	if err != nil {
+		return database.Webhook{}, wrapError(err, "selecting webhook")
+	}
+	return webhook, nil
+}
+
+func (d *Database) DeleteWebhookById(ctx context.Context, id int64) error {
+	tenant, err := database.TenantOf(ctx)
+	if err != nil {
+		return err
+	}
+	result, err := d.db.ExecContext(ctx, `DELETE FROM webhooks WHERE webhook_id = $1 AND tenant_id = $2`, id, tenant)
+	if err != nil {
+		return wrapError(err, "deleting webhook")
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return EntityNotFound{Err: fmt.Errorf("webhook %d not found", id)}
+	}
+	return nil
+}
+
+// InsertWebhookDeliveries adds a delivery of payload to the outbox for every
+// webhook of the tenant subscribed to event. Called on a transactional
+// repository, the deliveries are only sent if the change they describe is
+// committed.
+func (d *Database) InsertWebhookDeliveries(ctx context.Context, event string, payload []byte) error {
+	tenant, err := database.TenantOf(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = d.db.ExecContext(ctx,
+		`INSERT INTO webhook_deliveries (webhook_id, event, payload, next_attempt_at, created_at)
+			   SELECT webhook_id, $1, $2, now(), now() FROM webhooks WHERE $1 = ANY(events) AND tenant_id = $3`,
+		event,
+		string(payload),
+		tenant)
+	if err != nil {
+		return wrapError(err, "inserting webhook deliveries")
+	}
+	return nil
+}
+
+func (d *Database) SelectWebhookDeliveries(ctx context.Context, params dto.GetWebhookDeliveriesParams) ([]database.WebhookDelivery, error) {
+	tenant, err := database.TenantOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+	deliveries := make([]database.WebhookDelivery, 0)
+	err = d.db.SelectContext(ctx, &deliveries,
+		`SELECT d.delivery_id, d.webhook_id, d.event, d.payload, d.status, d.attempts, d.next_attempt_at,
+					d.response_status, d.last_error, d.created_at, d.delivered_at
+			   FROM webhook_deliveries d
+			   JOIN webhooks w ON w.webhook_id = d.webhook_id
+			   WHERE d.webhook_id = $1 AND ($2 = '' OR d.status = $2) AND w.tenant_id = $5
+			   ORDER BY d.delivery_id DESC
+			   LIMIT $3 OFFSET $4`,
+		params.WebhookId,
+		params.Status,
+		params.Limit,
+		params.Offset,
+		tenant)
+	if err != nil {
+		return nil, wrapError(err, "selecting webhook deliveries")
+	}
+	return deliveries, nil
+}
+
+// ClaimWebhookDeliveries takes up to limit pending deliveries that are due,
+// counting an attempt for each and hiding them from other claims for lease.
+// Rows locked by another replica are skipped, and deliveries whose
+// dispatcher dies mid-attempt are retried once the lease expires.
+func (d *Database) ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]database.WebhookDelivery, error) {
+	deliveries := make([]database.WebhookDelivery, 0)
+	err := d.db.SelectContext(ctx, &deliveries,
+		`UPDATE webhook_deliveries d
+			   SET attempts = d.attempts + 1, next_attempt_at = now() + $2 * interval '1 second'
+			   FROM webhooks w
+			   WHERE w.webhook_id = d.webhook_id AND d.delivery_id IN (
+					SELECT delivery_id FROM webhook_deliveries
+					WHERE status = 'pending' AND next_attempt_at <= now()
+					ORDER BY next_attempt_at, delivery_id
+					LIMIT $1
+					FOR UPDATE SKIP LOCKED)
+			   RETURNING d.delivery_id, d.webhook_id, d.event, d.payload, d.status, d.attempts, d.next_attempt_at,
+					d.response_status, d.last_error, d.created_at, d.delivered_at, w.url, w.secret`,
+		limit,
+		lease.Seconds())
+	if err != nil {
+		return nil, wrapError(err, "claiming webhook deliveries")
+	}
+	return deliveries, nil
+}
+
+// UpdateWebhookDelivery records the outcome of an attempt.
+func (d *Database) UpdateWebhookDelivery(ctx context.Context, delivery database.WebhookDelivery) error {
+	_, err := d.db.ExecContext(ctx,
+		`UPDATE webhook_deliveries
+			   SET status = $2, next_attempt_at = $3, response_status = $4, last_error = $5, delivered_at = $6
+			   WHERE delivery_id = $1`,
+		delivery.ID,
+		delivery.Status,
+		delivery.NextAttemptAt,
+		delivery.ResponseStatus,
+		delivery.LastError,
+		delivery.DeliveredAt)
+	if err != nil {
+		return wrapError(err, "updating webhook delivery")
+	}
+	return nil
+}
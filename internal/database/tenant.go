@@ -0,0 +1,39 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// DefaultTenant owns the rows written before tenants existed, and is the
+// tenant of tokens issued without one.
+const DefaultTenant int64 = 1
+
+// ErrNoTenant is returned by tenant-scoped repository calls made without a
+// tenant in their context.
+var ErrNoTenant = errors.New("no tenant in context")
+
+type tenantKey struct{}
+
+// WithTenant scopes the repository calls made with ctx to tenant.
+func WithTenant(ctx context.Context, tenant int64) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// TenantOf returns the tenant of ctx, failing rather than defaulting when
+// there is none so that no call reaches the data of an arbitrary tenant.
+func TenantOf(ctx context.Context) (int64, error) {
+	tenant, ok := ctx.Value(tenantKey{}).(int64)
+	if !ok || tenant <= 0 {
+		return 0, ErrNoTenant
+	}
+	return tenant, nil
+}
+
+// Tenant is a business unit with its own banners, features, tags and users.
+type Tenant struct {
+	ID        int64     `db:"tenant_id"`
+	Name      string    `db:"name"`
+	CreatedAt time.Time `db:"created_at"`
+}
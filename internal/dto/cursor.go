@@ -0,0 +1,57 @@
+package dto
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// BannerCursor is the keyset position of the last banner on a page. It is
+// handed to clients as an opaque base64 token.
+type BannerCursor struct {
+	Sort  string `json:"s"`
+	Order string `json:"o"`
+	Value string `json:"v"`
+	ID    int64  `json:"i"`
+}
+
+func EncodeBannerCursor(cursor BannerCursor) (string, error) {
+	raw, err := json.Marshal(cursor)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+func DecodeBannerCursor(token string) (*BannerCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, fmt.Errorf("malformed cursor")
+	}
+	var cursor BannerCursor
+	if err = json.Unmarshal(raw, &cursor); err != nil {
+		return nil, fmt.Errorf("malformed cursor")
+	}
+	if _, err = cursor.TypedValue(); err != nil {
+		return nil, fmt.Errorf("malformed cursor")
+	}
+	return &cursor, nil
+}
+
+// TypedValue returns the cursor value converted to the type of its sort column.
+func (c BannerCursor) TypedValue() (any, error) {
+	switch c.Sort {
+	case SortByCreatedAt, SortByUpdatedAt:
+		return time.Parse(time.RFC3339Nano, c.Value)
+	default:
+		return strconv.ParseInt(c.Value, 10, 64)
+	}
+}
+
+type BannerPage struct {
+	Banners    []Banner
+	Total      int64
+	NextCursor string
+}
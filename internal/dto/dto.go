@@ -1,12 +1,43 @@
 package dto
 
+import "encoding/json"
+
 type Banner struct {
 	Tags      []int64 `json:"tag_ids" validate:"nonzero"`
 	FeatureId int64   `json:"feature_id" validate:"nonzero"`
 	Content   Content `json:"content" validate:"nonzero"`
-	IsActive  bool    `json:"is_active" validate:"nonzero"`
+	IsActive  bool    `json:"is_active"`
 	CreatedAt string  `json:"created_at" validate:"nonzero"`
 	UpdatedAt string  `json:"updated_at" validate:"nonzero"`
+	Status    string  `json:"status,omitempty"`
+}
+
+const (
+	StatusDraft     = "draft"
+	StatusInReview  = "in_review"
+	StatusApproved  = "approved"
+	StatusPublished = "published"
+)
+
+// Transitions of a banner between statuses.
+const (
+	TransitionSubmit   = "submit"
+	TransitionApprove  = "approve"
+	TransitionReject   = "reject"
+	TransitionPublish  = "publish"
+	TransitionWithdraw = "withdraw"
+)
+
+type Review struct {
+	Comment string `json:"comment"`
+}
+
+type BannerReview struct {
+	FromStatus string `json:"from_status"`
+	ToStatus   string `json:"to_status"`
+	Reviewer   string `json:"reviewer"`
+	Comment    string `json:"comment"`
+	CreatedAt  string `json:"created_at"`
 }
 
 type Content struct {
@@ -15,7 +46,159 @@ type Content struct {
 	Url   string `json:"url" validate:"nonzero"`
 }
 
+// UserBannerKey addresses the banner shown for a feature and tag.
+type UserBannerKey struct {
+	FeatureId int64
+	TagId     int64
+}
+
+type UserBannerBatch struct {
+	Items []UserBannerBatchItem `json:"items"`
+}
+
+type UserBannerBatchItem struct {
+	FeatureId       int64 `json:"feature_id"`
+	TagId           int64 `json:"tag_id"`
+	UseLastRevision bool  `json:"use_last_revision"`
+}
+
+// UserBannerBatchResult is the answer to one item of a batch, in the order
+// of the request; Found is false when there is no banner to show.
+type UserBannerBatchResult struct {
+	FeatureId int64    `json:"feature_id"`
+	TagId     int64    `json:"tag_id"`
+	Found     bool     `json:"found"`
+	Content   *Content `json:"content,omitempty"`
+}
+
 type User struct {
 	Username string `json:"username" required:"true" validate:"nonzero"`
 	Password string `json:"password" required:"true" validate:"nonzero"`
 }
+
+// Feature is a feature banners are shown for, owned by a tenant.
+type Feature struct {
+	Id          int64  `json:"feature_id"`
+	Description string `json:"description"`
+}
+
+// Tag is a user tag banners are shown to, owned by a tenant.
+type Tag struct {
+	Id          int64  `json:"tag_id"`
+	Description string `json:"description"`
+}
+
+// Tenant is a business unit with its own banners and users.
+type Tenant struct {
+	Id        int64  `json:"tenant_id"`
+	Name      string `json:"name" validate:"nonzero"`
+	CreatedAt string `json:"created_at,omitempty"`
+}
+
+// Actor identifies who performs a request.
+type Actor struct {
+	Username  string
+	Role      string
+	RequestId string
+}
+
+type AuditEntry struct {
+	Id         int64                   `json:"id"`
+	Actor      string                  `json:"actor"`
+	Action     string                  `json:"action"`
+	TargetType string                  `json:"target_type"`
+	TargetId   string                  `json:"target_id"`
+	Before     json.RawMessage         `json:"before"`
+	After      json.RawMessage         `json:"after"`
+	Diff       map[string]FieldChanges `json:"diff"`
+	RequestId  string                  `json:"request_id"`
+	CreatedAt  string                  `json:"created_at"`
+}
+
+type FieldChanges struct {
+	Before any `json:"before"`
+	After  any `json:"after"`
+}
+
+// BannerChange is an entry of the change feed; Banner is null for deleted
+// banners.
+type BannerChange struct {
+	Sequence  int64           `json:"sequence"`
+	BannerId  int64           `json:"banner_id"`
+	Event     string          `json:"event"`
+	Banner    json.RawMessage `json:"banner"`
+	ChangedAt string          `json:"changed_at"`
+}
+
+// BannerChangePage is a page of the change feed. Next is the since value
+// of the following page.
+type BannerChangePage struct {
+	Changes []BannerChange `json:"changes"`
+	Next    int64          `json:"next"`
+}
+
+// CacheStats is the state of the user banner cache; the counters run since
+// the process started.
+type CacheStats struct {
+	Entries      int   `json:"entries"`
+	Bytes        int64 `json:"bytes"`
+	MaxEntries   int   `json:"max_entries"`
+	MaxBytes     int64 `json:"max_bytes"`
+	Hits         int64 `json:"hits"`
+	StaleHits    int64 `json:"stale_hits"`
+	DegradedHits int64 `json:"degraded_hits"`
+	Misses       int64 `json:"misses"`
+	Evictions    int64 `json:"evictions"`
+	Expirations  int64 `json:"expirations"`
+}
+
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// Webhook is a subscription of a URL to banner events. Secret signs the
+// deliveries and is never returned.
+type Webhook struct {
+	Id        int64    `json:"id"`
+	Url       string   `json:"url" validate:"nonzero"`
+	Secret    string   `json:"secret,omitempty"`
+	Events    []string `json:"events" validate:"nonzero"`
+	CreatedBy string   `json:"created_by"`
+	CreatedAt string   `json:"created_at"`
+}
+
+// APIKey authenticates a service as a user of a tenant. Key is only set in
+// the response creating it.
+type APIKey struct {
+	Id        int64  `json:"id"`
+	Name      string `json:"name" validate:"nonzero"`
+	Role      string `json:"role" validate:"nonzero"`
+	Key       string `json:"key,omitempty"`
+	CreatedBy string `json:"created_by"`
+	CreatedAt string `json:"created_at"`
+}
+
+type WebhookDelivery struct {
+	Id             int64           `json:"id"`
+	WebhookId      int64           `json:"webhook_id"`
+	Event          string          `json:"event"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	ResponseStatus int             `json:"response_status,omitempty"`
+	LastError      string          `json:"last_error,omitempty"`
+	NextAttemptAt  string          `json:"next_attempt_at,omitempty"`
+	CreatedAt      string          `json:"created_at"`
+	DeliveredAt    string          `json:"delivered_at,omitempty"`
+}
+
+// WebhookPayload is the body posted to webhooks. Banner is the state after
+// the change and is absent for deleted banners.
+type WebhookPayload struct {
+	Event      string  `json:"event"`
+	BannerId   int64   `json:"banner_id"`
+	Banner     *Banner `json:"banner,omitempty"`
+	OccurredAt string  `json:"occurred_at"`
+}
@@ -1,32 +1,103 @@
 package dto
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	//"github.com/Paincake/avito-tech/internal/server"
 	"github.com/labstack/echo/v4"
+	"io"
 	"strconv"
+	"time"
 )
 
 const (
-	DefaultIdValue      = -1
-	TokenRoleContextKey = "Token"
+	DefaultIdValue          = -1
+	TokenRoleContextKey     = "Token"
+	TokenUsernameContextKey = "TokenUsername"
+	DefaultLimit            = 50
+	MaxLimit                = 100
 )
 
+// PageLimits are the default and the largest accepted value of the limit
+// query parameter.
+type PageLimits struct {
+	Default int
+	Max     int
+}
+
+var DefaultPageLimits = PageLimits{Default: DefaultLimit, Max: MaxLimit}
+
+const (
+	SortById        = "id"
+	SortByCreatedAt = "created_at"
+	SortByUpdatedAt = "updated_at"
+	SortByFeatureId = "feature_id"
+	OrderAsc        = "asc"
+	OrderDesc       = "desc"
+)
+
+var sortFields = map[string]bool{
+	SortById:        true,
+	SortByCreatedAt: true,
+	SortByUpdatedAt: true,
+	SortByFeatureId: true,
+}
+
 type GetBannerParams struct {
-	FeatureId int64
-	TagId     int64
-	Limit     int
-	Offset    int
-	UseActive bool
+	FeatureId   int64
+	TagIds      []int64
+	Limit       int
+	Offset      int
+	UseActive   bool
+	IsActive    *bool
+	Query       string
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+	UpdatedFrom time.Time
+	UpdatedTo   time.Time
+	Sort        string
+	Order       string
+	Cursor      *BannerCursor
 }
 
-func NewGetBannerParams(ctx echo.Context) (*GetBannerParams, error) {
+func parseTimeParam(ctx echo.Context, name string) (time.Time, error) {
+	param := ctx.QueryParams().Get(name)
+	if param == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, param)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s format: %s", name, err)
+	}
+	return t, nil
+}
+
+func parseLimitParam(ctx echo.Context, limits PageLimits) (int, error) {
+	param := ctx.QueryParams().Get("limit")
+	if param == "" {
+		return limits.Default, nil
+	}
+	limit, err := strconv.Atoi(param)
+	if err != nil {
+		return 0, fmt.Errorf("invalid limit format: %s", err)
+	}
+	if limit < 1 || limit > limits.Max {
+		return 0, fmt.Errorf("limit must be between 1 and %d", limits.Max)
+	}
+	return limit, nil
+}
+
+func NewGetBannerParams(ctx echo.Context, limits PageLimits) (*GetBannerParams, error) {
 	var err error
 	featureId := int64(DefaultIdValue)
-	tagId := int64(DefaultIdValue)
-	limit := 50
+	var tagIds []int64
+	var isActive *bool
 	offset := 0
 	useActive := true
+	sort := SortById
+	order := OrderAsc
+	var cursor *BannerCursor
 	param := ctx.QueryParams().Get("feature_id")
 	if param != "" {
 		featureId, err = strconv.ParseInt(param, 10, 64)
@@ -34,19 +105,40 @@ func NewGetBannerParams(ctx echo.Context) (*GetBannerParams, error) {
 			return nil, fmt.Errorf("invalid feature_id format: %s", err)
 		}
 	}
-	param = ctx.QueryParams().Get("tag_id")
-	if param != "" {
-		tagId, err = strconv.ParseInt(param, 10, 64)
+	for _, param = range ctx.QueryParams()["tag_id"] {
+		tagId, err := strconv.ParseInt(param, 10, 64)
 		if err != nil {
 			return nil, fmt.Errorf("invalid tag_id format: %s", err)
 		}
+		tagIds = append(tagIds, tagId)
 	}
-	param = ctx.QueryParams().Get("limit")
+	param = ctx.QueryParams().Get("is_active")
 	if param != "" {
-		limit, err = strconv.Atoi(param)
+		active, err := strconv.ParseBool(param)
 		if err != nil {
-			return nil, fmt.Errorf("invalid limit format: %s", err)
+			return nil, fmt.Errorf("invalid is_active format: %s", err)
 		}
+		isActive = &active
+	}
+	createdFrom, err := parseTimeParam(ctx, "created_from")
+	if err != nil {
+		return nil, err
+	}
+	createdTo, err := parseTimeParam(ctx, "created_to")
+	if err != nil {
+		return nil, err
+	}
+	updatedFrom, err := parseTimeParam(ctx, "updated_from")
+	if err != nil {
+		return nil, err
+	}
+	updatedTo, err := parseTimeParam(ctx, "updated_to")
+	if err != nil {
+		return nil, err
+	}
+	limit, err := parseLimitParam(ctx, limits)
+	if err != nil {
+		return nil, err
 	}
 	param = ctx.QueryParams().Get("offset")
 	if param != "" {
@@ -54,6 +146,36 @@ func NewGetBannerParams(ctx echo.Context) (*GetBannerParams, error) {
 		if err != nil {
 			return nil, fmt.Errorf("invalid offset format: %s", err)
 		}
+		if offset < 0 {
+			return nil, fmt.Errorf("offset must not be negative")
+		}
+	}
+	param = ctx.QueryParams().Get("sort")
+	if param != "" {
+		if !sortFields[param] {
+			return nil, fmt.Errorf("invalid sort field: %s", param)
+		}
+		sort = param
+	}
+	param = ctx.QueryParams().Get("order")
+	if param != "" {
+		if param != OrderAsc && param != OrderDesc {
+			return nil, fmt.Errorf("invalid order: %s", param)
+		}
+		order = param
+	}
+	param = ctx.QueryParams().Get("cursor")
+	if param != "" {
+		if offset != 0 {
+			return nil, fmt.Errorf("cursor and offset can not be used together")
+		}
+		cursor, err = DecodeBannerCursor(param)
+		if err != nil {
+			return nil, err
+		}
+		if cursor.Sort != sort || cursor.Order != order {
+			return nil, fmt.Errorf("cursor does not match sort and order")
+		}
 	}
 
 	param, ok := ctx.Get(TokenRoleContextKey).(string)
@@ -64,11 +186,20 @@ func NewGetBannerParams(ctx echo.Context) (*GetBannerParams, error) {
 	}
 
 	return &GetBannerParams{
-		FeatureId: featureId,
-		TagId:     tagId,
-		Limit:     limit,
-		Offset:    offset,
-		UseActive: useActive,
+		FeatureId:   featureId,
+		TagIds:      tagIds,
+		Limit:       limit,
+		Offset:      offset,
+		UseActive:   useActive,
+		IsActive:    isActive,
+		Query:       ctx.QueryParams().Get("q"),
+		CreatedFrom: createdFrom,
+		CreatedTo:   createdTo,
+		UpdatedFrom: updatedFrom,
+		UpdatedTo:   updatedTo,
+		Sort:        sort,
+		Order:       order,
+		Cursor:      cursor,
 	}, nil
 }
 
@@ -124,6 +255,91 @@ func NewGetUserBannerParams(ctx echo.Context) (*GetUserBannerParams, error) {
 	}, nil
 }
 
+type StreamUserBannerParams struct {
+	Key         UserBannerKey
+	LastEventId uint64
+}
+
+// NewStreamUserBannerParams reads the banner to watch from the query and
+// the event to resume after from the Last-Event-ID header.
+func NewStreamUserBannerParams(ctx echo.Context) (*StreamUserBannerParams, error) {
+	var key UserBannerKey
+	var lastEventId uint64
+	var err error
+	for name, id := range map[string]*int64{"feature_id": &key.FeatureId, "tag_id": &key.TagId} {
+		param := ctx.QueryParams().Get(name)
+		if param == "" {
+			return nil, fmt.Errorf("missed required query param: %s", name)
+		}
+		*id, err = strconv.ParseInt(param, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s format: %s", name, err)
+		}
+	}
+	if param := ctx.Request().Header.Get("Last-Event-ID"); param != "" {
+		lastEventId, err = strconv.ParseUint(param, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Last-Event-ID format: %s", err)
+		}
+	}
+	return &StreamUserBannerParams{Key: key, LastEventId: lastEventId}, nil
+}
+
+// FlushCacheParams selects the cached banners of a tenant to flush; zero
+// feature and tag ids match any.
+type FlushCacheParams struct {
+	TenantId  int64
+	FeatureId int64
+	TagId     int64
+}
+
+func NewFlushCacheParams(ctx echo.Context) (*FlushCacheParams, error) {
+	var params FlushCacheParams
+	var err error
+	for name, id := range map[string]*int64{"feature_id": &params.FeatureId, "tag_id": &params.TagId} {
+		param := ctx.QueryParams().Get(name)
+		if param == "" {
+			continue
+		}
+		*id, err = strconv.ParseInt(param, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s format: %s", name, err)
+		}
+		if *id <= 0 {
+			return nil, fmt.Errorf("%s must be positive", name)
+		}
+	}
+	return &params, nil
+}
+
+// MaxUserBannerBatch is the most items POST /user_banner/batch accepts.
+const MaxUserBannerBatch = 100
+
+type GetUserBannersParams struct {
+	Items     []UserBannerBatchItem
+	UseActive bool
+}
+
+// NewGetUserBannersParams checks a batch decoded from the request body.
+func NewGetUserBannersParams(ctx echo.Context, batch UserBannerBatch) (*GetUserBannersParams, error) {
+	if len(batch.Items) == 0 || len(batch.Items) > MaxUserBannerBatch {
+		return nil, fmt.Errorf("items must hold between 1 and %d entries", MaxUserBannerBatch)
+	}
+	for i, item := range batch.Items {
+		if item.FeatureId == 0 || item.TagId == 0 {
+			return nil, fmt.Errorf("items[%d]: feature_id and tag_id are required", i)
+		}
+	}
+	useActive := true
+	if role, ok := ctx.Get(TokenRoleContextKey).(string); ok && role == "admin" {
+		useActive = false
+	}
+	return &GetUserBannersParams{
+		Items:     batch.Items,
+		UseActive: useActive,
+	}, nil
+}
+
 type PatchBannerIdParams struct {
 	BannerId int64
 }
@@ -169,3 +385,156 @@ func NewDeleteBannerIdParams(ctx echo.Context) (*DeleteBannerIdParams, error) {
 		BannerId: bannerId,
 	}, nil
 }
+
+type TransitionBannerParams struct {
+	BannerId   int64
+	Transition string
+	Comment    string
+	Actor      Actor
+}
+
+func NewTransitionBannerParams(ctx echo.Context, transition string) (*TransitionBannerParams, error) {
+	param := ctx.Param("id")
+	if param == "" {
+		return nil, fmt.Errorf("missed required query param: banner_id")
+	}
+	bannerId, err := strconv.ParseInt(param, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid banner_id format: %s", err)
+	}
+	var review Review
+	if ctx.Request().ContentLength != 0 {
+		if err = json.NewDecoder(ctx.Request().Body).Decode(&review); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("invalid request body: %s", err)
+		}
+	}
+	return &TransitionBannerParams{
+		BannerId:   bannerId,
+		Transition: transition,
+		Comment:    review.Comment,
+		Actor:      NewActor(ctx),
+	}, nil
+}
+
+func NewActor(ctx echo.Context) Actor {
+	username, _ := ctx.Get(TokenUsernameContextKey).(string)
+	role, _ := ctx.Get(TokenRoleContextKey).(string)
+	requestId := ctx.Response().Header().Get(echo.HeaderXRequestID)
+	if requestId == "" {
+		requestId = ctx.Request().Header.Get(echo.HeaderXRequestID)
+	}
+	return Actor{
+		Username:  username,
+		Role:      role,
+		RequestId: requestId,
+	}
+}
+
+type GetAuditParams struct {
+	Actor      string
+	TargetType string
+	TargetId   string
+	From       time.Time
+	To         time.Time
+	Limit      int
+	Offset     int
+}
+
+func NewGetAuditParams(ctx echo.Context, limits PageLimits) (*GetAuditParams, error) {
+	offset := 0
+	limit, err := parseLimitParam(ctx, limits)
+	if err != nil {
+		return nil, err
+	}
+	param := ctx.QueryParams().Get("offset")
+	if param != "" {
+		offset, err = strconv.Atoi(param)
+		if err != nil {
+			return nil, fmt.Errorf("invalid offset format: %s", err)
+		}
+		if offset < 0 {
+			return nil, fmt.Errorf("offset must not be negative")
+		}
+	}
+	from, err := parseTimeParam(ctx, "from")
+	if err != nil {
+		return nil, err
+	}
+	to, err := parseTimeParam(ctx, "to")
+	if err != nil {
+		return nil, err
+	}
+	return &GetAuditParams{
+		Actor:      ctx.QueryParams().Get("actor"),
+		TargetType: ctx.QueryParams().Get("target_type"),
+		TargetId:   ctx.QueryParams().Get("target_id"),
+		From:       from,
+		To:         to,
+		Limit:      limit,
+		Offset:     offset,
+	}, nil
+}
+
+type GetWebhookDeliveriesParams struct {
+	WebhookId int64
+	Status    string
+	Limit     int
+	Offset    int
+}
+
+func NewGetWebhookDeliveriesParams(ctx echo.Context, limits PageLimits) (*GetWebhookDeliveriesParams, error) {
+	webhookId, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid id format: %s", err)
+	}
+	limit, err := parseLimitParam(ctx, limits)
+	if err != nil {
+		return nil, err
+	}
+	offset := 0
+	if param := ctx.QueryParams().Get("offset"); param != "" {
+		offset, err = strconv.Atoi(param)
+		if err != nil {
+			return nil, fmt.Errorf("invalid offset format: %s", err)
+		}
+		if offset < 0 {
+			return nil, fmt.Errorf("offset must not be negative")
+		}
+	}
+	status := ctx.QueryParams().Get("status")
+	switch status {
+	case "", DeliveryPending, DeliveryDelivered, DeliveryFailed:
+	default:
+		return nil, fmt.Errorf("status must be one of %s, %s or %s", DeliveryPending, DeliveryDelivered, DeliveryFailed)
+	}
+	return &GetWebhookDeliveriesParams{
+		WebhookId: webhookId,
+		Status:    status,
+		Limit:     limit,
+		Offset:    offset,
+	}, nil
+}
+
+type GetChangesParams struct {
+	Since int64
+	Limit int
+}
+
+func NewGetChangesParams(ctx echo.Context, limits PageLimits) (*GetChangesParams, error) {
+	var since int64
+	var err error
+	if param := ctx.QueryParams().Get("since"); param != "" {
+		since, err = strconv.ParseInt(param, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid since format: %s", err)
+		}
+		if since < 0 {
+			return nil, fmt.Errorf("since must not be negative")
+		}
+	}
+	limit, err := parseLimitParam(ctx, limits)
+	if err != nil {
+		return nil, err
+	}
+	return &GetChangesParams{Since: since, Limit: limit}, nil
+}
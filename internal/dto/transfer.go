@@ -0,0 +1,231 @@
+package dto
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	FormatCSV    = "csv"
+	FormatNDJSON = "ndjson"
+)
+
+var csvHeader = []string{
+	"banner_id", "feature_id", "tag_ids", "title", "text", "url", "is_active", "created_at", "updated_at",
+}
+
+// BannerRecord is a banner together with its identifier as it appears in
+// import and export files. A zero BannerId in an imported record creates a
+// new banner.
+type BannerRecord struct {
+	BannerId int64 `json:"banner_id"`
+	Banner
+}
+
+// ImportRow is a decoded record with the line of the file it was read from.
+type ImportRow struct {
+	Line   int
+	Record BannerRecord
+}
+
+type ImportRowError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+type ImportReport struct {
+	DryRun   bool             `json:"dry_run"`
+	Inserted int              `json:"inserted"`
+	Updated  int              `json:"updated"`
+	Errors   []ImportRowError `json:"errors"`
+}
+
+func ValidFormat(format string) bool {
+	return format == FormatCSV || format == FormatNDJSON
+}
+
+// BannerEncoder writes banner records to an export stream.
+type BannerEncoder interface {
+	Encode(record BannerRecord) error
+	Flush() error
+}
+
+func NewBannerEncoder(format string, w io.Writer) (BannerEncoder, error) {
+	switch format {
+	case FormatCSV:
+		writer := csv.NewWriter(w)
+		if err := writer.Write(csvHeader); err != nil {
+			return nil, err
+		}
+		return &csvEncoder{writer: writer}, nil
+	case FormatNDJSON:
+		return &ndjsonEncoder{encoder: json.NewEncoder(w)}, nil
+	default:
+		return nil, fmt.Errorf("unsupported format: %s", format)
+	}
+}
+
+type csvEncoder struct {
+	writer *csv.Writer
+}
+
+func (e *csvEncoder) Encode(record BannerRecord) error {
+	tags := make([]string, 0, len(record.Tags))
+	for _, tag := range record.Tags {
+		tags = append(tags, strconv.FormatInt(tag, 10))
+	}
+	return e.writer.Write([]string{
+		strconv.FormatInt(record.BannerId, 10),
+		strconv.FormatInt(record.FeatureId, 10),
+		strings.Join(tags, ";"),
+		record.Content.Title,
+		record.Content.Text,
+		record.Content.Url,
+		strconv.FormatBool(record.IsActive),
+		record.CreatedAt,
+		record.UpdatedAt,
+	})
+}
+
+func (e *csvEncoder) Flush() error {
+	e.writer.Flush()
+	return e.writer.Error()
+}
+
+type ndjsonEncoder struct {
+	encoder *json.Encoder
+}
+
+func (e *ndjsonEncoder) Encode(record BannerRecord) error {
+	return e.encoder.Encode(record)
+}
+
+func (e *ndjsonEncoder) Flush() error {
+	return nil
+}
+
+// DecodeBannerRecords reads every record of an import file. Rows that can not
+// be decoded are reported by line number instead of aborting the whole file.
+func DecodeBannerRecords(format string, r io.Reader) ([]ImportRow, []ImportRowError, error) {
+	switch format {
+	case FormatCSV:
+		return decodeCSV(r)
+	case FormatNDJSON:
+		return decodeNDJSON(r)
+	default:
+		return nil, nil, fmt.Errorf("unsupported format: %s", format)
+	}
+}
+
+func decodeCSV(r io.Reader) ([]ImportRow, []ImportRowError, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = len(csvHeader)
+	header, err := reader.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid csv header: %s", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+	for _, name := range csvHeader {
+		if _, ok := columns[name]; !ok {
+			return nil, nil, fmt.Errorf("invalid csv header: missing column %s", name)
+		}
+	}
+
+	var rows []ImportRow
+	var rowErrors []ImportRowError
+	for {
+		fields, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		line, _ := reader.FieldPos(0)
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				rowErrors = append(rowErrors, ImportRowError{Line: parseErr.Line, Error: parseErr.Err.Error()})
+				continue
+			}
+			return nil, nil, err
+		}
+		record, err := csvRecord(columns, fields)
+		if err != nil {
+			rowErrors = append(rowErrors, ImportRowError{Line: line, Error: err.Error()})
+			continue
+		}
+		rows = append(rows, ImportRow{Line: line, Record: record})
+	}
+	return rows, rowErrors, nil
+}
+
+func csvRecord(columns map[string]int, fields []string) (BannerRecord, error) {
+	var record BannerRecord
+	var err error
+	field := func(name string) string {
+		return strings.TrimSpace(fields[columns[name]])
+	}
+	if param := field("banner_id"); param != "" {
+		record.BannerId, err = strconv.ParseInt(param, 10, 64)
+		if err != nil {
+			return record, fmt.Errorf("invalid banner_id format: %s", err)
+		}
+	}
+	record.FeatureId, err = strconv.ParseInt(field("feature_id"), 10, 64)
+	if err != nil {
+		return record, fmt.Errorf("invalid feature_id format: %s", err)
+	}
+	if param := field("tag_ids"); param != "" {
+		for _, tag := range strings.Split(param, ";") {
+			tagId, err := strconv.ParseInt(strings.TrimSpace(tag), 10, 64)
+			if err != nil {
+				return record, fmt.Errorf("invalid tag_ids format: %s", err)
+			}
+			record.Tags = append(record.Tags, tagId)
+		}
+	}
+	record.IsActive, err = strconv.ParseBool(field("is_active"))
+	if err != nil {
+		return record, fmt.Errorf("invalid is_active format: %s", err)
+	}
+	record.Content = Content{
+		Title: fields[columns["title"]],
+		Text:  fields[columns["text"]],
+		Url:   fields[columns["url"]],
+	}
+	record.CreatedAt = field("created_at")
+	record.UpdatedAt = field("updated_at")
+	return record, nil
+}
+
+func decodeNDJSON(r io.Reader) ([]ImportRow, []ImportRowError, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	var rows []ImportRow
+	var rowErrors []ImportRowError
+	line := 0
+	for scanner.Scan() {
+		line++
+		raw := strings.TrimSpace(scanner.Text())
+		if raw == "" {
+			continue
+		}
+		var record BannerRecord
+		if err := json.Unmarshal([]byte(raw), &record); err != nil {
+			rowErrors = append(rowErrors, ImportRowError{Line: line, Error: fmt.Sprintf("invalid json: %s", err)})
+			continue
+		}
+		rows = append(rows, ImportRow{Line: line, Record: record})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	return rows, rowErrors, nil
+}
@@ -0,0 +1,63 @@
+package grpcserver
+
+import (
+	"context"
+	"errors"
+	"github.com/Paincake/avito-tech/internal/server"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"log/slog"
+	"net/http"
+	"os"
+)
+
+// ErrorDomain is the domain of the ErrorInfo detail carrying the error code
+// the REST API would return.
+const ErrorDomain = "banner.v1"
+
+var errorLogger = slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: server.LogLevel}))
+
+// httpCodes maps the statuses of server.ToAPIError to gRPC codes.
+var httpCodes = map[int]codes.Code{
+	http.StatusBadRequest:            codes.InvalidArgument,
+	http.StatusUnauthorized:          codes.Unauthenticated,
+	http.StatusForbidden:             codes.PermissionDenied,
+	http.StatusNotFound:              codes.NotFound,
+	http.StatusConflict:              codes.FailedPrecondition,
+	http.StatusRequestEntityTooLarge: codes.ResourceExhausted,
+	http.StatusUnprocessableEntity:   codes.InvalidArgument,
+	http.StatusTooManyRequests:       codes.ResourceExhausted,
+	http.StatusServiceUnavailable:    codes.Unavailable,
+}
+
+// toStatus maps err through server.ToAPIError, so both APIs classify errors
+// the same way, and attaches its code as an ErrorInfo reason.
+func toStatus(err error) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	apiErr := server.ToAPIError(err)
+	code, ok := httpCodes[apiErr.Status]
+	switch {
+	case errors.Is(err, context.Canceled):
+		code = codes.Canceled
+	case apiErr.Code == server.CodeUnauthorized:
+		// REST answers some token errors with 400 for compatibility
+		code = codes.Unauthenticated
+	case apiErr.Code == server.CodeTimeout:
+		code = codes.DeadlineExceeded
+	case apiErr.Code == server.CodeConflict:
+		code = codes.AlreadyExists
+	case !ok:
+		code = codes.Internal
+	}
+	if code == codes.Internal {
+		errorLogger.Error("RPC_FAILED", slog.String("err", err.Error()))
+	}
+	st := status.New(code, apiErr.Message)
+	if withInfo, err := st.WithDetails(&errdetails.ErrorInfo{Reason: apiErr.Code, Domain: ErrorDomain}); err == nil {
+		st = withInfo
+	}
+	return st.Err()
+}
@@ -0,0 +1,347 @@
+package grpcserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	bannerv1 "github.com/Paincake/avito-tech/api/banner/v1"
+	"github.com/Paincake/avito-tech/internal/config"
+	"github.com/Paincake/avito-tech/internal/database"
+	"github.com/Paincake/avito-tech/internal/dto"
+	"github.com/Paincake/avito-tech/internal/server"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"gopkg.in/validator.v2"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+const (
+	// TokenMetadataKey carries the token issued by Login.
+	TokenMetadataKey = "token"
+	// APIKeyMetadataKey carries an API key, in place of a token.
+	APIKeyMetadataKey = "x-api-key"
+	// TenantMetadataKey names the tenant of the user calling Login, the
+	// default one when absent.
+	TenantMetadataKey    = "x-tenant-id"
+	RequestIdMetadataKey = "x-request-id"
+	// DegradedMetadataKey is sent in the header of responses served from
+	// last-known banners because the database could not be queried.
+	DegradedMetadataKey = "x-banner-degraded"
+	// RetryAfterMetadataKey is sent in the header of rate limited calls with
+	// the seconds to wait before retrying.
+	RetryAfterMetadataKey = "retry-after"
+)
+
+type claimsContextKey struct{}
+
+// Server implements bannerv1.BannerServiceServer on top of the same
+// ServerInterface, and so the same repository and cache, as the REST API.
+type Server struct {
+	bannerv1.UnimplementedBannerServiceServer
+	Handler server.ServerInterface
+	Options config.Config
+	// Limiter applies the rate limits of the REST API, from the same buckets.
+	// A nil Limiter does not limit calls.
+	Limiter *server.RateLimiter
+}
+
+// New returns a gRPC server serving handler, which authenticates every call
+// but Login, rate limits calls with limiter and bounds calls without a
+// deadline by Options.RequestTimeout.
+func New(handler server.ServerInterface, options config.Config, limiter *server.RateLimiter, opts ...grpc.ServerOption) *grpc.Server {
+	s := &Server{Handler: handler, Options: options, Limiter: limiter}
+	opts = append(opts, grpc.ChainUnaryInterceptor(s.deadline, s.authenticate, s.rateLimit))
+	g := grpc.NewServer(opts...)
+	bannerv1.RegisterBannerServiceServer(g, s)
+	return g
+}
+
+func (s *Server) deadline(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if _, ok := ctx.Deadline(); !ok && s.Options.RequestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.Options.RequestTimeout)
+		defer cancel()
+	}
+	return handler(ctx, req)
+}
+
+func (s *Server) authenticate(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if info.FullMethod == bannerv1.BannerService_Login_FullMethodName {
+		return handler(ctx, req)
+	}
+	claims, err := server.Authenticate(ctx, firstMetadata(ctx, TokenMetadataKey), firstMetadata(ctx, APIKeyMetadataKey))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	ctx = database.WithTenant(ctx, claims.Tenant)
+	return handler(context.WithValue(ctx, claimsContextKey{}, claims), req)
+}
+
+// rateLimit limits Login, GetUserBanner with use_last_revision, which
+// bypasses the cache, and admin writes like their REST counterparts.
+func (s *Server) rateLimit(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if s.Limiter == nil {
+		return handler(ctx, req)
+	}
+	class := rateLimitClass(ctx, req, info)
+	if class == "" {
+		return handler(ctx, req)
+	}
+	allowed, retryAfter := s.Limiter.Allow(ctx, class, rateLimitKey(ctx))
+	if !allowed {
+		_ = grpc.SetHeader(ctx, metadata.Pairs(RetryAfterMetadataKey, strconv.Itoa(retryAfter)))
+		return nil, toStatus(&server.APIError{Status: http.StatusTooManyRequests, Code: server.CodeRateLimited, Message: "rate limit exceeded"})
+	}
+	return handler(ctx, req)
+}
+
+func rateLimitClass(ctx context.Context, req any, info *grpc.UnaryServerInfo) string {
+	switch info.FullMethod {
+	case bannerv1.BannerService_Login_FullMethodName:
+		return server.RateLimitLogin
+	case bannerv1.BannerService_GetUserBanner_FullMethodName:
+		if r, ok := req.(*bannerv1.GetUserBannerRequest); ok && r.UseLastRevision {
+			return server.RateLimitLatest
+		}
+	case bannerv1.BannerService_CreateBanner_FullMethodName,
+		bannerv1.BannerService_UpdateBanner_FullMethodName,
+		bannerv1.BannerService_DeleteBanner_FullMethodName:
+		if claimsFrom(ctx).Role == database.AdminRole {
+			return server.RateLimitAdmin
+		}
+	}
+	return ""
+}
+
+// rateLimitKey tells clients apart the way the REST API does, by the token
+// subject and else by address.
+func rateLimitKey(ctx context.Context) string {
+	if claims := claimsFrom(ctx); claims.Username != "" {
+		return server.SubjectRateLimitKey(claims.Tenant, claims.Username)
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		if host, _, err := net.SplitHostPort(p.Addr.String()); err == nil {
+			return "ip:" + host
+		}
+		return "ip:" + p.Addr.String()
+	}
+	return "ip:"
+}
+
+func firstMetadata(ctx context.Context, key string) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func claimsFrom(ctx context.Context) server.TokenClaims {
+	claims, _ := ctx.Value(claimsContextKey{}).(server.TokenClaims)
+	return claims
+}
+
+func actorFrom(ctx context.Context) dto.Actor {
+	claims := claimsFrom(ctx)
+	return dto.Actor{
+		Username:  claims.Username,
+		Role:      claims.Role,
+		RequestId: firstMetadata(ctx, RequestIdMetadataKey),
+	}
+}
+
+var errForbidden = status.Error(codes.PermissionDenied, "forbidden")
+
+func requireAdmin(ctx context.Context) error {
+	if claimsFrom(ctx).Role != database.AdminRole {
+		return errForbidden
+	}
+	return nil
+}
+
+func (s *Server) GetUserBanner(ctx context.Context, req *bannerv1.GetUserBannerRequest) (*bannerv1.GetUserBannerResponse, error) {
+	role := claimsFrom(ctx).Role
+	if role != database.AdminRole && role != database.UserRole {
+		return nil, errForbidden
+	}
+	if req.FeatureId == 0 || req.TagId == 0 {
+		return nil, status.Error(codes.InvalidArgument, "feature_id and tag_id are required")
+	}
+	ctx, degraded := server.WithDegradation(ctx)
+	content, err := s.Handler.GetUserBanner(ctx, dto.GetUserBannerParams{
+		TagId:        req.TagId,
+		FeatureId:    req.FeatureId,
+		LastRevision: req.UseLastRevision,
+		UseActive:    role != database.AdminRole,
+	})
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	if degraded() {
+		_ = grpc.SetHeader(ctx, metadata.Pairs(DegradedMetadataKey, "true"))
+	}
+	return &bannerv1.GetUserBannerResponse{Content: toContent(content)}, nil
+}
+
+func (s *Server) ListBanners(ctx context.Context, req *bannerv1.ListBannersRequest) (*bannerv1.ListBannersResponse, error) {
+	if err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	params, err := s.listParams(req)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	page, err := s.Handler.GetBanner(ctx, params)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	banners := make([]*bannerv1.Banner, 0, len(page.Banners))
+	for _, banner := range page.Banners {
+		banners = append(banners, toBanner(banner))
+	}
+	return &bannerv1.ListBannersResponse{Banners: banners, Total: page.Total, NextCursor: page.NextCursor}, nil
+}
+
+// listParams applies the defaults and checks of GET /banner to req.
+func (s *Server) listParams(req *bannerv1.ListBannersRequest) (dto.GetBannerParams, error) {
+	params := dto.GetBannerParams{
+		FeatureId: dto.DefaultIdValue,
+		TagIds:    req.TagIds,
+		Limit:     s.Options.DefaultPageLimit,
+		Offset:    int(req.Offset),
+		Query:     req.Query,
+		Sort:      dto.SortById,
+		Order:     dto.OrderAsc,
+	}
+	if req.FeatureId != 0 {
+		params.FeatureId = req.FeatureId
+	}
+	if req.Limit != 0 {
+		if req.Limit < 1 || int(req.Limit) > s.Options.MaxPageLimit {
+			return params, fmt.Errorf("limit must be between 1 and %d", s.Options.MaxPageLimit)
+		}
+		params.Limit = int(req.Limit)
+	}
+	if req.Offset < 0 {
+		return params, errors.New("offset must not be negative")
+	}
+	if req.Cursor != "" {
+		if req.Offset != 0 {
+			return params, errors.New("cursor and offset can not be used together")
+		}
+		cursor, err := dto.DecodeBannerCursor(req.Cursor)
+		if err != nil {
+			return params, err
+		}
+		if cursor.Sort != params.Sort || cursor.Order != params.Order {
+			return params, errors.New("cursor does not match sort and order")
+		}
+		params.Cursor = cursor
+	}
+	return params, nil
+}
+
+func (s *Server) CreateBanner(ctx context.Context, req *bannerv1.CreateBannerRequest) (*bannerv1.CreateBannerResponse, error) {
+	if err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	banner, err := fromBanner(req.Banner)
+	if err != nil {
+		return nil, err
+	}
+	id, err := s.Handler.PostBanner(ctx, banner, actorFrom(ctx))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &bannerv1.CreateBannerResponse{BannerId: id}, nil
+}
+
+func (s *Server) UpdateBanner(ctx context.Context, req *bannerv1.UpdateBannerRequest) (*bannerv1.UpdateBannerResponse, error) {
+	if err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	banner, err := fromBanner(req.Banner)
+	if err != nil {
+		return nil, err
+	}
+	err = s.Handler.PatchBannerID(ctx, dto.PatchBannerIdParams{BannerId: req.BannerId}, banner, actorFrom(ctx))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &bannerv1.UpdateBannerResponse{}, nil
+}
+
+func (s *Server) DeleteBanner(ctx context.Context, req *bannerv1.DeleteBannerRequest) (*bannerv1.DeleteBannerResponse, error) {
+	if err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	err := s.Handler.DeleteBannerID(ctx, dto.DeleteBannerIdParams{BannerId: req.BannerId}, actorFrom(ctx))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &bannerv1.DeleteBannerResponse{}, nil
+}
+
+func (s *Server) Login(ctx context.Context, req *bannerv1.LoginRequest) (*bannerv1.LoginResponse, error) {
+	tenant := database.DefaultTenant
+	if value := firstMetadata(ctx, TenantMetadataKey); value != "" {
+		var err error
+		tenant, err = strconv.ParseInt(value, 10, 64)
+		if err != nil || tenant < 1 {
+			return nil, status.Error(codes.InvalidArgument, TenantMetadataKey+" must be a positive integer")
+		}
+	}
+	claims, err := s.Handler.Login(database.WithTenant(ctx, tenant), req.Username, req.Password)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	token, err := server.CreateTenantJWT(claims.Tenant, claims.Username, claims.Role)
+	if err != nil {
+		return nil, toStatus(fmt.Errorf("error generating token: %w", err))
+	}
+	return &bannerv1.LoginResponse{Token: token}, nil
+}
+
+func toContent(content dto.Content) *bannerv1.Content {
+	return &bannerv1.Content{Title: content.Title, Text: content.Text, Url: content.Url}
+}
+
+func toBanner(banner dto.Banner) *bannerv1.Banner {
+	return &bannerv1.Banner{
+		TagIds:    banner.Tags,
+		FeatureId: banner.FeatureId,
+		Content:   toContent(banner.Content),
+		IsActive:  banner.IsActive,
+		CreatedAt: banner.CreatedAt,
+		UpdatedAt: banner.UpdatedAt,
+		Status:    banner.Status,
+	}
+}
+
+// fromBanner converts and validates a banner the way the REST body is.
+func fromBanner(banner *bannerv1.Banner) (dto.Banner, error) {
+	if banner == nil {
+		return dto.Banner{}, status.Error(codes.InvalidArgument, "banner is required")
+	}
+	result := dto.Banner{
+		Tags:      banner.TagIds,
+		FeatureId: banner.FeatureId,
+		Content: dto.Content{
+			Title: banner.GetContent().GetTitle(),
+			Text:  banner.GetContent().GetText(),
+			Url:   banner.GetContent().GetUrl(),
+		},
+		IsActive:  banner.IsActive,
+		CreatedAt: banner.CreatedAt,
+		UpdatedAt: banner.UpdatedAt,
+	}
+	if err := validator.Validate(result); err != nil {
+		return dto.Banner{}, status.Error(codes.InvalidArgument, "invalid banner: "+err.Error())
+	}
+	return result, nil
+}
@@ -0,0 +1,246 @@
+package grpcserver
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	bannerv1 "github.com/Paincake/avito-tech/api/banner/v1"
+	"github.com/Paincake/avito-tech/internal/config"
+	"github.com/Paincake/avito-tech/internal/database"
+	"github.com/Paincake/avito-tech/internal/database/postgres"
+	"github.com/Paincake/avito-tech/internal/dto"
+	"github.com/Paincake/avito-tech/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"net"
+	"testing"
+	"time"
+)
+
+// fakeHandler serves a single published banner of the default tenant for
+// feature 1 and tag 1. Every tenant has the same users.
+type fakeHandler struct {
+	server.ServerInterface
+	posted []dto.Banner
+}
+
+func (h *fakeHandler) Login(ctx context.Context, username, password string) (server.TokenClaims, error) {
+	tenant, err := database.TenantOf(ctx)
+	if err != nil {
+		return server.TokenClaims{}, err
+	}
+	if password != "secret" {
+		return server.TokenClaims{}, postgres.InvalidCredentials{Err: errors.New("wrong password")}
+	}
+	claims := server.TokenClaims{Username: username, Role: database.UserRole, Tenant: tenant}
+	if username == "admin" {
+		claims.Role = database.AdminRole
+	}
+	return claims, nil
+}
+
+func (h *fakeHandler) GetUserBanner(ctx context.Context, params dto.GetUserBannerParams) (dto.Content, error) {
+	tenant, err := database.TenantOf(ctx)
+	if err != nil {
+		return dto.Content{}, err
+	}
+	if tenant != database.DefaultTenant || params.FeatureId != 1 || params.TagId != 1 {
+		return dto.Content{}, postgres.EntityNotFound{Err: errors.New("banner not found")}
+	}
+	return dto.Content{Title: "title", Text: "text", Url: "url"}, nil
+}
+
+func (h *fakeHandler) PostBanner(_ context.Context, banner dto.Banner, actor dto.Actor) (int64, error) {
+	if banner.FeatureId == 404 {
+		return 0, postgres.InvalidReference{Err: errors.New("feature 404")}
+	}
+	h.posted = append(h.posted, banner)
+	return int64(len(h.posted)), nil
+}
+
+func (h *fakeHandler) DeleteBannerID(context.Context, dto.DeleteBannerIdParams, dto.Actor) error {
+	return postgres.EntityNotFound{Err: errors.New("banner not found")}
+}
+
+func dial(t *testing.T, handler server.ServerInterface) bannerv1.BannerServiceClient {
+	return dialLimited(t, handler, nil)
+}
+
+func dialLimited(t *testing.T, handler server.ServerInterface, limiter *server.RateLimiter) bannerv1.BannerServiceClient {
+	server.ConfigureAuth("test-secret", time.Hour)
+	listener := bufconn.Listen(1 << 20)
+	s := New(handler, config.Config{RequestTimeout: time.Second, DefaultPageLimit: 50, MaxPageLimit: 100}, limiter)
+	go func() { _ = s.Serve(listener) }()
+	t.Cleanup(s.Stop)
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	return bannerv1.NewBannerServiceClient(conn)
+}
+
+func login(t *testing.T, client bannerv1.BannerServiceClient, username string) context.Context {
+	return loginTo(t, client, "", username)
+}
+
+// loginTo logs in to tenant, the default one when empty.
+func loginTo(t *testing.T, client bannerv1.BannerServiceClient, tenant, username string) context.Context {
+	ctx := context.Background()
+	if tenant != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, TenantMetadataKey, tenant)
+	}
+	resp, err := client.Login(ctx, &bannerv1.LoginRequest{Username: username, Password: "secret"})
+	require.NoError(t, err)
+	return metadata.AppendToOutgoingContext(context.Background(), TokenMetadataKey, resp.Token)
+}
+
+func TestGetUserBanner_ShouldServeContentToAuthenticatedUser(t *testing.T) {
+	client := dial(t, &fakeHandler{})
+	ctx := login(t, client, "user")
+
+	resp, err := client.GetUserBanner(ctx, &bannerv1.GetUserBannerRequest{FeatureId: 1, TagId: 1})
+	require.NoError(t, err)
+	assert.Equal(t, "title", resp.Content.Title)
+
+	_, err = client.GetUserBanner(ctx, &bannerv1.GetUserBannerRequest{FeatureId: 2, TagId: 1})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	_, err = client.GetUserBanner(ctx, &bannerv1.GetUserBannerRequest{FeatureId: 1})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestGetUserBanner_ShouldBeScopedToTheTenantOfTheToken(t *testing.T) {
+	client := dial(t, &fakeHandler{})
+	ctx := loginTo(t, client, "2", "user")
+
+	_, err := client.GetUserBanner(ctx, &bannerv1.GetUserBannerRequest{FeatureId: 1, TagId: 1})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+// apiKeyFinder knows the API keys of its map, by the hex SHA-256 of the key.
+type apiKeyFinder map[string]database.APIKey
+
+func (f apiKeyFinder) SelectAPIKeyByHash(_ context.Context, hash string) (database.APIKey, error) {
+	key, ok := f[hash]
+	if !ok {
+		return database.APIKey{}, postgres.EntityNotFound{Err: errors.New("API key not found")}
+	}
+	return key, nil
+}
+
+func TestAuthenticate_ShouldAcceptAPIKeysOfTheirTenant(t *testing.T) {
+	finder := apiKeyFinder{}
+	for key, tenant := range map[string]int64{"default-key": database.DefaultTenant, "other-key": 2} {
+		sum := sha256.Sum256([]byte(key))
+		finder[hex.EncodeToString(sum[:])] = database.APIKey{Name: key, Role: database.UserRole, TenantID: tenant}
+	}
+	server.ConfigureAPIKeys(finder)
+	t.Cleanup(func() { server.ConfigureAPIKeys(nil) })
+	client := dial(t, &fakeHandler{})
+	withKey := func(key string) context.Context {
+		return metadata.AppendToOutgoingContext(context.Background(), APIKeyMetadataKey, key)
+	}
+	request := &bannerv1.GetUserBannerRequest{FeatureId: 1, TagId: 1}
+
+	_, err := client.GetUserBanner(withKey("default-key"), request)
+	assert.NoError(t, err)
+	_, err = client.GetUserBanner(withKey("other-key"), request)
+	assert.Equal(t, codes.NotFound, status.Code(err), "the banner is not of the tenant of the key")
+	_, err = client.GetUserBanner(withKey("unknown-key"), request)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestRateLimit_ShouldShareLimitsWithREST(t *testing.T) {
+	limiter := server.NewRateLimiter(server.NewMemoryRateLimitStore(), map[string]server.RateLimit{
+		server.RateLimitLatest: {Rate: 0.001, Burst: 1},
+		server.RateLimitLogin:  {Rate: 0.001, Burst: 2},
+	})
+	client := dialLimited(t, &fakeHandler{}, limiter)
+	ctx := login(t, client, "user")
+
+	request := &bannerv1.GetUserBannerRequest{FeatureId: 1, TagId: 1, UseLastRevision: true}
+	_, err := client.GetUserBanner(ctx, request)
+	require.NoError(t, err)
+	var header metadata.MD
+	_, err = client.GetUserBanner(ctx, request, grpc.Header(&header))
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.NotEmpty(t, header.Get(RetryAfterMetadataKey))
+	_, err = client.GetUserBanner(ctx, &bannerv1.GetUserBannerRequest{FeatureId: 1, TagId: 1})
+	assert.NoError(t, err, "cached reads are not limited")
+
+	// the REST API draws from the same bucket of the user
+	allowed, _ := limiter.Allow(context.Background(), server.RateLimitLatest, server.SubjectRateLimitKey(database.DefaultTenant, "user"))
+	assert.False(t, allowed)
+
+	login(t, client, "user")
+	_, err = client.Login(context.Background(), &bannerv1.LoginRequest{Username: "user", Password: "secret"})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+}
+
+func TestAuthenticate_ShouldRejectMissingOrInvalidToken(t *testing.T) {
+	client := dial(t, &fakeHandler{})
+	_, err := client.GetUserBanner(context.Background(), &bannerv1.GetUserBannerRequest{FeatureId: 1, TagId: 1})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), TokenMetadataKey, "not-a-token")
+	_, err = client.GetUserBanner(ctx, &bannerv1.GetUserBannerRequest{FeatureId: 1, TagId: 1})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = client.Login(context.Background(), &bannerv1.LoginRequest{Username: "user", Password: "wrong"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	ctx = metadata.AppendToOutgoingContext(context.Background(), TenantMetadataKey, "0")
+	_, err = client.Login(ctx, &bannerv1.LoginRequest{Username: "user", Password: "secret"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestCreateBanner_ShouldBeAdminOnly(t *testing.T) {
+	handler := &fakeHandler{}
+	client := dial(t, handler)
+	banner := &bannerv1.Banner{
+		TagIds: []int64{1, 2}, FeatureId: 1, IsActive: true,
+		Content:   &bannerv1.Content{Title: "title", Text: "text", Url: "url"},
+		CreatedAt: "2024-04-01T00:00:00Z", UpdatedAt: "2024-04-01T00:00:00Z",
+	}
+
+	_, err := client.CreateBanner(login(t, client, "user"), &bannerv1.CreateBannerRequest{Banner: banner})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	admin := login(t, client, "admin")
+	resp, err := client.CreateBanner(admin, &bannerv1.CreateBannerRequest{Banner: banner})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), resp.BannerId)
+	assert.Equal(t, []int64{1, 2}, handler.posted[0].Tags)
+
+	_, err = client.CreateBanner(admin, &bannerv1.CreateBannerRequest{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestToStatus_ShouldCarryErrorCode(t *testing.T) {
+	client := dial(t, &fakeHandler{})
+	admin := login(t, client, "admin")
+
+	_, err := client.DeleteBanner(admin, &bannerv1.DeleteBannerRequest{BannerId: 7})
+	st := status.Convert(err)
+	assert.Equal(t, codes.NotFound, st.Code())
+	require.Len(t, st.Details(), 1)
+	assert.Equal(t, server.CodeBannerNotFound, st.Details()[0].(*errdetails.ErrorInfo).Reason)
+
+	banner := &bannerv1.Banner{
+		TagIds: []int64{1}, FeatureId: 404,
+		Content:   &bannerv1.Content{Title: "title", Text: "text", Url: "url"},
+		CreatedAt: "2024-04-01T00:00:00Z", UpdatedAt: "2024-04-01T00:00:00Z",
+	}
+	_, err = client.CreateBanner(admin, &bannerv1.CreateBannerRequest{Banner: banner})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(toStatus(context.DeadlineExceeded)))
+	assert.Equal(t, codes.Internal, status.Code(toStatus(errors.New("boom"))))
+}
@@ -0,0 +1,184 @@
+package metrics
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const namespace = "banner_service"
+
+// Metrics holds the Prometheus collectors of the service. It implements
+// server.CacheMetrics, so a MemoryCache can report to it directly.
+type Metrics struct {
+	registry        *prometheus.Registry
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	cacheHits       prometheus.Counter
+	cacheStaleHits  prometheus.Counter
+	cacheDegraded   prometheus.Counter
+	cacheMisses     prometheus.Counter
+	cacheEvictions  prometheus.Counter
+	cacheLockWait   prometheus.Histogram
+}
+
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "Number of HTTP requests by route and status.",
+		}, []string{"method", "route", "status"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "HTTP request latency by route and status.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+		cacheHits: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "cache_hits_total",
+			Help:      "Number of user banner lookups served from the cache.",
+		}),
+		cacheStaleHits: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "cache_stale_hits_total",
+			Help:      "Number of user banner lookups served an expired entry while it is refreshed.",
+		}),
+		cacheDegraded: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "cache_degraded_hits_total",
+			Help:      "Number of user banner lookups served a last-known entry because the database failed.",
+		}),
+		cacheMisses: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "cache_misses_total",
+			Help:      "Number of user banner lookups not found in the cache.",
+		}),
+		cacheEvictions: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "cache_evictions_total",
+			Help:      "Number of entries removed from the cache as they expired or to stay within its limits.",
+		}),
+		cacheLockWait: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "cache_build_wait_seconds",
+			Help:      "Time spent waiting for the per-key lock before building a cache entry.",
+			Buckets:   []float64{.0001, .0005, .001, .005, .01, .05, .1, .5, 1},
+		}),
+	}
+	m.registry.MustRegister(
+		m.requests,
+		m.requestDuration,
+		m.cacheHits,
+		m.cacheStaleHits,
+		m.cacheDegraded,
+		m.cacheMisses,
+		m.cacheEvictions,
+		m.cacheLockWait,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// Middleware counts requests and observes their latency. Routes are labelled
+// by their registered path, so /banner/1 and /banner/2 share a series.
+func (m *Metrics) Middleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		start := time.Now()
+		err := next(c)
+		status := c.Response().Status
+		if err != nil {
+			var httpErr *echo.HTTPError
+			var statusErr interface{ HTTPStatus() int }
+			switch {
+			case errors.As(err, &httpErr):
+				status = httpErr.Code
+			case errors.As(err, &statusErr):
+				status = statusErr.HTTPStatus()
+			default:
+				status = http.StatusInternalServerError
+			}
+		}
+		route := c.Path()
+		if route == "" {
+			route = "unmatched"
+		}
+		labels := prometheus.Labels{
+			"method": c.Request().Method,
+			"route":  route,
+			"status": strconv.Itoa(status),
+		}
+		m.requests.With(labels).Inc()
+		m.requestDuration.With(labels).Observe(time.Since(start).Seconds())
+		return err
+	}
+}
+
+// Handler serves the metrics in the Prometheus exposition format.
+func (m *Metrics) Handler() echo.HandlerFunc {
+	return echo.WrapHandler(promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+}
+
+// RegisterCacheSize exposes the number of cached entries reported by size.
+func (m *Metrics) RegisterCacheSize(size func() int) {
+	m.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "cache_entries",
+		Help:      "Number of entries in the cache.",
+	}, func() float64 {
+		return float64(size())
+	}))
+}
+
+// RegisterCircuitBreaker exposes whether the database circuit breaker
+// rejects queries, as reported by open.
+func (m *Metrics) RegisterCircuitBreaker(open func() bool) {
+	m.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "db_circuit_breaker_open",
+		Help:      "Whether queries to the database are rejected by the circuit breaker.",
+	}, func() float64 {
+		if open() {
+			return 1
+		}
+		return 0
+	}))
+}
+
+// RegisterDB exposes the connection pool statistics of db.
+func (m *Metrics) RegisterDB(db *sql.DB) {
+	m.registry.MustRegister(collectors.NewDBStatsCollector(db, "postgres"))
+}
+
+func (m *Metrics) CacheHit() {
+	m.cacheHits.Inc()
+}
+
+func (m *Metrics) CacheStaleHit() {
+	m.cacheStaleHits.Inc()
+}
+
+func (m *Metrics) CacheDegradedHit() {
+	m.cacheDegraded.Inc()
+}
+
+func (m *Metrics) CacheMiss() {
+	m.cacheMisses.Inc()
+}
+
+func (m *Metrics) CacheEvicted(count int) {
+	m.cacheEvictions.Add(float64(count))
+}
+
+func (m *Metrics) CacheLockWait(wait time.Duration) {
+	m.cacheLockWait.Observe(wait.Seconds())
+}
@@ -0,0 +1,31 @@
+package metrics
+
+import (
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetrics_ShouldExposeRequestsByRoute(t *testing.T) {
+	m := New()
+	e := echo.New()
+	e.Use(m.Middleware)
+	e.GET("/banner/:id", func(c echo.Context) error {
+		return echo.NewHTTPError(http.StatusNotFound)
+	})
+	e.GET("/metrics", m.Handler())
+	m.CacheHit()
+
+	for _, target := range []string{"/banner/1", "/banner/2"} {
+		e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", target, nil))
+	}
+	recorder := httptest.NewRecorder()
+	e.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+
+	body := recorder.Body.String()
+	assert.True(t, strings.Contains(body, `banner_service_http_requests_total{method="GET",route="/banner/:id",status="404"} 2`), body)
+	assert.True(t, strings.Contains(body, "banner_service_cache_hits_total 1"), body)
+}
@@ -0,0 +1,67 @@
+// Package openapi embeds the OpenAPI 3 document of the banner API and serves
+// it together with a Swagger UI page.
+package openapi
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/labstack/echo/v4"
+	"net/http"
+)
+
+//go:embed openapi.yaml
+var document []byte
+
+// Load parses and validates the embedded document.
+func Load() (*openapi3.T, error) {
+	loader := openapi3.NewLoader()
+	doc, err := loader.LoadFromData(document)
+	if err != nil {
+		return nil, fmt.Errorf("error loading openapi document: %s", err)
+	}
+	if err = doc.Validate(context.Background()); err != nil {
+		return nil, fmt.Errorf("invalid openapi document: %s", err)
+	}
+	return doc, nil
+}
+
+// MustLoad is Load for callers that can not recover from an invalid
+// document; the embedded document is checked by the package tests.
+func MustLoad() *openapi3.T {
+	doc, err := Load()
+	if err != nil {
+		panic(err)
+	}
+	return doc
+}
+
+// Handler serves the document as JSON.
+func Handler(doc *openapi3.T) echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		return ctx.JSON(http.StatusOK, doc)
+	}
+}
+
+const swaggerUI = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>Banner service API</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+  <script>
+    window.ui = SwaggerUIBundle({url: "/openapi.json", dom_id: "#swagger-ui"});
+  </script>
+</body>
+</html>
+`
+
+// SwaggerUI serves a Swagger UI page rendering /openapi.json.
+func SwaggerUI(ctx echo.Context) error {
+	return ctx.HTML(http.StatusOK, swaggerUI)
+}
@@ -0,0 +1,12 @@
+package openapi
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestLoad_ShouldValidateDocument(t *testing.T) {
+	doc, err := Load()
+	assert.NoError(t, err)
+	assert.NotNil(t, doc.Paths.Find("/banner/{id}"))
+}
@@ -0,0 +1,130 @@
+package openapi
+
+import (
+	"errors"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"strings"
+)
+
+func init() {
+	openapi3filter.RegisterBodyDecoder("application/x-ndjson", openapi3filter.FileBodyDecoder)
+}
+
+// Violation is a part of a request that does not match the document,
+// located by a JSON pointer such as /query/limit or /body/content/title.
+type Violation struct {
+	Pointer string `json:"pointer"`
+	Message string `json:"message"`
+}
+
+// ValidationError is the body of a 400 response to a request the validator
+// rejected.
+type ValidationError struct {
+	Message string      `json:"message"`
+	Details []Violation `json:"details"`
+}
+
+// Validator checks path and query parameters, headers and bodies of the
+// requests to documented operations against the document before the handler
+// runs, and rejects the request listing every violation. Authentication is
+// left to VerifyJWT and undocumented paths pass through.
+func Validator(doc *openapi3.T) echo.MiddlewareFunc {
+	router, err := gorillamux.NewRouter(doc)
+	if err != nil {
+		panic(err)
+	}
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			request := c.Request()
+			route, pathParams, err := router.FindRoute(request)
+			if err != nil {
+				return next(c)
+			}
+			options := &openapi3filter.Options{
+				MultiError:         true,
+				AuthenticationFunc: openapi3filter.NoopAuthenticationFunc,
+			}
+			// Clients are not required to send Content-Type, the handlers
+			// decode the body by the route; so assume the only documented
+			// media type, and leave the body to the handler when there are
+			// several.
+			if body := route.Operation.RequestBody; body != nil && request.Header.Get(echo.HeaderContentType) == "" {
+				if content := body.Value.Content; len(content) == 1 {
+					for mediaType := range content {
+						request.Header.Set(echo.HeaderContentType, mediaType)
+					}
+				} else {
+					options.ExcludeRequestBody = true
+				}
+			}
+			err = openapi3filter.ValidateRequest(request.Context(), &openapi3filter.RequestValidationInput{
+				Request:    request,
+				PathParams: pathParams,
+				Route:      route,
+				Options:    options,
+			})
+			if err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, ValidationError{
+					Message: "invalid request",
+					Details: violations(err),
+				})
+			}
+			return next(c)
+		}
+	}
+}
+
+func violations(err error) []Violation {
+	var requestErr *openapi3filter.RequestError
+	switch err := err.(type) {
+	case openapi3.MultiError:
+		var result []Violation
+		for _, err := range err {
+			result = append(result, violations(err)...)
+		}
+		return result
+	case *openapi3filter.RequestError:
+		requestErr = err
+	default:
+		return []Violation{{Pointer: "", Message: err.Error()}}
+	}
+	pointer := ""
+	switch {
+	case requestErr.Parameter != nil:
+		pointer = "/" + requestErr.Parameter.In + "/" + escapePointer(requestErr.Parameter.Name)
+	case requestErr.RequestBody != nil:
+		pointer = "/body"
+	}
+	if requestErr.Err == nil {
+		return []Violation{{Pointer: pointer, Message: requestErr.Reason}}
+	}
+	return schemaViolations(requestErr.Err, pointer)
+}
+
+func schemaViolations(err error, pointer string) []Violation {
+	var multi openapi3.MultiError
+	if errors.As(err, &multi) {
+		var result []Violation
+		for _, err := range multi {
+			result = append(result, schemaViolations(err, pointer)...)
+		}
+		return result
+	}
+	var schemaErr *openapi3.SchemaError
+	if errors.As(err, &schemaErr) {
+		for _, part := range schemaErr.JSONPointer() {
+			pointer += "/" + escapePointer(part)
+		}
+		return []Violation{{Pointer: pointer, Message: schemaErr.Reason}}
+	}
+	return []Violation{{Pointer: pointer, Message: err.Error()}}
+}
+
+// escapePointer escapes a JSON pointer reference token, RFC 6901.
+func escapePointer(token string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(token)
+}
@@ -0,0 +1,63 @@
+package openapi
+
+import (
+	"encoding/json"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func serve(method, target, body string) *httptest.ResponseRecorder {
+	e := echo.New()
+	e.Use(Validator(MustLoad()))
+	ok := func(c echo.Context) error { return c.NoContent(http.StatusOK) }
+	e.GET("/banner", ok)
+	e.POST("/banner", ok)
+	e.PATCH("/banner/:id", ok)
+	e.GET("/user_banner", ok)
+	e.POST("/banner/import", ok)
+	e.GET("/metrics", ok)
+	recorder := httptest.NewRecorder()
+	e.ServeHTTP(recorder, httptest.NewRequest(method, target, strings.NewReader(body)))
+	return recorder
+}
+
+func pointers(t *testing.T, recorder *httptest.ResponseRecorder) []string {
+	t.Helper()
+	var body ValidationError
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &body))
+	var result []string
+	for _, violation := range body.Details {
+		result = append(result, violation.Pointer)
+	}
+	return result
+}
+
+func TestValidator_ShouldListEveryParameterViolation(t *testing.T) {
+	recorder := serve("GET", "/user_banner?feature_id=abc", "")
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	assert.ElementsMatch(t, []string{"/query/tag_id", "/query/feature_id"}, pointers(t, recorder))
+
+	recorder = serve("GET", "/banner?limit=0&sort=title", "")
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	assert.ElementsMatch(t, []string{"/query/limit", "/query/sort"}, pointers(t, recorder))
+}
+
+func TestValidator_ShouldPointIntoJSONBody(t *testing.T) {
+	recorder := serve("POST", "/banner", `{"tag_ids": [], "feature_id": 1, "content": {"title": "", "text": "b"}, "created_at": "x", "updated_at": "x"}`)
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	assert.ElementsMatch(t, []string{"/body/tag_ids", "/body/content/title", "/body/content/url"}, pointers(t, recorder))
+
+	recorder = serve("PATCH", "/banner/abc", `{}`)
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	assert.Contains(t, pointers(t, recorder), "/path/id")
+}
+
+func TestValidator_ShouldPassValidAndUndocumentedRequests(t *testing.T) {
+	assert.Equal(t, http.StatusOK, serve("GET", "/banner?tag_id=1&tag_id=2&limit=10", "").Code)
+	assert.Equal(t, http.StatusOK, serve("POST", "/banner/import?format=ndjson", "{broken\n").Code)
+	assert.Equal(t, http.StatusOK, serve("GET", "/metrics", "").Code)
+}
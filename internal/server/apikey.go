@@ -0,0 +1,134 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/Paincake/avito-tech/internal/database"
+	"github.com/Paincake/avito-tech/internal/database/postgres"
+	"github.com/Paincake/avito-tech/internal/dto"
+	"net/http"
+	"strconv"
+)
+
+// APIKeyHeader carries the API key of a request, in place of a Token.
+const APIKeyHeader = "X-Api-Key"
+
+// APIKeyFinder looks up the API key a request authenticates with, whatever
+// its tenant.
+type APIKeyFinder interface {
+	SelectAPIKeyByHash(ctx context.Context, hash string) (database.APIKey, error)
+}
+
+// apiKeys is set by ConfigureAPIKeys before the server starts; requests
+// carrying an API key are rejected while it is nil.
+var apiKeys APIKeyFinder
+
+// ConfigureAPIKeys sets where the API keys requests authenticate with are
+// looked up.
+func ConfigureAPIKeys(finder APIKeyFinder) {
+	apiKeys = finder
+}
+
+// hashAPIKey returns the hash an API key is stored and looked up by. Keys
+// are random, so a fast hash is enough.
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Authenticate verifies the credentials of a request for the REST and the
+// gRPC API alike: its API key when it has one, else its token. A request
+// authenticated with a key acts as "api-key:" followed by the key name.
+func Authenticate(ctx context.Context, token, apiKey string) (TokenClaims, error) {
+	if apiKey == "" {
+		return ParseToken(token)
+	}
+	invalid := &APIError{Status: http.StatusUnauthorized, Code: CodeUnauthorized, Message: "invalid API key"}
+	if apiKeys == nil {
+		return TokenClaims{}, invalid
+	}
+	key, err := apiKeys.SelectAPIKeyByHash(ctx, hashAPIKey(apiKey))
+	if err != nil {
+		var notFound postgres.EntityNotFound
+		if errors.As(err, &notFound) {
+			invalid.Err = err
+			return TokenClaims{}, invalid
+		}
+		return TokenClaims{}, err
+	}
+	return TokenClaims{Role: key.Role, Username: "api-key:" + key.Name, Tenant: key.TenantID}, nil
+}
+
+func validateAPIKey(key dto.APIKey) error {
+	if key.Role != database.UserRole && key.Role != database.AdminRole {
+		return fmt.Errorf("role must be %s or %s", database.UserRole, database.AdminRole)
+	}
+	return nil
+}
+
+func apiKeyNotFound(err error) error {
+	var notFound postgres.EntityNotFound
+	if errors.As(err, &notFound) {
+		return &APIError{Status: http.StatusNotFound, Code: CodeAPIKeyNotFound, Message: "API key not found", Err: err}
+	}
+	return err
+}
+
+// PostAPIKey issues a key authenticating as a user of the tenant of ctx with
+// the role of key. The key is returned once and only its hash is stored.
+func (s *Server) PostAPIKey(ctx context.Context, key dto.APIKey, actor dto.Actor) (dto.APIKey, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return dto.APIKey{}, err
+	}
+	var created dto.APIKey
+	err := s.Repository.InTransaction(ctx, func(repository database.BannerRepository) error {
+		id, err := repository.InsertAPIKey(ctx, key, hashAPIKey(hex.EncodeToString(secret)), actor.Username)
+		if err != nil {
+			return err
+		}
+		after, err := repository.SelectAPIKeyById(ctx, id)
+		if err != nil {
+			return err
+		}
+		created = database.ConvertAPIKeyToDto(after)
+		return audit(ctx, repository, actor, AuditActionCreate, AuditTargetAPIKey, strconv.FormatInt(id, 10), nil, &created)
+	})
+	if err != nil {
+		return dto.APIKey{}, err
+	}
+	created.Key = hex.EncodeToString(secret)
+	return created, nil
+}
+
+func (s *Server) GetAPIKeys(ctx context.Context) ([]dto.APIKey, error) {
+	keys, err := s.Repository.SelectAPIKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	dtoKeys := make([]dto.APIKey, 0, len(keys))
+	for _, key := range keys {
+		dtoKeys = append(dtoKeys, database.ConvertAPIKeyToDto(key))
+	}
+	return dtoKeys, nil
+}
+
+// DeleteAPIKeyID revokes an API key.
+func (s *Server) DeleteAPIKeyID(ctx context.Context, id int64, actor dto.Actor) error {
+	err := s.Repository.InTransaction(ctx, func(repository database.BannerRepository) error {
+		before, err := repository.SelectAPIKeyById(ctx, id)
+		if err != nil {
+			return err
+		}
+		if err = repository.DeleteAPIKeyById(ctx, id); err != nil {
+			return err
+		}
+		snapshot := database.ConvertAPIKeyToDto(before)
+		return audit(ctx, repository, actor, AuditActionDelete, AuditTargetAPIKey, strconv.FormatInt(id, 10), &snapshot, nil)
+	})
+	return apiKeyNotFound(err)
+}
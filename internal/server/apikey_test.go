@@ -0,0 +1,132 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/Paincake/avito-tech/internal/database"
+	"github.com/Paincake/avito-tech/internal/database/postgres"
+	"github.com/Paincake/avito-tech/internal/dto"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// keyRepository keeps API keys by the hash of their key.
+type keyRepository struct {
+	outboxRepository
+	keys   map[string]database.APIKey
+	nextID int64
+}
+
+func (r *keyRepository) InTransaction(ctx context.Context, fn func(repository database.BannerRepository) error) error {
+	return r.outboxRepository.InTransaction(ctx, func(database.BannerRepository) error { return fn(r) })
+}
+
+func (r *keyRepository) InsertAPIKey(ctx context.Context, key dto.APIKey, hash string, author string) (int64, error) {
+	tenant, err := database.TenantOf(ctx)
+	if err != nil {
+		return -1, err
+	}
+	r.nextID++
+	r.keys[hash] = database.APIKey{ID: r.nextID, TenantID: tenant, Name: key.Name, Role: key.Role, CreatedBy: author}
+	return r.nextID, nil
+}
+
+func (r *keyRepository) SelectAPIKeyById(ctx context.Context, id int64) (database.APIKey, error) {
+	tenant, err := database.TenantOf(ctx)
+	if err != nil {
+		return database.APIKey{}, err
+	}
+	for _, key := range r.keys {
+		if key.ID == id && key.TenantID == tenant {
+			return key, nil
+		}
+	}
+	return database.APIKey{}, postgres.EntityNotFound{Err: errors.New("API key not found")}
+}
+
+func (r *keyRepository) SelectAPIKeyByHash(_ context.Context, hash string) (database.APIKey, error) {
+	key, ok := r.keys[hash]
+	if !ok {
+		return database.APIKey{}, postgres.EntityNotFound{Err: errors.New("API key not found")}
+	}
+	return key, nil
+}
+
+func (r *keyRepository) DeleteAPIKeyById(ctx context.Context, id int64) error {
+	key, err := r.SelectAPIKeyById(ctx, id)
+	if err != nil {
+		return err
+	}
+	for hash, stored := range r.keys {
+		if stored.ID == key.ID {
+			delete(r.keys, hash)
+		}
+	}
+	return nil
+}
+
+func TestAPIKey_ShouldAuthenticateAsItsTenantUntilRevoked(t *testing.T) {
+	repository := &keyRepository{keys: map[string]database.APIKey{}}
+	ConfigureAPIKeys(repository)
+	t.Cleanup(func() { ConfigureAPIKeys(nil) })
+	s := &Server{Repository: repository}
+	ctx := database.WithTenant(context.Background(), 2)
+
+	created, err := s.PostAPIKey(ctx, dto.APIKey{Name: "ci", Role: database.AdminRole}, dto.Actor{Username: "admin"})
+	require.NoError(t, err)
+	assert.NotEmpty(t, created.Key)
+	assert.NotContains(t, repository.keys, created.Key, "only the hash of the key is stored")
+
+	e := echo.New()
+	e.HTTPErrorHandler = ErrorHandler
+	e.Use(VerifyJWT)
+	e.GET("/banner", func(c echo.Context) error {
+		tenant, _ := database.TenantOf(c.Request().Context())
+		return c.JSON(http.StatusOK, map[string]any{"tenant": tenant, "actor": dto.NewActor(c)})
+	})
+	get := func(key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/banner", nil)
+		req.Header.Set(APIKeyHeader, key)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+	rec := get(created.Key)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var body struct {
+		Tenant int64
+		Actor  dto.Actor
+	}
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&body))
+	assert.Equal(t, int64(2), body.Tenant)
+	assert.Equal(t, "api-key:ci", body.Actor.Username)
+	assert.Equal(t, database.AdminRole, body.Actor.Role)
+	assert.Equal(t, http.StatusUnauthorized, get("not-a-key").Code)
+
+	require.NoError(t, s.DeleteAPIKeyID(ctx, created.Id, dto.Actor{Username: "admin"}))
+	assert.Equal(t, http.StatusUnauthorized, get(created.Key).Code, "revoked keys are rejected")
+	assert.Equal(t, CodeAPIKeyNotFound, ToAPIError(s.DeleteAPIKeyID(ctx, created.Id, dto.Actor{Username: "admin"})).Code)
+}
+
+func TestPostAPIKey_ShouldNotIssueSuperAdminKeys(t *testing.T) {
+	repository := &keyRepository{keys: map[string]database.APIKey{}}
+	wrapper := &ServerInterfaceWrapper{Handler: &Server{Repository: repository}}
+	for role, want := range map[string]int{database.SuperAdminRole: http.StatusBadRequest, database.UserRole: http.StatusCreated} {
+		req := httptest.NewRequest(http.MethodPost, "/api_keys", strings.NewReader(`{"name": "`+role+`", "role": "`+role+`"}`))
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req.WithContext(tenantContext()), rec)
+		c.Set(dto.TokenRoleContextKey, database.AdminRole)
+		if err := wrapper.PostAPIKey(c); err != nil {
+			assert.Equal(t, want, ToAPIError(err).Status, role)
+			continue
+		}
+		assert.Equal(t, want, rec.Code, role)
+	}
+	assert.Len(t, repository.keys, 1)
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/Paincake/avito-tech/internal/database"
+	"github.com/Paincake/avito-tech/internal/dto"
+	"reflect"
+	"strconv"
+)
+
+const (
+	AuditActionCreate = "create"
+	AuditActionUpdate = "update"
+	AuditActionDelete = "delete"
+
+	AuditTargetBanner  = "banner"
+	AuditTargetUser    = "user"
+	AuditTargetWebhook = "webhook"
+	AuditTargetTenant  = "tenant"
+	AuditTargetFeature = "feature"
+	AuditTargetTag     = "tag"
+	AuditTargetAPIKey  = "api_key"
+)
+
+// audit writes an entry of the audit log through repository, which should be
+// the transaction the change itself was made in. before and after are
+// pointers or maps holding the target snapshots, nil when the target did not
+// exist.
+func audit(ctx context.Context, repository database.BannerRepository, actor dto.Actor, action, targetType, targetId string, before, after any) error {
+	beforeJSON, beforeFields, err := auditSnapshot(before)
+	if err != nil {
+		return err
+	}
+	afterJSON, afterFields, err := auditSnapshot(after)
+	if err != nil {
+		return err
+	}
+	diff := make(map[string]dto.FieldChanges)
+	for field, value := range afterFields {
+		if previous, ok := beforeFields[field]; !ok || !reflect.DeepEqual(previous, value) {
+			diff[field] = dto.FieldChanges{Before: beforeFields[field], After: value}
+		}
+	}
+	for field, value := range beforeFields {
+		if _, ok := afterFields[field]; !ok {
+			diff[field] = dto.FieldChanges{Before: value}
+		}
+	}
+	diffJSON, err := json.Marshal(diff)
+	if err != nil {
+		return err
+	}
+	return repository.InsertAuditEntry(ctx, database.AuditEntry{
+		Actor:      actor.Username,
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetId,
+		Before:     beforeJSON,
+		After:      afterJSON,
+		Diff:       diffJSON,
+		RequestID:  actor.RequestId,
+	})
+}
+
+func auditSnapshot(target any) ([]byte, map[string]any, error) {
+	if target == nil || reflect.ValueOf(target).IsNil() {
+		return nil, nil, nil
+	}
+	raw, err := json.Marshal(target)
+	if err != nil {
+		return nil, nil, err
+	}
+	var fields map[string]any
+	if err = json.Unmarshal(raw, &fields); err != nil {
+		return nil, nil, err
+	}
+	return raw, fields, nil
+}
+
+// bannerSnapshot returns the current state of a banner for the audit log.
+func bannerSnapshot(ctx context.Context, repository database.BannerRepository, id int64) (*dto.BannerRecord, error) {
+	banner, err := repository.SelectBannerById(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	dtoBanner, err := database.ConvertBannerToDto(banner)
+	if err != nil {
+		return nil, err
+	}
+	return &dto.BannerRecord{BannerId: id, Banner: dtoBanner}, nil
+}
+
+func bannerTarget(id int64) string {
+	return strconv.FormatInt(id, 10)
+}
+
+func (s *Server) GetAudit(ctx context.Context, params dto.GetAuditParams) ([]dto.AuditEntry, error) {
+	entries, err := s.Repository.SelectAuditEntries(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	dtoEntries := make([]dto.AuditEntry, 0, len(entries))
+	for _, entry := range entries {
+		dtoEntry, err := database.ConvertAuditEntryToDto(entry)
+		if err != nil {
+			return nil, err
+		}
+		dtoEntries = append(dtoEntries, dtoEntry)
+	}
+	return dtoEntries, nil
+}
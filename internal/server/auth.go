@@ -1,18 +1,103 @@
 package server
 
 import (
+	"fmt"
+	"github.com/Paincake/avito-tech/internal/database"
 	"github.com/golang-jwt/jwt"
+	"log/slog"
+	"net/http"
 	"os"
+	"time"
 )
 
+// jwtSecret and jwtTTL are set from the config by ConfigureAuth before the
+// server starts.
+var (
+	jwtSecret []byte
+	jwtTTL    time.Duration
+)
+
+// ConfigureAuth sets the key tokens are signed and verified with and how long
+// issued tokens stay valid; a zero ttl issues tokens that do not expire.
+func ConfigureAuth(secret string, ttl time.Duration) {
+	jwtSecret = []byte(secret)
+	jwtTTL = ttl
+}
+
 func CreateJWT(role string) (string, error) {
-	var sampleSecretKey = []byte(os.Getenv("JWT_SECRET_KEY"))
+	return CreateUserJWT("", role)
+}
+
+// CreateUserJWT issues a token carrying the username in the sub claim, so
+// handlers can tell which user performs an action.
+func CreateUserJWT(username, role string) (string, error) {
+	return CreateTenantJWT(database.DefaultTenant, username, role)
+}
+
+// CreateTenantJWT issues a token of a user of tenant, whose data is the only
+// one the token gives access to.
+func CreateTenantJWT(tenant int64, username, role string) (string, error) {
 	token := jwt.New(jwt.SigningMethodHS256)
 	claims := token.Claims.(jwt.MapClaims)
 	claims["role"] = role
-	tokenString, err := token.SignedString(sampleSecretKey)
+	claims["tenant"] = tenant
+	if username != "" {
+		claims["sub"] = username
+	}
+	if jwtTTL > 0 {
+		claims["exp"] = time.Now().Add(jwtTTL).Unix()
+	}
+	tokenString, err := token.SignedString(jwtSecret)
 	if err != nil {
 		return "", err
 	}
 	return tokenString, nil
 }
+
+// TokenClaims are the claims of a verified token.
+type TokenClaims struct {
+	Role     string
+	Username string
+	Tenant   int64
+}
+
+// ParseToken verifies a token issued by CreateUserJWT, for the REST and the
+// gRPC API alike.
+func ParseToken(tokenString string) (TokenClaims, error) {
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: LogLevel}))
+	if tokenString == "" {
+		logger.Debug("Request discarded: auth failed: token absent")
+		return TokenClaims{}, &APIError{Status: http.StatusBadRequest, Code: CodeUnauthorized, Message: "Token header absent"}
+	}
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		return jwtSecret, nil
+	})
+	if err != nil {
+		logger.Debug("Request discarded: auth failed")
+		return TokenClaims{}, &APIError{Status: http.StatusBadRequest, Code: CodeUnauthorized, Message: "invalid token", Err: err}
+	}
+	if !token.Valid {
+		logger.Debug("Request discarded: auth failed: invalid token")
+		return TokenClaims{}, &APIError{Status: http.StatusUnauthorized, Code: CodeUnauthorized, Message: "invalid token"}
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		logger.Debug("Request discarded: auth failed: required claim absent")
+		return TokenClaims{}, &APIError{Status: http.StatusUnauthorized, Code: CodeUnauthorized, Message: "token claims absent"}
+	}
+	var result TokenClaims
+	result.Role, _ = claims["role"].(string)
+	result.Username, _ = claims["sub"].(string)
+	// tokens issued before tenants existed belong to the default one
+	result.Tenant = database.DefaultTenant
+	if tenant, ok := claims["tenant"]; ok {
+		id, ok := tenant.(float64)
+		if !ok || id < 1 || id != float64(int64(id)) {
+			logger.Debug("Request discarded: auth failed: invalid tenant claim")
+			return TokenClaims{}, &APIError{Status: http.StatusUnauthorized, Code: CodeUnauthorized, Message: "invalid token"}
+		}
+		result.Tenant = int64(id)
+	}
+	logger.Debug(fmt.Sprintf("User with claims %s authenticated", result.Role))
+	return result, nil
+}
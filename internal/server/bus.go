@@ -0,0 +1,196 @@
+package server
+
+import (
+	"github.com/Paincake/avito-tech/internal/dto"
+	"slices"
+	"sync"
+)
+
+const (
+	BannerEventCreated     = "created"
+	BannerEventUpdated     = "updated"
+	BannerEventDeactivated = "deactivated"
+	BannerEventDeleted     = "deleted"
+	// The steps of the review of a banner; it is shown to users from
+	// BannerEventPublished until BannerEventWithdrawn.
+	BannerEventSubmitted = "submitted"
+	BannerEventApproved  = "approved"
+	BannerEventRejected  = "rejected"
+	BannerEventPublished = "published"
+	BannerEventWithdrawn = "withdrawn"
+)
+
+// BannerEvent is a change to a banner. Banner is the state after the change
+// and is nil for deleted banners.
+type BannerEvent struct {
+	Id       uint64      `json:"id"`
+	Type     string      `json:"type"`
+	BannerId int64       `json:"banner_id"`
+	Banner   *dto.Banner `json:"banner,omitempty"`
+	// tenant owns the banner, keys are the feature and tag pairs addressing
+	// it before and after the change.
+	tenant int64
+	keys   []dto.UserBannerKey
+}
+
+// newBannerEvent builds the event of a change from the banner before and
+// after it; either may be nil.
+func newBannerEvent(before, after *dto.BannerRecord) BannerEvent {
+	event := BannerEvent{}
+	for _, record := range []*dto.BannerRecord{before, after} {
+		if record == nil {
+			continue
+		}
+		event.BannerId = record.BannerId
+		for _, tagId := range record.Tags {
+			event.keys = append(event.keys, dto.UserBannerKey{FeatureId: record.FeatureId, TagId: tagId})
+		}
+	}
+	switch {
+	case before == nil:
+		event.Type = BannerEventCreated
+	case after == nil:
+		event.Type = BannerEventDeleted
+	case before.IsActive && !after.IsActive:
+		event.Type = BannerEventDeactivated
+	default:
+		event.Type = BannerEventUpdated
+	}
+	if after != nil {
+		banner := after.Banner
+		event.Banner = &banner
+	}
+	return event
+}
+
+// Matches reports whether the event concerns the banner of key in tenant.
+func (e BannerEvent) Matches(tenant int64, key dto.UserBannerKey) bool {
+	return e.tenant == tenant && slices.Contains(e.keys, key)
+}
+
+// ChangeBus fans banner changes out to the subscribers of this process. It
+// numbers events and keeps the last ones so that a subscriber can resume
+// after the last event it saw.
+type ChangeBus struct {
+	mu          sync.Mutex
+	lastId      uint64
+	history     []BannerEvent
+	historySize int
+	bufferSize  int
+	subscribers map[*Subscription]struct{}
+	closed      bool
+}
+
+// NewChangeBus keeps historySize events for resumption and buffers up to
+// bufferSize events per subscriber.
+func NewChangeBus(historySize, bufferSize int) *ChangeBus {
+	return &ChangeBus{
+		historySize: historySize,
+		bufferSize:  bufferSize,
+		subscribers: make(map[*Subscription]struct{}),
+	}
+}
+
+// Publish numbers and delivers events of the banners of tenant. A subscriber
+// too slow to take an event is dropped rather than allowed to block the
+// publisher; it can resume from the history. Publishing on a nil bus does
+// nothing.
+func (b *ChangeBus) Publish(tenant int64, events ...BannerEvent) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, event := range events {
+		b.lastId++
+		event.Id = b.lastId
+		event.tenant = tenant
+		b.history = append(b.history, event)
+		if len(b.history) > b.historySize {
+			b.history = slices.Delete(b.history, 0, len(b.history)-b.historySize)
+		}
+		for subscriber := range b.subscribers {
+			if !event.Matches(subscriber.tenant, subscriber.key) {
+				continue
+			}
+			select {
+			case subscriber.events <- event:
+			default:
+				subscriber.lagged = true
+				b.unsubscribe(subscriber)
+			}
+		}
+	}
+}
+
+// Subscribe delivers the future events matching key in tenant. The events after
+// lastEventId still in the history are returned for replay; complete is
+// false when some of them are gone, or lastEventId is unknown, and the
+// subscriber has to reload the banner instead.
+func (b *ChangeBus) Subscribe(tenant int64, key dto.UserBannerKey, lastEventId uint64) (subscription *Subscription, replay []BannerEvent, complete bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	subscription = &Subscription{bus: b, tenant: tenant, key: key, events: make(chan BannerEvent, b.bufferSize)}
+	if b.closed {
+		close(subscription.events)
+		return subscription, nil, true
+	}
+	b.subscribers[subscription] = struct{}{}
+	if lastEventId == 0 {
+		return subscription, nil, true
+	}
+	complete = lastEventId == b.lastId ||
+		(lastEventId < b.lastId && len(b.history) > 0 && b.history[0].Id <= lastEventId+1)
+	for _, event := range b.history {
+		if event.Id > lastEventId && event.Matches(tenant, key) {
+			replay = append(replay, event)
+		}
+	}
+	return subscription, replay, complete
+}
+
+// Close ends every subscription, e.g. when the server shuts down.
+func (b *ChangeBus) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for subscriber := range b.subscribers {
+		b.unsubscribe(subscriber)
+	}
+}
+
+func (b *ChangeBus) unsubscribe(subscription *Subscription) {
+	if _, ok := b.subscribers[subscription]; ok {
+		delete(b.subscribers, subscription)
+		close(subscription.events)
+	}
+}
+
+// Subscription receives the events of a single key of a tenant until it is
+// closed.
+type Subscription struct {
+	bus    *ChangeBus
+	tenant int64
+	key    dto.UserBannerKey
+	events chan BannerEvent
+	lagged bool
+}
+
+// Events is closed when the subscription ends.
+func (s *Subscription) Events() <-chan BannerEvent {
+	return s.events
+}
+
+// Lagged reports whether the subscription was ended because its buffer
+// was full.
+func (s *Subscription) Lagged() bool {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	return s.lagged
+}
+
+func (s *Subscription) Close() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	s.bus.unsubscribe(s)
+}
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"github.com/Paincake/avito-tech/internal/config"
+	"github.com/Paincake/avito-tech/internal/database"
+	"github.com/Paincake/avito-tech/internal/dto"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func record(id, featureId int64, isActive bool, tags ...int64) *dto.BannerRecord {
+	return &dto.BannerRecord{BannerId: id, Banner: dto.Banner{FeatureId: featureId, Tags: tags, IsActive: isActive, Status: dto.StatusPublished}}
+}
+
+func TestChangeBus_ShouldDeliverMatchingEventsAndReplayHistory(t *testing.T) {
+	bus := NewChangeBus(2, 4)
+	key := dto.UserBannerKey{FeatureId: 1, TagId: 2}
+	subscription, replay, complete := bus.Subscribe(database.DefaultTenant, key, 0)
+	assert.Empty(t, replay)
+	assert.True(t, complete)
+	other, _, _ := bus.Subscribe(2, key, 0)
+
+	bus.Publish(database.DefaultTenant, newBannerEvent(nil, record(7, 1, true, 2, 3)))
+	bus.Publish(database.DefaultTenant, newBannerEvent(nil, record(8, 1, true, 4)))
+	bus.Publish(database.DefaultTenant, newBannerEvent(record(7, 1, true, 2, 3), record(7, 1, false, 3)))
+	event := <-subscription.Events()
+	assert.Equal(t, uint64(1), event.Id)
+	assert.Equal(t, BannerEventCreated, event.Type)
+	event = <-subscription.Events()
+	assert.Equal(t, uint64(3), event.Id)
+	assert.Equal(t, BannerEventDeactivated, event.Type, "the old tags still match")
+	subscription.Close()
+	_, ok := <-subscription.Events()
+	assert.False(t, ok)
+	assert.Empty(t, other.Events(), "events of another tenant are not delivered")
+
+	_, replay, complete = bus.Subscribe(database.DefaultTenant, key, 2)
+	assert.True(t, complete)
+	if assert.Len(t, replay, 1) {
+		assert.Equal(t, uint64(3), replay[0].Id)
+	}
+	_, replay, complete = bus.Subscribe(database.DefaultTenant, key, 1)
+	assert.True(t, complete, "event 2 is still in the history")
+	assert.Len(t, replay, 1)
+	bus.Publish(database.DefaultTenant, newBannerEvent(record(8, 1, true, 4), nil))
+	_, _, complete = bus.Subscribe(database.DefaultTenant, key, 1)
+	assert.False(t, complete, "event 2 fell out of the history")
+	_, _, complete = bus.Subscribe(database.DefaultTenant, key, 9)
+	assert.False(t, complete, "event 9 was never published")
+}
+
+func TestChangeBus_ShouldDropSubscriberWithFullBuffer(t *testing.T) {
+	bus := NewChangeBus(8, 1)
+	subscription, _, _ := bus.Subscribe(database.DefaultTenant, dto.UserBannerKey{FeatureId: 1, TagId: 1}, 0)
+	bus.Publish(database.DefaultTenant, newBannerEvent(nil, record(1, 1, true, 1)), newBannerEvent(record(1, 1, true, 1), nil))
+
+	_, ok := <-subscription.Events()
+	assert.True(t, ok)
+	_, ok = <-subscription.Events()
+	assert.False(t, ok)
+	assert.True(t, subscription.Lagged())
+	subscription.Close()
+}
+
+func TestStreamUserBanner_ShouldSendEventsAndHeartbeats(t *testing.T) {
+	bus := NewChangeBus(8, 8)
+	bus.Publish(database.DefaultTenant, newBannerEvent(nil, record(1, 1, true, 1)))
+	wrapper := &ServerInterfaceWrapper{
+		Handler: &Server{Bus: bus},
+		Options: config.Config{StreamHeartbeatInterval: 10 * time.Millisecond},
+	}
+	e := echo.New()
+	e.GET("/user_banner/stream", wrapper.StreamUserBanner, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Set(dto.TokenRoleContextKey, database.UserRole)
+			c.SetRequest(c.Request().WithContext(database.WithTenant(c.Request().Context(), database.DefaultTenant)))
+			return next(c)
+		}
+	})
+	ts := httptest.NewServer(e)
+	defer ts.Close()
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/user_banner/stream?feature_id=1&tag_id=1", nil)
+	require.NoError(t, err)
+	req.Header.Set("Last-Event-ID", "0")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get(echo.HeaderContentType))
+
+	lines := bufio.NewScanner(resp.Body)
+	next := func() string {
+		require.True(t, lines.Scan())
+		return lines.Text()
+	}
+	assert.Equal(t, ": heartbeat", next())
+	assert.Equal(t, "", next())
+
+	bus.Publish(database.DefaultTenant, newBannerEvent(record(1, 1, true, 1), record(1, 1, false, 1)))
+	for line := next(); line != "id: 2"; line = next() {
+		assert.True(t, line == ": heartbeat" || line == "", line)
+	}
+	assert.Equal(t, "event: deactivated", next())
+	data := next()
+	assert.True(t, strings.HasPrefix(data, "data: "))
+	assert.NotContains(t, data, `"banner"`, "users do not see inactive content")
+}
+
+func TestVisibleTo_ShouldHideUnpublishedContentFromUsers(t *testing.T) {
+	published := newBannerEvent(record(1, 1, true, 1), record(1, 1, true, 1))
+	assert.NotNil(t, visibleTo(database.UserRole, published).Banner)
+
+	draft := record(1, 1, true, 1)
+	draft.Status = dto.StatusDraft
+	updated := newBannerEvent(record(1, 1, true, 1), draft)
+	assert.Equal(t, BannerEventUpdated, updated.Type)
+	assert.Nil(t, visibleTo(database.UserRole, updated).Banner, "users do not see drafts")
+	assert.NotNil(t, visibleTo(database.AdminRole, updated).Banner)
+}
+
+func TestStreamUserBanner_ShouldResetUnknownLastEventId(t *testing.T) {
+	wrapper := &ServerInterfaceWrapper{
+		Handler: &Server{Bus: NewChangeBus(8, 8)},
+		Options: config.Config{StreamHeartbeatInterval: time.Hour},
+	}
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/user_banner/stream?feature_id=1&tag_id=1", nil)
+	req.Header.Set("Last-Event-ID", "42")
+	ctx, cancel := context.WithCancel(database.WithTenant(req.Context(), database.DefaultTenant))
+	defer cancel()
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req.WithContext(ctx), rec)
+	c.Set(dto.TokenRoleContextKey, database.AdminRole)
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	assert.NoError(t, wrapper.StreamUserBanner(c))
+	assert.Equal(t, "event: reset\ndata: {}\n\n", rec.Body.String())
+}
@@ -1,25 +1,81 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"github.com/Paincake/avito-tech/internal/database"
+	"github.com/Paincake/avito-tech/internal/database/postgres"
 	"github.com/Paincake/avito-tech/internal/dto"
 	"github.com/puzpuzpuz/xsync"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"log/slog"
 	"math/rand/v2"
 	"strconv"
-	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type BannerCache interface {
-	GetBanner(featureID int64, params dto.GetUserBannerParams) (database.UserBanner, error)
+	GetBanner(ctx context.Context, featureID int64, params dto.GetUserBannerParams) (database.UserBanner, error)
+	GetBanners(ctx context.Context, params dto.GetUserBannersParams) (map[dto.UserBannerKey]database.UserBanner, error)
+	Stats() dto.CacheStats
+	Flush(params dto.FlushCacheParams) int
+}
+
+// CacheMetrics receives instrumentation events from MemoryCache.
+type CacheMetrics interface {
+	CacheHit()
+	CacheStaleHit()
+	CacheDegradedHit()
+	CacheMiss()
+	CacheEvicted(count int)
+	CacheLockWait(wait time.Duration)
+}
+
+type noopCacheMetrics struct{}
+
+func (noopCacheMetrics) CacheHit()                   {}
+func (noopCacheMetrics) CacheStaleHit()              {}
+func (noopCacheMetrics) CacheDegradedHit()           {}
+func (noopCacheMetrics) CacheMiss()                  {}
+func (noopCacheMetrics) CacheEvicted(int)            {}
+func (noopCacheMetrics) CacheLockWait(time.Duration) {}
+
+// refreshTimeout bounds a background refresh of a stale entry.
+const refreshTimeout = 10 * time.Second
+
+type cacheExpiration struct {
+	minutesToKeyInvalidation float64
+	maxStalenessMinutes      float64
+	retentionMinutes         float64
+	schedulerRateMinute      int64
+}
+
+// cacheEntry is a cached banner along with the tenant and parameters it was
+// loaded with, to refresh it, and the time it was stored, shifted by a jitter
+// so that entries loaded together do not all expire together.
+type cacheEntry struct {
+	banner   database.UserBanner
+	tenant   int64
+	params   dto.GetUserBannerParams
+	storedAt time.Time
+}
+
+// cacheCounters count the lookups and removals reported by Stats.
+type cacheCounters struct {
+	hits, staleHits, degradedHits, misses, evictions, expirations atomic.Int64
 }
 
 type MemoryCache struct {
-	Repository               database.BannerRepository
-	Map                      xsync.Map
-	KeyLocks                 xsync.Map
-	MinutesToKeyInvalidation float64
-	SchedulerRateMinute      int64
+	Repository database.BannerRepository
+	KeyLocks   xsync.Map
+	Metrics    CacheMetrics
+	entries    *cacheStore
+	counters   cacheCounters
+	ready      atomic.Bool
+	expiration atomic.Pointer[cacheExpiration]
+	reschedule chan struct{}
 }
 
 func NewMemoryCache(repository database.BannerRepository,
@@ -27,88 +83,485 @@ func NewMemoryCache(repository database.BannerRepository,
 	schedulerRate int64,
 	done chan bool) *MemoryCache {
 	cache := &MemoryCache{
-		Repository:               repository,
-		Map:                      *xsync.NewMap(),
-		KeyLocks:                 *xsync.NewMap(),
-		MinutesToKeyInvalidation: minutesToKeyInval,
-		SchedulerRateMinute:      schedulerRate,
+		Repository: repository,
+		entries:    newCacheStore(),
+		KeyLocks:   *xsync.NewMap(),
+		Metrics:    noopCacheMetrics{},
+		reschedule: make(chan struct{}, 1),
 	}
+	cache.expiration.Store(&cacheExpiration{
+		minutesToKeyInvalidation: minutesToKeyInval,
+		schedulerRateMinute:      schedulerRate,
+	})
+	cache.ready.Store(true)
 
 	go func() {
-		time.Sleep(1 * time.Duration(cache.SchedulerRateMinute))
+		time.Sleep(1 * time.Duration(schedulerRate))
 		cache.cacheCleaningScheduler(done)
 	}()
 	return cache
 }
 
+// SetExpiration changes how long banners stay cached and how often expired
+// ones are evicted, without dropping the cached banners.
+func (c *MemoryCache) SetExpiration(minutesToKeyInval float64, schedulerRate int64) {
+	c.updateExpiration(func(expiration *cacheExpiration) {
+		expiration.minutesToKeyInvalidation = minutesToKeyInval
+		expiration.schedulerRateMinute = schedulerRate
+	})
+	select {
+	case c.reschedule <- struct{}{}:
+	default:
+	}
+}
+
+// SetMaxStaleness lets expired banners be served for up to minutes after
+// they were cached while a single background refresh reloads them. Past
+// that bound, or when it is not above the expiration, callers wait for the
+// banner to be reloaded.
+func (c *MemoryCache) SetMaxStaleness(minutes float64) {
+	c.updateExpiration(func(expiration *cacheExpiration) {
+		expiration.maxStalenessMinutes = minutes
+	})
+}
+
+// SetRetention keeps banners cached for up to minutes, past their max
+// staleness, as last-known values served when the repository fails to reload
+// them. A retention below the max staleness is raised to it.
+func (c *MemoryCache) SetRetention(minutes float64) {
+	c.updateExpiration(func(expiration *cacheExpiration) {
+		expiration.retentionMinutes = minutes
+	})
+}
+
+// SetLimits bounds the cache to maxEntries banners and maxBytes of memory,
+// evicting the least recently used banners past either; zero is unbounded.
+func (c *MemoryCache) SetLimits(maxEntries int, maxBytes int64) {
+	c.evicted(c.entries.setLimits(maxEntries, maxBytes))
+}
+
+func (c *MemoryCache) updateExpiration(update func(expiration *cacheExpiration)) {
+	for {
+		current := c.expiration.Load()
+		next := *current
+		update(&next)
+		if c.expiration.CompareAndSwap(current, &next) {
+			return
+		}
+	}
+}
+
+func (c *MemoryCache) minutesToKeyInvalidation() float64 {
+	return c.expiration.Load().minutesToKeyInvalidation
+}
+
+func (c *MemoryCache) maxStalenessMinutes() float64 {
+	expiration := c.expiration.Load()
+	return max(expiration.maxStalenessMinutes, expiration.minutesToKeyInvalidation)
+}
+
+func (c *MemoryCache) retentionMinutes() float64 {
+	return max(c.expiration.Load().retentionMinutes, c.maxStalenessMinutes())
+}
+
+func (c *MemoryCache) fresh(entry cacheEntry) bool {
+	return time.Since(entry.storedAt).Minutes() < c.minutesToKeyInvalidation()
+}
+
+// servable reports whether entry may still be served, fresh or stale.
+func (c *MemoryCache) servable(entry cacheEntry) bool {
+	return time.Since(entry.storedAt).Minutes() < c.maxStalenessMinutes()
+}
+
+// retained reports whether entry may still be served when the repository
+// fails.
+func (c *MemoryCache) retained(entry cacheEntry) bool {
+	return time.Since(entry.storedAt).Minutes() < c.retentionMinutes()
+}
+
+func (c *MemoryCache) load(key string) (cacheEntry, bool) {
+	return c.entries.get(key)
+}
+
+func (c *MemoryCache) store(tenant int64, params dto.GetUserBannerParams, banner database.UserBanner) {
+	jitter := addJitterSeconds(-15, 15)
+	c.evicted(c.entries.put(cacheKey(tenant, params.FeatureId, params.TagId), cacheEntry{
+		banner:   banner,
+		tenant:   tenant,
+		params:   params,
+		storedAt: time.Now().Add(time.Second * time.Duration(jitter)),
+	}))
+}
+
+func (c *MemoryCache) hit() {
+	c.counters.hits.Add(1)
+	c.Metrics.CacheHit()
+}
+
+func (c *MemoryCache) staleHit() {
+	c.counters.staleHits.Add(1)
+	c.Metrics.CacheStaleHit()
+}
+
+func (c *MemoryCache) degradedHit() {
+	c.counters.degradedHits.Add(1)
+	c.Metrics.CacheDegradedHit()
+}
+
+func (c *MemoryCache) miss() {
+	c.counters.misses.Add(1)
+	c.Metrics.CacheMiss()
+}
+
+// evicted counts entries removed to stay within the limits.
+func (c *MemoryCache) evicted(count int) {
+	if count > 0 {
+		c.counters.evictions.Add(int64(count))
+		c.Metrics.CacheEvicted(count)
+	}
+}
+
+// expireEntries removes the entries past the retention.
+func (c *MemoryCache) expireEntries() {
+	cutoff := time.Now().Add(-time.Duration(c.retentionMinutes() * float64(time.Minute)))
+	expired := c.entries.expire(cutoff)
+	c.counters.expirations.Add(int64(expired))
+	c.Metrics.CacheEvicted(expired)
+}
+
 func (c *MemoryCache) cacheCleaningScheduler(done chan bool) {
-	ticker := time.NewTicker(time.Minute * time.Duration(c.SchedulerRateMinute))
+	ticker := time.NewTicker(time.Minute * time.Duration(c.expiration.Load().schedulerRateMinute))
 	go func() {
 		for {
 			select {
 			case <-done:
 				ticker.Stop()
 				return
+			case <-c.reschedule:
+				ticker.Reset(time.Minute * time.Duration(c.expiration.Load().schedulerRateMinute))
 			case <-ticker.C:
-				mapCopy := make(map[string]database.UserBanner)
-				c.Map.Range(func(key string, value interface{}) bool {
-					mapCopy[key] = value.(database.UserBanner)
-					return true
-				})
-				for key := range mapCopy {
-					val, _ := c.Map.Load(key)
-					banner := val.(database.UserBanner)
-					if time.Since(banner.UpdatedAt).Minutes() > c.MinutesToKeyInvalidation {
-						c.Map.Delete(key)
-					}
-				}
+				// stale entries are kept as last-known values until the
+				// retention runs out
+				c.expireEntries()
 			}
 		}
 	}()
 }
 
+// cacheKey is the key of the banner a tenant shows for a feature and tag.
+func cacheKey(tenant, featureID, tagID int64) string {
+	return strconv.FormatInt(tenant, 10) + ":" + strconv.FormatInt(featureID, 10) + ":" + strconv.FormatInt(tagID, 10)
+}
+
 func addJitterSeconds(min, max int) int64 {
 	return int64(rand.IntN(max-min) + min)
 }
 
-func (c *MemoryCache) buildValue(featureID int64, params dto.GetUserBannerParams) (database.UserBanner, error) {
-	key := strconv.Itoa(int(featureID))
-	value, _ := c.KeyLocks.LoadOrStore(key, &sync.Mutex{})
-	mtx := value.(*sync.Mutex)
-	var content any
-	mtx.Lock()
-	defer func() {
-		mtx.Unlock()
-	}()
+// buildValue loads the banner of a missing key, letting a single caller per
+// key query the repository while the others wait for it. Waiters give up when
+// their context is done.
+func (c *MemoryCache) buildValue(ctx context.Context, tenant, featureID int64, params dto.GetUserBannerParams) (database.UserBanner, error) {
+	key := cacheKey(tenant, featureID, params.TagId)
+	ctx, span := tracer.Start(ctx, "MemoryCache.buildValue")
+	defer span.End()
+	waitStart := time.Now()
+	lock, err := c.lockKey(ctx, key)
+	if err != nil {
+		span.AddEvent("lock wait cancelled")
+		return database.UserBanner{}, err
+	}
+	wait := time.Since(waitStart)
+	c.Metrics.CacheLockWait(wait)
+	span.AddEvent("lock acquired", trace.WithAttributes(attribute.Int64("cache.lock_wait_us", wait.Microseconds())))
+	defer c.unlockKey(key, lock)
+
+	if entry, ok := c.load(key); ok && c.fresh(entry) {
+		return entry.banner, nil
+	}
+	banner, err := c.Repository.SelectUserBanner(ctx, params)
+	if err != nil {
+		return database.UserBanner{}, err
+	}
+	c.store(tenant, params, banner)
+	return banner, nil
+}
+
+// lockKey waits for the lock of key. A lock its holder has already dropped
+// from KeyLocks is stale, as a newcomer may hold the next one, so it is
+// released and the key locked again.
+func (c *MemoryCache) lockKey(ctx context.Context, key string) (chan struct{}, error) {
+	for {
+		value, _ := c.KeyLocks.LoadOrStore(key, make(chan struct{}, 1))
+		lock := value.(chan struct{})
+		select {
+		case lock <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if c.holdsKey(key, lock) {
+			return lock, nil
+		}
+		<-lock
+	}
+}
+
+// holdsKey reports whether lock is still the lock of key in KeyLocks.
+func (c *MemoryCache) holdsKey(key string, lock chan struct{}) bool {
+	current, ok := c.KeyLocks.Load(key)
+	return ok && current == any(lock)
+}
 
-	content, ok := c.Map.Load(key)
-	if ok && time.Since(content.(database.UserBanner).UpdatedAt).Minutes() < c.MinutesToKeyInvalidation {
-		return content.(database.UserBanner), nil
+// unlockKey ends the load of key, whatever its outcome. The lock is dropped
+// from KeyLocks, unless another lock replaced it, so that it does not keep an
+// entry per key ever loaded. It is dropped before being released: the
+// waiters then find it stale and queue on the next lock of the key along with
+// newcomers, instead of querying next to them.
+func (c *MemoryCache) unlockKey(key string, lock chan struct{}) {
+	if c.holdsKey(key, lock) {
+		c.KeyLocks.Delete(key)
+	}
+	<-lock
+}
+
+// refresh reloads a stale entry in the background unless a load of its key
+// is already running. The stale entry is served meanwhile, and dropped if
+// the banner is gone.
+func (c *MemoryCache) refresh(entry cacheEntry) {
+	params := entry.params
+	key := cacheKey(entry.tenant, params.FeatureId, params.TagId)
+	value, _ := c.KeyLocks.LoadOrStore(key, make(chan struct{}, 1))
+	lock := value.(chan struct{})
+	select {
+	case lock <- struct{}{}:
+	default:
+		return
+	}
+	if !c.holdsKey(key, lock) {
+		<-lock
+		return
 	}
-	banner, err := c.Repository.SelectUserBanner(params)
+	go func() {
+		defer c.unlockKey(key, lock)
+		ctx, cancel := context.WithTimeout(database.WithTenant(context.Background(), entry.tenant), refreshTimeout)
+		defer cancel()
+		ctx, span := tracer.Start(ctx, "MemoryCache.refresh")
+		defer span.End()
+		if entry, ok := c.load(key); ok && c.fresh(entry) {
+			return
+		}
+		banner, err := c.Repository.SelectUserBanner(ctx, params)
+		var notFound postgres.EntityNotFound
+		switch {
+		case errors.As(err, &notFound):
+			c.entries.delete(key)
+		case err != nil:
+			span.RecordError(err)
+			errorLogger.Warn("CACHE_REFRESH_FAILED", slog.String("key", key), slog.String("err", err.Error()))
+		default:
+			c.store(entry.tenant, params, banner)
+		}
+	}()
+}
+
+func (c *MemoryCache) GetBanner(ctx context.Context, featureID int64, params dto.GetUserBannerParams) (database.UserBanner, error) {
+	tenant, err := database.TenantOf(ctx)
 	if err != nil {
 		return database.UserBanner{}, err
 	}
+	ctx, span := tracer.Start(ctx, "MemoryCache.GetBanner")
+	defer span.End()
+	key := cacheKey(tenant, featureID, params.TagId)
+	entry, ok := c.load(key)
+	switch {
+	case ok && c.fresh(entry):
+		span.SetAttributes(attribute.Bool("cache.hit", true))
+		c.hit()
+		return entry.banner, nil
+	case ok && c.servable(entry):
+		span.SetAttributes(attribute.Bool("cache.hit", true), attribute.Bool("cache.stale", true))
+		c.staleHit()
+		c.refresh(entry)
+		return entry.banner, nil
+	}
+	span.SetAttributes(attribute.Bool("cache.hit", false))
+	c.miss()
+	banner, err := c.buildValue(ctx, tenant, featureID, params)
+	if err != nil && ok && c.retained(entry) && degradable(ctx, err) {
+		span.RecordError(err)
+		span.SetAttributes(attribute.Bool("cache.degraded", true))
+		c.degradedHit()
+		c.serveDegraded(ctx, err, slog.String("key", key))
+		return entry.banner, nil
+	}
+	return banner, err
+}
 
-	jitter := addJitterSeconds(-15, 15)
-	banner.UpdatedAt = banner.UpdatedAt.Add(time.Second * time.Duration(jitter))
+// serveDegraded records that last-known banners are served because loading
+// them failed with err.
+func (c *MemoryCache) serveDegraded(ctx context.Context, err error, keys slog.Attr) {
+	markDegraded(ctx)
+	errorLogger.Warn("CACHE_DEGRADED", keys, slog.String("err", err.Error()))
+}
 
-	content = banner
-	c.Map.Store(key, content)
-	c.KeyLocks.Delete(key)
-	return content.(database.UserBanner), nil
+// GetBanners serves the items of a batch from the cache, except the ones
+// asking for the last revision, and loads all the others with a single query,
+// caching them. Pairs without a banner are absent from the result.
+func (c *MemoryCache) GetBanners(ctx context.Context, params dto.GetUserBannersParams) (map[dto.UserBannerKey]database.UserBanner, error) {
+	tenant, err := database.TenantOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ctx, span := tracer.Start(ctx, "MemoryCache.GetBanners")
+	defer span.End()
+	latest := make(map[dto.UserBannerKey]bool, len(params.Items))
+	for _, item := range params.Items {
+		key := dto.UserBannerKey{FeatureId: item.FeatureId, TagId: item.TagId}
+		latest[key] = latest[key] || item.UseLastRevision
+	}
+	banners := make(map[dto.UserBannerKey]database.UserBanner, len(latest))
+	var missing []dto.UserBannerKey
+	for key, useLastRevision := range latest {
+		if !useLastRevision {
+			entry, ok := c.load(cacheKey(tenant, key.FeatureId, key.TagId))
+			switch {
+			case ok && c.fresh(entry):
+				c.hit()
+				banners[key] = entry.banner
+				continue
+			case ok && c.servable(entry):
+				c.staleHit()
+				c.refresh(entry)
+				banners[key] = entry.banner
+				continue
+			}
+			c.miss()
+		}
+		missing = append(missing, key)
+	}
+	span.SetAttributes(attribute.Int("cache.hits", len(banners)), attribute.Int("cache.misses", len(missing)))
+	if len(missing) == 0 {
+		return banners, nil
+	}
+	loaded, err := c.Repository.SelectUserBanners(ctx, missing, params.UseActive)
+	if err != nil {
+		return c.lastKnown(ctx, tenant, banners, missing, latest, err)
+	}
+	for key, banner := range loaded {
+		banners[key] = banner
+		c.store(tenant, dto.GetUserBannerParams{FeatureId: key.FeatureId, TagId: key.TagId, UseActive: params.UseActive}, banner)
+	}
+	return banners, nil
 }
 
-func (c *MemoryCache) GetBanner(featureID int64, params dto.GetUserBannerParams) (database.UserBanner, error) {
-	var err error
-	value, ok := c.Map.Load(strconv.Itoa(int(featureID)))
-	if !ok {
-		value, err = c.buildValue(featureID, params)
+// lastKnown completes banners with the last-known banners of the missing keys
+// when loading them failed with err. It fails when a key asks for the last
+// revision or has no retained banner, as its banner can not be told apart
+// from an absent one.
+func (c *MemoryCache) lastKnown(ctx context.Context, tenant int64, banners map[dto.UserBannerKey]database.UserBanner, missing []dto.UserBannerKey, latest map[dto.UserBannerKey]bool, err error) (map[dto.UserBannerKey]database.UserBanner, error) {
+	if !degradable(ctx, err) {
+		return nil, err
+	}
+	for _, key := range missing {
+		entry, ok := c.load(cacheKey(tenant, key.FeatureId, key.TagId))
+		if latest[key] || !ok || !c.retained(entry) {
+			return nil, err
+		}
+		banners[key] = entry.banner
+	}
+	for range missing {
+		c.degradedHit()
+	}
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Bool("cache.degraded", true))
+	c.serveDegraded(ctx, err, slog.Int("keys", len(missing)))
+	return banners, nil
+}
+
+// WarmUp preloads the banners users of every tenant are shown, failing
+// Ready until it is done. It returns at once; the result is sent on the returned channel. When
+// the warm-up fails the cache is reported ready anyway and loads banners on
+// demand.
+func (c *MemoryCache) WarmUp(ctx context.Context, timeout time.Duration) <-chan error {
+	c.ready.Store(false)
+	result := make(chan error, 1)
+	go func() {
+		defer c.ready.Store(true)
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		ctx, span := tracer.Start(ctx, "MemoryCache.WarmUp")
+		defer span.End()
+		tenants, err := c.Repository.SelectTenants(ctx)
 		if err != nil {
-			return database.UserBanner{}, err
+			span.RecordError(err)
+			result <- err
+			return
+		}
+		loaded := 0
+		for _, tenant := range tenants {
+			banners, err := c.Repository.SelectActiveUserBanners(database.WithTenant(ctx, tenant.ID))
+			if err != nil {
+				span.RecordError(err)
+				result <- err
+				return
+			}
+			for key, banner := range banners {
+				c.store(tenant.ID, dto.GetUserBannerParams{FeatureId: key.FeatureId, TagId: key.TagId, UseActive: true}, banner)
+			}
+			loaded += len(banners)
+		}
+		span.SetAttributes(attribute.Int("cache.size", loaded))
+		result <- nil
+	}()
+	return result
+}
+
+// Ready fails while the cache is not yet able to serve traffic.
+func (c *MemoryCache) Ready(ctx context.Context) error {
+	if !c.ready.Load() {
+		return errors.New("cache is not warmed up")
+	}
+	return nil
+}
+
+// Size returns the number of cached banners.
+func (c *MemoryCache) Size() int {
+	entries, _ := c.entries.size()
+	return entries
+}
+
+// Stats reports the size and limits of the cache and counts its lookups and
+// removals since it was created.
+func (c *MemoryCache) Stats() dto.CacheStats {
+	entries, bytes := c.entries.size()
+	maxEntries, maxBytes := c.entries.limits()
+	return dto.CacheStats{
+		Entries:      entries,
+		Bytes:        bytes,
+		MaxEntries:   maxEntries,
+		MaxBytes:     maxBytes,
+		Hits:         c.counters.hits.Load(),
+		StaleHits:    c.counters.staleHits.Load(),
+		DegradedHits: c.counters.degradedHits.Load(),
+		Misses:       c.counters.misses.Load(),
+		Evictions:    c.counters.evictions.Load(),
+		Expirations:  c.counters.expirations.Load(),
+	}
+}
+
+// Flush removes the banners of the tenant of params for a feature and tag,
+// for every tag of a feature, for every feature of a tag or, when params has
+// neither, all of them, and returns how many were removed.
+func (c *MemoryCache) Flush(params dto.FlushCacheParams) int {
+	if params.FeatureId != 0 && params.TagId != 0 {
+		if c.entries.delete(cacheKey(params.TenantId, params.FeatureId, params.TagId)) {
+			return 1
 		}
+		return 0
 	}
-	return value.(database.UserBanner), nil
+	return c.entries.deleteIf(func(entry cacheEntry) bool {
+		return entry.tenant == params.TenantId &&
+			(params.FeatureId == 0 || entry.params.FeatureId == params.FeatureId) &&
+			(params.TagId == 0 || entry.params.TagId == params.TagId)
+	})
 }
 
 func (c *MemoryCache) SetBanner(featureId int64, content dto.Content) error {
@@ -0,0 +1,366 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Paincake/avito-tech/internal/database"
+	"github.com/Paincake/avito-tech/internal/database/postgres"
+	"github.com/Paincake/avito-tech/internal/dto"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// tenantContext is the context of a request of the default tenant.
+func tenantContext() context.Context {
+	return database.WithTenant(context.Background(), database.DefaultTenant)
+}
+
+type blockingRepository struct {
+	database.BannerRepository
+	release chan struct{}
+}
+
+func (r *blockingRepository) SelectUserBanner(ctx context.Context, params dto.GetUserBannerParams) (database.UserBanner, error) {
+	<-r.release
+	return database.UserBanner{UpdatedAt: time.Now()}, nil
+}
+
+func TestMemoryCache_WaiterShouldGiveUpWhenContextIsDone(t *testing.T) {
+	repository := &blockingRepository{release: make(chan struct{})}
+	done := make(chan bool)
+	defer close(done)
+	cache := NewMemoryCache(repository, 5, 1, done)
+	params := dto.GetUserBannerParams{FeatureId: 1, TagId: 1}
+
+	builderDone := make(chan error)
+	go func() {
+		_, err := cache.GetBanner(tenantContext(), 1, params)
+		builderDone <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(tenantContext(), 20*time.Millisecond)
+	defer cancel()
+	_, err := cache.GetBanner(ctx, 1, params)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+
+	close(repository.release)
+	assert.NoError(t, <-builderDone)
+}
+
+type batchRepository struct {
+	database.BannerRepository
+	queries [][]dto.UserBannerKey
+}
+
+func (r *batchRepository) SelectUserBanners(ctx context.Context, keys []dto.UserBannerKey, useActive bool) (map[dto.UserBannerKey]database.UserBanner, error) {
+	r.queries = append(r.queries, keys)
+	banners := make(map[dto.UserBannerKey]database.UserBanner)
+	for _, key := range keys {
+		if key.TagId != 404 {
+			banners[key] = database.UserBanner{Title: fmt.Sprintf("%d:%d", key.FeatureId, key.TagId), UpdatedAt: time.Now()}
+		}
+	}
+	return banners, nil
+}
+
+func TestMemoryCache_GetBannersShouldLoadMissesInOneQuery(t *testing.T) {
+	repository := &batchRepository{}
+	done := make(chan bool)
+	defer close(done)
+	cache := NewMemoryCache(repository, 5, 1, done)
+	cache.store(database.DefaultTenant, dto.GetUserBannerParams{FeatureId: 1, TagId: 1}, database.UserBanner{Title: "cached"})
+	s := Server{Repository: repository, Cache: cache}
+
+	results, err := s.GetUserBanners(tenantContext(), dto.GetUserBannersParams{Items: []dto.UserBannerBatchItem{
+		{FeatureId: 1, TagId: 1},
+		{FeatureId: 1, TagId: 2},
+		{FeatureId: 2, TagId: 404},
+		{FeatureId: 3, TagId: 1, UseLastRevision: true},
+	}})
+	assert.NoError(t, err)
+	assert.Len(t, repository.queries, 1)
+	assert.ElementsMatch(t, []dto.UserBannerKey{{FeatureId: 1, TagId: 2}, {FeatureId: 2, TagId: 404}, {FeatureId: 3, TagId: 1}}, repository.queries[0])
+	if assert.Len(t, results, 4) {
+		assert.Equal(t, "cached", results[0].Content.Title)
+		assert.Equal(t, "1:2", results[1].Content.Title, "tags of a feature are cached apart")
+		assert.False(t, results[2].Found)
+		assert.Nil(t, results[2].Content)
+		assert.Equal(t, "3:1", results[3].Content.Title)
+	}
+
+	_, err = s.GetUserBanners(tenantContext(), dto.GetUserBannersParams{Items: []dto.UserBannerBatchItem{
+		{FeatureId: 1, TagId: 2},
+		{FeatureId: 3, TagId: 1},
+	}})
+	assert.NoError(t, err)
+	assert.Len(t, repository.queries, 1, "loaded banners are cached")
+}
+
+// tenantRepository serves banners titled after the tenant they are loaded
+// for.
+type tenantRepository struct {
+	database.BannerRepository
+}
+
+func (tenantRepository) SelectUserBanner(ctx context.Context, params dto.GetUserBannerParams) (database.UserBanner, error) {
+	tenant, err := database.TenantOf(ctx)
+	if err != nil {
+		return database.UserBanner{}, err
+	}
+	return database.UserBanner{Title: fmt.Sprint(tenant), UpdatedAt: time.Now()}, nil
+}
+
+func TestMemoryCache_ShouldKeepTenantsApart(t *testing.T) {
+	done := make(chan bool)
+	defer close(done)
+	cache := NewMemoryCache(tenantRepository{}, 5, 1, done)
+	params := dto.GetUserBannerParams{FeatureId: 1, TagId: 1}
+
+	banner, err := cache.GetBanner(tenantContext(), 1, params)
+	assert.NoError(t, err)
+	assert.Equal(t, "1", banner.Title)
+	banner, err = cache.GetBanner(database.WithTenant(context.Background(), 2), 1, params)
+	assert.NoError(t, err)
+	assert.Equal(t, "2", banner.Title, "the banner cached for tenant 1 is not served to tenant 2")
+
+	_, err = cache.GetBanner(context.Background(), 1, params)
+	assert.ErrorIs(t, err, database.ErrNoTenant)
+}
+
+// countingRepository serves a banner titled after the number of loads, each
+// load waiting for release.
+type countingRepository struct {
+	database.BannerRepository
+	mu      sync.Mutex
+	loads   int
+	release chan struct{}
+}
+
+func (r *countingRepository) SelectUserBanner(ctx context.Context, params dto.GetUserBannerParams) (database.UserBanner, error) {
+	<-r.release
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.loads++
+	return database.UserBanner{Title: fmt.Sprint(r.loads)}, nil
+}
+
+func (r *countingRepository) SelectActiveUserBanners(ctx context.Context) (map[dto.UserBannerKey]database.UserBanner, error) {
+	<-r.release
+	return map[dto.UserBannerKey]database.UserBanner{{FeatureId: 1, TagId: 1}: {Title: "warm"}}, nil
+}
+
+func (r *countingRepository) SelectTenants(ctx context.Context) ([]database.Tenant, error) {
+	return []database.Tenant{{ID: database.DefaultTenant}}, nil
+}
+
+func (r *countingRepository) Loads() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.loads
+}
+
+func TestMemoryCache_ShouldServeStaleWhileRefreshingOnce(t *testing.T) {
+	repository := &countingRepository{release: make(chan struct{})}
+	done := make(chan bool)
+	defer close(done)
+	cache := NewMemoryCache(repository, 5, 1, done)
+	cache.SetMaxStaleness(10)
+	params := dto.GetUserBannerParams{FeatureId: 1, TagId: 1}
+	cache.entries.put(cacheKey(database.DefaultTenant, 1, 1), cacheEntry{tenant: database.DefaultTenant, banner: database.UserBanner{Title: "stale"}, params: params, storedAt: time.Now().Add(-7 * time.Minute)})
+
+	for i := 0; i < 3; i++ {
+		banner, err := cache.GetBanner(tenantContext(), 1, params)
+		assert.NoError(t, err)
+		assert.Equal(t, "stale", banner.Title, "served without waiting for the refresh")
+	}
+	close(repository.release)
+	assert.Eventually(t, func() bool {
+		banner, _ := cache.GetBanner(tenantContext(), 1, params)
+		return banner.Title == "1"
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, 1, repository.Loads(), "a single refresh runs")
+
+	cache.entries.put(cacheKey(database.DefaultTenant, 1, 1), cacheEntry{tenant: database.DefaultTenant, banner: database.UserBanner{Title: "too old"}, params: params, storedAt: time.Now().Add(-11 * time.Minute)})
+	banner, err := cache.GetBanner(tenantContext(), 1, params)
+	assert.NoError(t, err)
+	assert.Equal(t, "2", banner.Title, "entries past the max staleness are reloaded before serving")
+}
+
+func TestMemoryCache_WarmUpShouldPreloadBeforeReady(t *testing.T) {
+	repository := &countingRepository{release: make(chan struct{})}
+	done := make(chan bool)
+	defer close(done)
+	cache := NewMemoryCache(repository, 5, 1, done)
+
+	warmedUp := cache.WarmUp(context.Background(), time.Second)
+	assert.Error(t, cache.Ready(context.Background()))
+	close(repository.release)
+	assert.NoError(t, <-warmedUp)
+	assert.NoError(t, cache.Ready(context.Background()))
+
+	banner, err := cache.GetBanner(tenantContext(), 1, dto.GetUserBannerParams{FeatureId: 1, TagId: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, "warm", banner.Title)
+	assert.Equal(t, 0, repository.Loads())
+}
+
+type unavailableRepository struct {
+	database.BannerRepository
+}
+
+func (unavailableRepository) SelectUserBanner(ctx context.Context, params dto.GetUserBannerParams) (database.UserBanner, error) {
+	return database.UserBanner{}, postgres.Unavailable{Err: postgres.ErrCircuitOpen}
+}
+
+func (unavailableRepository) SelectUserBanners(ctx context.Context, keys []dto.UserBannerKey, useActive bool) (map[dto.UserBannerKey]database.UserBanner, error) {
+	return nil, postgres.Unavailable{Err: postgres.ErrCircuitOpen}
+}
+
+func TestMemoryCache_ShouldServeLastKnownBannerWhenRepositoryFails(t *testing.T) {
+	done := make(chan bool)
+	defer close(done)
+	cache := NewMemoryCache(unavailableRepository{}, 5, 1, done)
+	cache.SetMaxStaleness(10)
+	cache.SetRetention(60)
+	params := dto.GetUserBannerParams{FeatureId: 1, TagId: 1}
+	cache.entries.put(cacheKey(database.DefaultTenant, 1, 1), cacheEntry{tenant: database.DefaultTenant, banner: database.UserBanner{Title: "last known"}, params: params, storedAt: time.Now().Add(-30 * time.Minute)})
+
+	ctx, degraded := WithDegradation(tenantContext())
+	banner, err := cache.GetBanner(ctx, 1, params)
+	assert.NoError(t, err)
+	assert.Equal(t, "last known", banner.Title)
+	assert.True(t, degraded())
+
+	banners, err := cache.GetBanners(ctx, dto.GetUserBannersParams{Items: []dto.UserBannerBatchItem{{FeatureId: 1, TagId: 1}}})
+	assert.NoError(t, err)
+	assert.Equal(t, "last known", banners[dto.UserBannerKey{FeatureId: 1, TagId: 1}].Title)
+	_, err = cache.GetBanners(ctx, dto.GetUserBannersParams{Items: []dto.UserBannerBatchItem{{FeatureId: 1, TagId: 1}, {FeatureId: 1, TagId: 2}}})
+	assert.ErrorAs(t, err, &postgres.Unavailable{}, "the banner of 1:2 is unknown")
+
+	cache.entries.put(cacheKey(database.DefaultTenant, 1, 1), cacheEntry{tenant: database.DefaultTenant, banner: database.UserBanner{Title: "too old"}, params: params, storedAt: time.Now().Add(-61 * time.Minute)})
+	ctx, degraded = WithDegradation(tenantContext())
+	_, err = cache.GetBanner(ctx, 1, params)
+	assert.Error(t, err, "entries past the retention are not served")
+	assert.False(t, degraded())
+}
+
+func TestMemoryCache_ShouldRetainBannersForAtLeastTheirMaxStaleness(t *testing.T) {
+	done := make(chan bool)
+	defer close(done)
+	cache := NewMemoryCache(unavailableRepository{}, 5, 1, done)
+	cache.SetMaxStaleness(10)
+	cache.SetRetention(1)
+	assert.Equal(t, float64(10), cache.retentionMinutes())
+	assert.True(t, cache.retained(cacheEntry{storedAt: time.Now().Add(-7 * time.Minute)}))
+	assert.False(t, cache.retained(cacheEntry{storedAt: time.Now().Add(-11 * time.Minute)}))
+}
+
+func TestMemoryCache_ShouldDropKeyLocksAfterLoads(t *testing.T) {
+	done := make(chan bool)
+	defer close(done)
+	cache := NewMemoryCache(unavailableRepository{}, 5, 1, done)
+	params := dto.GetUserBannerParams{FeatureId: 1, TagId: 1}
+
+	_, err := cache.GetBanner(tenantContext(), 1, params)
+	assert.Error(t, err)
+	assert.Equal(t, 0, cache.KeyLocks.Size(), "failed loads release their lock")
+
+	cache.SetMaxStaleness(10)
+	cache.entries.put(cacheKey(database.DefaultTenant, 1, 1), cacheEntry{tenant: database.DefaultTenant, banner: database.UserBanner{Title: "stale"}, params: params, storedAt: time.Now().Add(-7 * time.Minute)})
+	_, err = cache.GetBanner(tenantContext(), 1, params)
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool { return cache.KeyLocks.Size() == 0 }, time.Second, 5*time.Millisecond, "refreshes release their lock")
+}
+
+// failingRepository fails every load once released, tracking how many loads
+// run at once.
+type failingRepository struct {
+	database.BannerRepository
+	release     chan struct{}
+	calls       atomic.Int32
+	inFlight    atomic.Int32
+	maxInFlight atomic.Int32
+}
+
+func (r *failingRepository) SelectUserBanner(ctx context.Context, params dto.GetUserBannerParams) (database.UserBanner, error) {
+	r.calls.Add(1)
+	inFlight := r.inFlight.Add(1)
+	for maxInFlight := r.maxInFlight.Load(); inFlight > maxInFlight && !r.maxInFlight.CompareAndSwap(maxInFlight, inFlight); {
+		maxInFlight = r.maxInFlight.Load()
+	}
+	<-r.release
+	r.inFlight.Add(-1)
+	return database.UserBanner{}, postgres.Unavailable{Err: postgres.ErrCircuitOpen}
+}
+
+func TestMemoryCache_ShouldQueryOnceAtATimeWhileLoadsFail(t *testing.T) {
+	repository := &failingRepository{release: make(chan struct{})}
+	done := make(chan bool)
+	defer close(done)
+	cache := NewMemoryCache(repository, 5, 1, done)
+	params := dto.GetUserBannerParams{FeatureId: 1, TagId: 1}
+
+	var callers sync.WaitGroup
+	call := func() {
+		callers.Add(1)
+		go func() {
+			defer callers.Done()
+			_, err := cache.GetBanner(tenantContext(), 1, params)
+			assert.Error(t, err)
+		}()
+	}
+	for range 8 {
+		call()
+	}
+	for wave := 1; wave <= 16; wave++ {
+		if !assert.Eventually(t, func() bool { return repository.calls.Load() == int32(wave) }, time.Second, time.Millisecond) {
+			break
+		}
+		if wave <= 8 {
+			// a newcomer arrives as the running load fails
+			call()
+		}
+		select {
+		case repository.release <- struct{}{}:
+		case <-time.After(time.Second):
+			t.Fatalf("wave %d never queried the repository", wave)
+		}
+	}
+	close(repository.release)
+	callers.Wait()
+	assert.Equal(t, int32(1), repository.maxInFlight.Load(), "one repository call per wave")
+	assert.Equal(t, int32(16), repository.calls.Load())
+	assert.Equal(t, 0, cache.KeyLocks.Size())
+}
+
+func TestGetUserBanner_ShouldFlagDegradedResponse(t *testing.T) {
+	done := make(chan bool)
+	defer close(done)
+	cache := NewMemoryCache(unavailableRepository{}, 5, 1, done)
+	cache.SetRetention(60)
+	params := dto.GetUserBannerParams{FeatureId: 1, TagId: 1, UseActive: true}
+	cache.entries.put(cacheKey(database.DefaultTenant, 1, 1), cacheEntry{tenant: database.DefaultTenant, banner: database.UserBanner{Title: "last known"}, params: params, storedAt: time.Now().Add(-30 * time.Minute)})
+	wrapper := &ServerInterfaceWrapper{Handler: &Server{Repository: unavailableRepository{}, Cache: cache}}
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/user_banner?feature_id=1&tag_id=1", nil)
+	c := echo.New().NewContext(req.WithContext(tenantContext()), rec)
+	c.Set(dto.TokenRoleContextKey, database.UserRole)
+	assert.NoError(t, wrapper.GetUserBanner(c))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "true", rec.Header().Get(DegradedHeader))
+	assert.Equal(t, `111 - "Revalidation Failed"`, rec.Header().Get("Warning"))
+
+	req = httptest.NewRequest(http.MethodGet, "/user_banner?feature_id=1&tag_id=1&use_last_revision=true", nil)
+	c = echo.New().NewContext(req.WithContext(tenantContext()), httptest.NewRecorder())
+	c.Set(dto.TokenRoleContextKey, database.UserRole)
+	assert.Equal(t, http.StatusServiceUnavailable, ToAPIError(wrapper.GetUserBanner(c)).Status)
+}
@@ -0,0 +1,182 @@
+package server
+
+import (
+	"container/heap"
+	"container/list"
+	"sync"
+	"time"
+)
+
+// entryOverhead approximates the bytes a cached banner takes besides its
+// key and content: the entry, its list element, map slot and heap slot.
+const entryOverhead = 256
+
+// storeItem is a cached entry along with its place in the recency list and
+// the expiry heap.
+type storeItem struct {
+	key       string
+	entry     cacheEntry
+	size      int64
+	element   *list.Element
+	heapIndex int
+}
+
+// expiryHeap orders items by the time they were stored, which is the order
+// they expire in as every entry is kept for the same duration.
+type expiryHeap []*storeItem
+
+func (h expiryHeap) Len() int           { return len(h) }
+func (h expiryHeap) Less(i, j int) bool { return h[i].entry.storedAt.Before(h[j].entry.storedAt) }
+func (h expiryHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].heapIndex = i
+	h[j].heapIndex = j
+}
+
+func (h *expiryHeap) Push(x any) {
+	item := x.(*storeItem)
+	item.heapIndex = len(*h)
+	*h = append(*h, item)
+}
+
+func (h *expiryHeap) Pop() any {
+	old := *h
+	item := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return item
+}
+
+// cacheStore holds the entries of a MemoryCache within a maximum number of
+// entries and of bytes, evicting the least recently used ones. Zero limits
+// are unbounded.
+type cacheStore struct {
+	mu         sync.Mutex
+	items      map[string]*storeItem
+	recency    *list.List
+	expiry     expiryHeap
+	bytes      int64
+	maxEntries int
+	maxBytes   int64
+}
+
+func newCacheStore() *cacheStore {
+	return &cacheStore{items: make(map[string]*storeItem), recency: list.New()}
+}
+
+func entrySize(key string, entry cacheEntry) int64 {
+	banner := entry.banner
+	return int64(entryOverhead + 2*len(key) + len(banner.Title) + len(banner.Text) + len(banner.URL))
+}
+
+// get returns the entry of key, marking it as recently used.
+func (s *cacheStore) get(key string) (cacheEntry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	item, ok := s.items[key]
+	if !ok {
+		return cacheEntry{}, false
+	}
+	s.recency.MoveToFront(item.element)
+	return item.entry, true
+}
+
+// put stores entry under key and returns how many entries were evicted to
+// make room for it.
+func (s *cacheStore) put(key string, entry cacheEntry) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	size := entrySize(key, entry)
+	if item, ok := s.items[key]; ok {
+		s.bytes += size - item.size
+		item.entry = entry
+		item.size = size
+		s.recency.MoveToFront(item.element)
+		heap.Fix(&s.expiry, item.heapIndex)
+	} else {
+		item = &storeItem{key: key, entry: entry, size: size}
+		item.element = s.recency.PushFront(item)
+		heap.Push(&s.expiry, item)
+		s.items[key] = item
+		s.bytes += size
+	}
+	return s.evict()
+}
+
+// evict drops the least recently used entries until the limits are met.
+func (s *cacheStore) evict() int {
+	evicted := 0
+	for s.recency.Len() > 0 &&
+		((s.maxEntries > 0 && s.recency.Len() > s.maxEntries) || (s.maxBytes > 0 && s.bytes > s.maxBytes)) {
+		s.remove(s.recency.Back().Value.(*storeItem))
+		evicted++
+	}
+	return evicted
+}
+
+func (s *cacheStore) remove(item *storeItem) {
+	s.recency.Remove(item.element)
+	heap.Remove(&s.expiry, item.heapIndex)
+	delete(s.items, item.key)
+	s.bytes -= item.size
+}
+
+func (s *cacheStore) delete(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	item, ok := s.items[key]
+	if ok {
+		s.remove(item)
+	}
+	return ok
+}
+
+// deleteIf removes the entries matching and returns how many there were.
+func (s *cacheStore) deleteIf(matching func(entry cacheEntry) bool) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	deleted := 0
+	for _, item := range s.items {
+		if matching(item.entry) {
+			s.remove(item)
+			deleted++
+		}
+	}
+	return deleted
+}
+
+// expire removes the entries stored before cutoff, oldest first, without
+// visiting the others.
+func (s *cacheStore) expire(cutoff time.Time) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	expired := 0
+	for len(s.expiry) > 0 && s.expiry[0].entry.storedAt.Before(cutoff) {
+		s.remove(s.expiry[0])
+		expired++
+	}
+	return expired
+}
+
+// setLimits changes the limits, evicting entries that no longer fit, and
+// returns how many were evicted.
+func (s *cacheStore) setLimits(maxEntries int, maxBytes int64) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maxEntries = maxEntries
+	s.maxBytes = maxBytes
+	return s.evict()
+}
+
+// size returns the number of entries and the bytes they take.
+func (s *cacheStore) size() (int, int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.items), s.bytes
+}
+
+func (s *cacheStore) limits() (int, int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.maxEntries, s.maxBytes
+}
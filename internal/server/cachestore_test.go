@@ -0,0 +1,71 @@
+package server
+
+import (
+	"github.com/Paincake/avito-tech/internal/database"
+	"github.com/Paincake/avito-tech/internal/dto"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func entryAt(storedAt time.Time, title string) cacheEntry {
+	return cacheEntry{banner: database.UserBanner{Title: title}, storedAt: storedAt}
+}
+
+func TestCacheStore_ShouldEvictLeastRecentlyUsed(t *testing.T) {
+	store := newCacheStore()
+	store.setLimits(2, 0)
+	now := time.Now()
+	assert.Equal(t, 0, store.put("a", entryAt(now, "a")))
+	assert.Equal(t, 0, store.put("b", entryAt(now, "b")))
+	_, _ = store.get("a")
+	assert.Equal(t, 1, store.put("c", entryAt(now, "c")))
+
+	_, ok := store.get("b")
+	assert.False(t, ok, "b was the least recently used")
+	_, ok = store.get("a")
+	assert.True(t, ok)
+
+	entrySize := entrySize("d", entryAt(now, "d"))
+	assert.Equal(t, 1, store.setLimits(0, entrySize), "a single entry fits")
+	assert.Equal(t, 1, store.put("d", entryAt(now, "d")))
+	entries, bytes := store.size()
+	assert.Equal(t, 1, entries)
+	assert.Equal(t, entrySize, bytes)
+	_, ok = store.get("d")
+	assert.True(t, ok)
+}
+
+func TestCacheStore_ShouldExpireOldestEntriesOnly(t *testing.T) {
+	store := newCacheStore()
+	now := time.Now()
+	store.put("old", entryAt(now.Add(-2*time.Hour), "old"))
+	store.put("new", entryAt(now, "new"))
+	store.put("older", entryAt(now.Add(-3*time.Hour), "older"))
+	store.put("new", entryAt(now.Add(-4*time.Hour), "refreshed long ago"))
+	store.put("old", entryAt(now, "refreshed"))
+
+	assert.Equal(t, 2, store.expire(now.Add(-time.Hour)))
+	entry, ok := store.get("old")
+	assert.True(t, ok)
+	assert.Equal(t, "refreshed", entry.banner.Title)
+	entries, _ := store.size()
+	assert.Equal(t, 1, entries)
+}
+
+func TestMemoryCache_FlushShouldRemoveSelectedBanners(t *testing.T) {
+	done := make(chan bool)
+	defer close(done)
+	cache := NewMemoryCache(&batchRepository{}, 5, 1, done)
+	for _, key := range []dto.UserBannerKey{{FeatureId: 1, TagId: 1}, {FeatureId: 1, TagId: 2}, {FeatureId: 2, TagId: 1}, {FeatureId: 3, TagId: 3}} {
+		cache.store(database.DefaultTenant, dto.GetUserBannerParams{FeatureId: key.FeatureId, TagId: key.TagId}, database.UserBanner{})
+	}
+	cache.store(2, dto.GetUserBannerParams{FeatureId: 1, TagId: 1}, database.UserBanner{})
+
+	tenant := database.DefaultTenant
+	assert.Equal(t, 1, cache.Flush(dto.FlushCacheParams{TenantId: tenant, FeatureId: 1, TagId: 2}))
+	assert.Equal(t, 0, cache.Flush(dto.FlushCacheParams{TenantId: tenant, FeatureId: 1, TagId: 2}))
+	assert.Equal(t, 2, cache.Flush(dto.FlushCacheParams{TenantId: tenant, TagId: 1}))
+	assert.Equal(t, 1, cache.Flush(dto.FlushCacheParams{TenantId: tenant}))
+	assert.Equal(t, 1, cache.Stats().Entries, "banners of other tenants are kept")
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/Paincake/avito-tech/internal/database"
+	"github.com/Paincake/avito-tech/internal/dto"
+)
+
+// recordEvent appends event to the change feed and the webhook outbox
+// through repository, which should be the transaction the change was made
+// in, so that either both the change and its records are stored or neither.
+func recordEvent(ctx context.Context, repository database.BannerRepository, event BannerEvent) error {
+	if err := recordChange(ctx, repository, event); err != nil {
+		return err
+	}
+	return enqueueWebhooks(ctx, repository, event)
+}
+
+func recordChange(ctx context.Context, repository database.BannerRepository, event BannerEvent) error {
+	var snapshot []byte
+	if event.Banner != nil {
+		var err error
+		snapshot, err = json.Marshal(dto.BannerRecord{BannerId: event.BannerId, Banner: *event.Banner})
+		if err != nil {
+			return err
+		}
+	}
+	return repository.InsertBannerChange(ctx, database.BannerChange{
+		BannerID: event.BannerId,
+		Event:    event.Type,
+		Banner:   snapshot,
+	})
+}
+
+// GetChanges returns the changes after params.Since in the order they were
+// committed.
+func (s *Server) GetChanges(ctx context.Context, params dto.GetChangesParams) (dto.BannerChangePage, error) {
+	changes, err := s.Repository.SelectBannerChanges(ctx, params)
+	if err != nil {
+		return dto.BannerChangePage{}, err
+	}
+	page := dto.BannerChangePage{Changes: make([]dto.BannerChange, 0, len(changes)), Next: params.Since}
+	for _, change := range changes {
+		page.Changes = append(page.Changes, database.ConvertBannerChangeToDto(change))
+		page.Next = change.Sequence
+	}
+	return page, nil
+}
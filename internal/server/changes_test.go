@@ -0,0 +1,65 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/Paincake/avito-tech/internal/database"
+	"github.com/Paincake/avito-tech/internal/dto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func (r *outboxRepository) SelectBannerChanges(_ context.Context, params dto.GetChangesParams) ([]database.BannerChange, error) {
+	changes := make([]database.BannerChange, 0)
+	for i, change := range r.changes {
+		change.Sequence = int64(i + 1)
+		if change.Sequence > params.Since && len(changes) < params.Limit {
+			changes = append(changes, change)
+		}
+	}
+	return changes, nil
+}
+
+func TestServer_ShouldRecordChangesInTransaction(t *testing.T) {
+	repository := &outboxRepository{}
+	s := Server{Repository: repository}
+	id, err := s.PostBanner(context.Background(), dto.Banner{FeatureId: 3, Tags: []int64{1, 2}}, dto.Actor{Username: "admin"})
+	require.NoError(t, err)
+	require.NoError(t, s.DeleteBannerID(context.Background(), dto.DeleteBannerIdParams{BannerId: id}, dto.Actor{Username: "admin"}))
+
+	require.Len(t, repository.changes, 2)
+	assert.NotContains(t, repository.transactional, false)
+	assert.Equal(t, BannerEventCreated, repository.changes[0].Event)
+	var snapshot dto.BannerRecord
+	require.NoError(t, json.Unmarshal(repository.changes[0].Banner, &snapshot))
+	assert.Equal(t, id, snapshot.BannerId)
+	assert.Equal(t, []int64{1, 2}, snapshot.Tags)
+	assert.Equal(t, BannerEventDeleted, repository.changes[1].Event)
+	assert.Nil(t, repository.changes[1].Banner)
+}
+
+func TestGetChanges_ShouldResumeAfterLastSequence(t *testing.T) {
+	repository := &outboxRepository{}
+	for i := 0; i < 3; i++ {
+		repository.changes = append(repository.changes, database.BannerChange{BannerID: int64(i), Event: BannerEventUpdated, ChangedAt: time.Now()})
+	}
+	s := Server{Repository: repository}
+
+	page, err := s.GetChanges(context.Background(), dto.GetChangesParams{Since: 0, Limit: 2})
+	require.NoError(t, err)
+	assert.Len(t, page.Changes, 2)
+	assert.Equal(t, int64(2), page.Next)
+	assert.Equal(t, "null", string(page.Changes[0].Banner))
+
+	page, err = s.GetChanges(context.Background(), dto.GetChangesParams{Since: page.Next, Limit: 2})
+	require.NoError(t, err)
+	require.Len(t, page.Changes, 1)
+	assert.Equal(t, int64(3), page.Changes[0].Sequence)
+
+	page, err = s.GetChanges(context.Background(), dto.GetChangesParams{Since: page.Next, Limit: 2})
+	require.NoError(t, err)
+	assert.Empty(t, page.Changes)
+	assert.Equal(t, int64(3), page.Next, "an empty page keeps the position")
+}
@@ -0,0 +1,33 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"net/http"
+)
+
+// Deadline bounds the request context of a route by Options.RouteTimeouts[route],
+// falling back to Options.RequestTimeout. Cancelling the context aborts the
+// queries and cache waits made on behalf of the request.
+func (w *ServerInterfaceWrapper) Deadline(route string) echo.MiddlewareFunc {
+	timeout, ok := w.Options.RouteTimeouts[route]
+	if !ok {
+		timeout = w.Options.RequestTimeout
+	}
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		if timeout <= 0 {
+			return next
+		}
+		return func(c echo.Context) error {
+			ctx, cancel := context.WithTimeout(c.Request().Context(), timeout)
+			defer cancel()
+			c.SetRequest(c.Request().WithContext(ctx))
+			err := next(c)
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) && !c.Response().Committed {
+				return &APIError{Status: http.StatusServiceUnavailable, Code: CodeTimeout, Message: "request timed out", Err: err}
+			}
+			return err
+		}
+	}
+}
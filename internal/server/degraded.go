@@ -0,0 +1,46 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"github.com/Paincake/avito-tech/internal/database/postgres"
+	"net/http"
+	"sync/atomic"
+)
+
+const (
+	// DegradedHeader is set on responses served from last-known banners
+	// because the database could not be queried.
+	DegradedHeader  = "X-Banner-Degraded"
+	degradedWarning = `111 - "Revalidation Failed"`
+)
+
+type degradedKey struct{}
+
+// WithDegradation returns a context in which the cache reports serving
+// last-known banners in place of failed repository calls, and a function
+// telling whether it did.
+func WithDegradation(ctx context.Context) (context.Context, func() bool) {
+	flag := &atomic.Bool{}
+	return context.WithValue(ctx, degradedKey{}, flag), flag.Load
+}
+
+func markDegraded(ctx context.Context) {
+	if flag, ok := ctx.Value(degradedKey{}).(*atomic.Bool); ok {
+		flag.Store(true)
+	}
+}
+
+// setDegraded marks a response as served from last-known banners.
+func setDegraded(header http.Header) {
+	header.Set("Warning", degradedWarning)
+	header.Set(DegradedHeader, "true")
+}
+
+// degradable reports whether the cache may answer a failed repository call
+// with a last-known banner: the banner is not known to be gone and the caller
+// still waits for an answer.
+func degradable(ctx context.Context, err error) bool {
+	var notFound postgres.EntityNotFound
+	return ctx.Err() == nil && !errors.As(err, &notFound)
+}
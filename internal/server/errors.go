@@ -0,0 +1,178 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"github.com/Paincake/avito-tech/internal/database/postgres"
+	"github.com/Paincake/avito-tech/internal/openapi"
+	"github.com/labstack/echo/v4"
+	"log/slog"
+	"net/http"
+	"os"
+)
+
+const (
+	CodeBadRequest         = "BAD_REQUEST"
+	CodeValidationFailed   = "VALIDATION_FAILED"
+	CodeInvalidParameter   = "INVALID_PARAMETER"
+	CodeInvalidBody        = "INVALID_BODY"
+	CodeUnauthorized       = "UNAUTHORIZED"
+	CodeInvalidCredentials = "INVALID_CREDENTIALS"
+	CodeForbidden          = "FORBIDDEN"
+	CodeNotFound           = "NOT_FOUND"
+	CodeBannerNotFound     = "BANNER_NOT_FOUND"
+	CodeWebhookNotFound    = "WEBHOOK_NOT_FOUND"
+	CodeTenantNotFound     = "TENANT_NOT_FOUND"
+	CodeAPIKeyNotFound     = "API_KEY_NOT_FOUND"
+	CodeMethodNotAllowed   = "METHOD_NOT_ALLOWED"
+	CodeConflict           = "CONFLICT"
+	CodeInvalidTransition  = "INVALID_TRANSITION"
+	CodeInvalidReference   = "INVALID_REFERENCE"
+	CodeTooLarge           = "REQUEST_TOO_LARGE"
+	CodeRateLimited        = "RATE_LIMITED"
+	CodeTimeout            = "TIMEOUT"
+	CodeUnavailable        = "SERVICE_UNAVAILABLE"
+	CodeInternal           = "INTERNAL_ERROR"
+)
+
+// APIError is an error with the status, code and message returned to the
+// client. Err is the cause, which is logged but never returned.
+type APIError struct {
+	Status  int
+	Code    string
+	Message string
+	Details any
+	Err     error
+}
+
+func (e *APIError) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *APIError) Unwrap() error {
+	return e.Err
+}
+
+// HTTPStatus lets middlewares outside this package, like metrics and
+// tracing, read the status of an error before it is written.
+func (e *APIError) HTTPStatus() int {
+	return e.Status
+}
+
+// ErrorBody is the body of every error response.
+type ErrorBody struct {
+	Error ErrorDetail `json:"error"`
+}
+
+type ErrorDetail struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestId string `json:"request_id,omitempty"`
+	Details   any    `json:"details,omitempty"`
+}
+
+var errForbidden = &APIError{Status: http.StatusForbidden, Code: CodeForbidden, Message: "forbidden"}
+
+func invalidParameter(err error) error {
+	return &APIError{Status: http.StatusBadRequest, Code: CodeInvalidParameter, Message: err.Error()}
+}
+
+func invalidBody(err error) error {
+	return &APIError{Status: http.StatusBadRequest, Code: CodeInvalidBody, Message: "invalid request body: " + err.Error()}
+}
+
+var statusCodes = map[int]string{
+	http.StatusBadRequest:            CodeBadRequest,
+	http.StatusUnauthorized:          CodeUnauthorized,
+	http.StatusForbidden:             CodeForbidden,
+	http.StatusNotFound:              CodeNotFound,
+	http.StatusMethodNotAllowed:      CodeMethodNotAllowed,
+	http.StatusConflict:              CodeConflict,
+	http.StatusRequestEntityTooLarge: CodeTooLarge,
+	http.StatusTooManyRequests:       CodeRateLimited,
+	http.StatusServiceUnavailable:    CodeUnavailable,
+}
+
+// ToAPIError maps any error returned by a handler or middleware to what the
+// client is told. This is the only place errors are turned into statuses.
+func ToAPIError(err error) *APIError {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+	var httpErr *echo.HTTPError
+	if errors.As(err, &httpErr) {
+		if validation, ok := httpErr.Message.(openapi.ValidationError); ok {
+			return &APIError{Status: httpErr.Code, Code: CodeValidationFailed, Message: validation.Message, Details: validation.Details, Err: err}
+		}
+		code, ok := statusCodes[httpErr.Code]
+		if !ok {
+			code = CodeInternal
+		}
+		message := http.StatusText(httpErr.Code)
+		if text, ok := httpErr.Message.(string); ok && httpErr.Code < http.StatusInternalServerError {
+			message = text
+		}
+		return &APIError{Status: httpErr.Code, Code: code, Message: message, Err: err}
+	}
+	var notFound postgres.EntityNotFound
+	var conflict postgres.EntityConflict
+	var reference postgres.InvalidReference
+	var credentials postgres.InvalidCredentials
+	var unavailable postgres.Unavailable
+	var workflow WorkflowError
+	switch {
+	case errors.As(err, &notFound):
+		return &APIError{Status: http.StatusNotFound, Code: CodeBannerNotFound, Message: "banner not found", Err: err}
+	case errors.As(err, &workflow):
+		return &APIError{Status: http.StatusConflict, Code: CodeInvalidTransition, Message: workflow.Error(), Err: err}
+	case errors.As(err, &conflict):
+		return &APIError{Status: http.StatusConflict, Code: CodeConflict, Message: "already exists", Err: err}
+	case errors.As(err, &reference):
+		return &APIError{Status: http.StatusUnprocessableEntity, Code: CodeInvalidReference, Message: "unknown feature or tag", Err: err}
+	case errors.As(err, &credentials):
+		return &APIError{Status: http.StatusUnauthorized, Code: CodeInvalidCredentials, Message: "invalid username or password", Err: err}
+	case errors.As(err, &unavailable):
+		return &APIError{Status: http.StatusServiceUnavailable, Code: CodeUnavailable, Message: "database unavailable", Err: err}
+	case errors.Is(err, context.DeadlineExceeded):
+		return &APIError{Status: http.StatusServiceUnavailable, Code: CodeTimeout, Message: "request timed out", Err: err}
+	}
+	return &APIError{Status: http.StatusInternalServerError, Code: CodeInternal, Message: "internal server error", Err: err}
+}
+
+var errorLogger = slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: LogLevel}))
+
+// ErrorHandler is the echo HTTPErrorHandler writing every error as an
+// ErrorBody. Causes of server errors are logged with the request id.
+func ErrorHandler(err error, c echo.Context) {
+	if c.Response().Committed {
+		return
+	}
+	apiErr := ToAPIError(err)
+	requestId := c.Response().Header().Get(echo.HeaderXRequestID)
+	if requestId == "" {
+		requestId = c.Request().Header.Get(echo.HeaderXRequestID)
+	}
+	if apiErr.Status >= http.StatusInternalServerError {
+		errorLogger.LogAttrs(c.Request().Context(), slog.LevelError, "REQUEST_FAILED",
+			slog.String("request_id", requestId),
+			slog.String("method", c.Request().Method),
+			slog.String("uri", c.Request().RequestURI),
+			slog.Int("status", apiErr.Status),
+			slog.String("err", err.Error()),
+		)
+	}
+	if c.Request().Method == http.MethodHead {
+		_ = c.NoContent(apiErr.Status)
+		return
+	}
+	_ = c.JSON(apiErr.Status, ErrorBody{Error: ErrorDetail{
+		Code:      apiErr.Code,
+		Message:   apiErr.Message,
+		RequestId: requestId,
+		Details:   apiErr.Details,
+	}})
+}
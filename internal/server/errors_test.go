@@ -0,0 +1,50 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Paincake/avito-tech/internal/database/postgres"
+	"github.com/Paincake/avito-tech/internal/openapi"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestToAPIError_ShouldMapErrorTypes(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{postgres.EntityNotFound{Err: errors.New("banner 1 not found")}, http.StatusNotFound, CodeBannerNotFound},
+		{fmt.Errorf("in transaction: %w", postgres.EntityConflict{Err: errors.New("duplicate key")}), http.StatusConflict, CodeConflict},
+		{postgres.InvalidReference{Err: errors.New("fk")}, http.StatusUnprocessableEntity, CodeInvalidReference},
+		{postgres.InvalidCredentials{Err: errors.New("wrong password")}, http.StatusUnauthorized, CodeInvalidCredentials},
+		{WorkflowError{Err: errors.New("banner can not move from draft to published")}, http.StatusConflict, CodeInvalidTransition},
+		{echo.ErrNotFound, http.StatusNotFound, CodeNotFound},
+		{echo.NewHTTPError(http.StatusBadRequest, openapi.ValidationError{Message: "invalid request"}), http.StatusBadRequest, CodeValidationFailed},
+		{errForbidden, http.StatusForbidden, CodeForbidden},
+		{errors.New("error selecting banners: connection refused"), http.StatusInternalServerError, CodeInternal},
+	}
+	for _, test := range tests {
+		apiErr := ToAPIError(test.err)
+		assert.Equal(t, test.status, apiErr.Status, test.err.Error())
+		assert.Equal(t, test.code, apiErr.Code, test.err.Error())
+	}
+}
+
+func TestErrorHandler_ShouldNotReturnInternalDetails(t *testing.T) {
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/banner", nil)
+	req.Header.Set(echo.HeaderXRequestID, "req-1")
+	recorder := httptest.NewRecorder()
+	ErrorHandler(errors.New("error selecting banners: password authentication failed"), e.NewContext(req, recorder))
+
+	assert.Equal(t, http.StatusInternalServerError, recorder.Code)
+	var body ErrorBody
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &body))
+	assert.Equal(t, ErrorDetail{Code: CodeInternal, Message: "internal server error", RequestId: "req-1"}, body.Error)
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"context"
+	"github.com/Paincake/avito-tech/internal/database"
+	"github.com/Paincake/avito-tech/internal/dto"
+	"strconv"
+)
+
+// PostFeature adds a feature to the tenant of ctx, which its banners may then
+// be shown for.
+func (s *Server) PostFeature(ctx context.Context, feature dto.Feature, actor dto.Actor) (int64, error) {
+	var id int64
+	err := s.Repository.InTransaction(ctx, func(repository database.BannerRepository) error {
+		var err error
+		id, err = repository.InsertFeature(ctx, feature.Description)
+		if err != nil {
+			return err
+		}
+		feature.Id = id
+		return audit(ctx, repository, actor, AuditActionCreate, AuditTargetFeature, strconv.FormatInt(id, 10), nil, &feature)
+	})
+	if err != nil {
+		return -1, err
+	}
+	return id, nil
+}
+
+func (s *Server) GetFeatures(ctx context.Context) ([]dto.Feature, error) {
+	features, err := s.Repository.SelectFeatures(ctx)
+	if err != nil {
+		return nil, err
+	}
+	dtoFeatures := make([]dto.Feature, 0, len(features))
+	for _, feature := range features {
+		dtoFeatures = append(dtoFeatures, database.ConvertFeatureToDto(feature))
+	}
+	return dtoFeatures, nil
+}
+
+// PostTag adds a tag to the tenant of ctx, which its banners may then be
+// shown to.
+func (s *Server) PostTag(ctx context.Context, tag dto.Tag, actor dto.Actor) (int64, error) {
+	var id int64
+	err := s.Repository.InTransaction(ctx, func(repository database.BannerRepository) error {
+		var err error
+		id, err = repository.InsertTag(ctx, tag.Description)
+		if err != nil {
+			return err
+		}
+		tag.Id = id
+		return audit(ctx, repository, actor, AuditActionCreate, AuditTargetTag, strconv.FormatInt(id, 10), nil, &tag)
+	})
+	if err != nil {
+		return -1, err
+	}
+	return id, nil
+}
+
+func (s *Server) GetTags(ctx context.Context) ([]dto.Tag, error) {
+	tags, err := s.Repository.SelectTags(ctx)
+	if err != nil {
+		return nil, err
+	}
+	dtoTags := make([]dto.Tag, 0, len(tags))
+	for _, tag := range tags {
+		dtoTags = append(dtoTags, database.ConvertTagToDto(tag))
+	}
+	return dtoTags, nil
+}
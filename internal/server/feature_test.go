@@ -0,0 +1,103 @@
+package server
+
+import (
+	"context"
+	"github.com/Paincake/avito-tech/internal/database"
+	"github.com/Paincake/avito-tech/internal/dto"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// catalogRepository keeps the features and tags of every tenant.
+type catalogRepository struct {
+	outboxRepository
+	features map[int64][]database.Feature
+	tags     map[int64][]database.Tag
+	audited  []database.AuditEntry
+	nextID   int64
+}
+
+func (r *catalogRepository) InTransaction(ctx context.Context, fn func(repository database.BannerRepository) error) error {
+	return r.outboxRepository.InTransaction(ctx, func(database.BannerRepository) error { return fn(r) })
+}
+
+func (r *catalogRepository) InsertFeature(ctx context.Context, description string) (int64, error) {
+	tenant, err := database.TenantOf(ctx)
+	if err != nil {
+		return -1, err
+	}
+	r.nextID++
+	r.features[tenant] = append(r.features[tenant], database.Feature{ID: r.nextID, Description: description})
+	return r.nextID, nil
+}
+
+func (r *catalogRepository) SelectFeatures(ctx context.Context) ([]database.Feature, error) {
+	tenant, err := database.TenantOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return r.features[tenant], nil
+}
+
+func (r *catalogRepository) InsertTag(ctx context.Context, description string) (int64, error) {
+	tenant, err := database.TenantOf(ctx)
+	if err != nil {
+		return -1, err
+	}
+	r.nextID++
+	r.tags[tenant] = append(r.tags[tenant], database.Tag{ID: r.nextID, Description: description})
+	return r.nextID, nil
+}
+
+func (r *catalogRepository) InsertAuditEntry(_ context.Context, entry database.AuditEntry) error {
+	r.audited = append(r.audited, entry)
+	return nil
+}
+
+func catalogWrapper() (*ServerInterfaceWrapper, *catalogRepository) {
+	repository := &catalogRepository{features: map[int64][]database.Feature{}, tags: map[int64][]database.Tag{}}
+	return &ServerInterfaceWrapper{Handler: &Server{Repository: repository}}, repository
+}
+
+func TestPostFeature_ShouldAddItToTheTenantOfTheAdmin(t *testing.T) {
+	wrapper, repository := catalogWrapper()
+	ctx := database.WithTenant(context.Background(), 2)
+
+	id, err := wrapper.Handler.PostFeature(ctx, dto.Feature{Description: "checkout"}, dto.Actor{Username: "admin"})
+	require.NoError(t, err)
+	_, err = wrapper.Handler.PostTag(ctx, dto.Tag{Description: "new users"}, dto.Actor{Username: "admin"})
+	require.NoError(t, err)
+
+	features, err := wrapper.Handler.GetFeatures(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []dto.Feature{{Id: id, Description: "checkout"}}, features)
+	assert.Len(t, repository.tags[2], 1)
+	features, err = wrapper.Handler.GetFeatures(tenantContext())
+	require.NoError(t, err)
+	assert.Empty(t, features, "features are per tenant")
+	if assert.Len(t, repository.audited, 2) {
+		assert.Equal(t, AuditTargetFeature, repository.audited[0].TargetType)
+		assert.Equal(t, AuditTargetTag, repository.audited[1].TargetType)
+	}
+}
+
+func TestPostFeature_ShouldBeAdminOnly(t *testing.T) {
+	wrapper, repository := catalogWrapper()
+	for role, want := range map[string]int{database.UserRole: http.StatusForbidden, database.SuperAdminRole: http.StatusForbidden, database.AdminRole: http.StatusCreated} {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/features", strings.NewReader(`{"description": "checkout"}`))
+		c := echo.New().NewContext(req.WithContext(tenantContext()), rec)
+		c.Set(dto.TokenRoleContextKey, role)
+		if err := wrapper.PostFeature(c); err != nil {
+			assert.Equal(t, want, ToAPIError(err).Status, role)
+			continue
+		}
+		assert.Equal(t, want, rec.Code, role)
+	}
+	assert.Len(t, repository.features[database.DefaultTenant], 1)
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"context"
+	"github.com/Paincake/avito-tech/internal/database"
+	"github.com/Paincake/avito-tech/internal/dto"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	HealthStatusUp   = "up"
+	HealthStatusDown = "down"
+)
+
+// HealthCheck is a named dependency check used for readiness.
+type HealthCheck struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+type HealthCheckResult struct {
+	Name      string `json:"name"`
+	Status    string `json:"status"`
+	LatencyMs int64  `json:"latency_ms"`
+	Error     string `json:"error,omitempty"`
+}
+
+type HealthReport struct {
+	Status        string              `json:"status"`
+	UptimeSeconds int64               `json:"uptime_seconds"`
+	Checks        []HealthCheckResult `json:"checks"`
+}
+
+// Health serves the liveness, readiness and detailed health endpoints.
+type Health struct {
+	Checks  []HealthCheck
+	Timeout time.Duration
+	started time.Time
+}
+
+func NewHealth(timeout time.Duration, checks ...HealthCheck) *Health {
+	return &Health{
+		Checks:  checks,
+		Timeout: timeout,
+		started: time.Now(),
+	}
+}
+
+// Run executes every check concurrently, each bounded by the health timeout.
+func (h *Health) Run(ctx context.Context) HealthReport {
+	results := make([]HealthCheckResult, len(h.Checks))
+	wg := sync.WaitGroup{}
+	for i, check := range h.Checks {
+		wg.Add(1)
+		go func(i int, check HealthCheck) {
+			defer wg.Done()
+			checkCtx, cancel := context.WithTimeout(ctx, h.Timeout)
+			defer cancel()
+			start := time.Now()
+			errs := make(chan error, 1)
+			go func() {
+				errs <- check.Check(checkCtx)
+			}()
+			var err error
+			select {
+			case err = <-errs:
+			case <-checkCtx.Done():
+				err = checkCtx.Err()
+			}
+			result := HealthCheckResult{
+				Name:      check.Name,
+				Status:    HealthStatusUp,
+				LatencyMs: time.Since(start).Milliseconds(),
+			}
+			if err != nil {
+				result.Status = HealthStatusDown
+				result.Error = err.Error()
+			}
+			results[i] = result
+		}(i, check)
+	}
+	wg.Wait()
+	status := HealthStatusUp
+	for _, result := range results {
+		if result.Status != HealthStatusUp {
+			status = HealthStatusDown
+		}
+	}
+	return HealthReport{
+		Status:        status,
+		UptimeSeconds: int64(time.Since(h.started).Seconds()),
+		Checks:        results,
+	}
+}
+
+// Liveness reports that the process is up and serving requests.
+func (h *Health) Liveness(ctx echo.Context) error {
+	return ctx.JSON(http.StatusOK, map[string]string{"status": HealthStatusUp})
+}
+
+// Readiness reports whether every dependency check passes. Failing checks are
+// named, but their errors are only shown in the admin report.
+func (h *Health) Readiness(ctx echo.Context) error {
+	report := h.Run(ctx.Request().Context())
+	failed := make([]string, 0)
+	for _, result := range report.Checks {
+		if result.Status != HealthStatusUp {
+			failed = append(failed, result.Name)
+		}
+	}
+	status := http.StatusOK
+	if report.Status != HealthStatusUp {
+		status = http.StatusServiceUnavailable
+	}
+	return ctx.JSON(status, struct {
+		Status string   `json:"status"`
+		Failed []string `json:"failed,omitempty"`
+	}{Status: report.Status, Failed: failed})
+}
+
+// Report is the detailed health report, available to admins only.
+func (h *Health) Report(ctx echo.Context) error {
+	role := ctx.Get(dto.TokenRoleContextKey)
+	if role != database.AdminRole {
+		return errForbidden
+	}
+	report := h.Run(ctx.Request().Context())
+	status := http.StatusOK
+	if report.Status != HealthStatusUp {
+		status = http.StatusServiceUnavailable
+	}
+	return ctx.JSON(status, report)
+}
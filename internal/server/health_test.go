@@ -0,0 +1,48 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestReadiness_ShouldFailWhenCheckFailsOrTimesOut(t *testing.T) {
+	health := NewHealth(50*time.Millisecond,
+		HealthCheck{Name: "database", Check: func(ctx context.Context) error { return nil }},
+		HealthCheck{Name: "migrations", Check: func(ctx context.Context) error { return errors.New("schema version is 1, expected 4") }},
+		HealthCheck{Name: "cache", Check: func(ctx context.Context) error {
+			time.Sleep(time.Second)
+			return nil
+		}},
+	)
+	e := echo.New()
+	rec := httptest.NewRecorder()
+	err := health.Readiness(e.NewContext(httptest.NewRequest(http.MethodGet, "/readyz", nil), rec))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	var body struct {
+		Status string   `json:"status"`
+		Failed []string `json:"failed"`
+	}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.Equal(t, HealthStatusDown, body.Status)
+	assert.Equal(t, []string{"migrations", "cache"}, body.Failed)
+}
+
+func TestReadiness_ShouldPassWhenAllChecksPass(t *testing.T) {
+	health := NewHealth(time.Second,
+		HealthCheck{Name: "database", Check: func(ctx context.Context) error { return nil }},
+	)
+	e := echo.New()
+	rec := httptest.NewRecorder()
+	err := health.Readiness(e.NewContext(httptest.NewRequest(http.MethodGet, "/readyz", nil), rec))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
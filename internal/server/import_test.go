@@ -0,0 +1,79 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"github.com/Paincake/avito-tech/internal/database"
+	"github.com/Paincake/avito-tech/internal/database/postgres"
+	"github.com/Paincake/avito-tech/internal/dto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+// importRepository knows banner 7 and feature 3 only, and counts the
+// transactions committed.
+type importRepository struct {
+	outboxRepository
+	committed int
+}
+
+func (r *importRepository) InTransaction(ctx context.Context, fn func(repository database.BannerRepository) error) error {
+	err := r.outboxRepository.InTransaction(ctx, func(database.BannerRepository) error { return fn(r) })
+	if err == nil {
+		r.committed++
+	}
+	return err
+}
+
+func (r *importRepository) InsertBanner(_ context.Context, banner dto.Banner, _ string) (int64, error) {
+	if banner.FeatureId != 3 {
+		return -1, postgres.InvalidReference{Err: errors.New("unknown feature")}
+	}
+	return 7, nil
+}
+
+func (r *importRepository) UpdateBannerById(context.Context, int64, dto.Banner, string) error {
+	return nil
+}
+
+func (r *importRepository) SelectBannerById(ctx context.Context, id int64) (database.Banner, error) {
+	if id != 7 {
+		return database.Banner{}, postgres.EntityNotFound{Err: errors.New("banner not found")}
+	}
+	return r.outboxRepository.SelectBannerById(ctx, id)
+}
+
+func TestImportBanners_DryRunShouldReportLikeARealRunAndRollBack(t *testing.T) {
+	now := time.Now().Format(time.RFC3339)
+	banner := func(featureId int64) dto.Banner {
+		return dto.Banner{FeatureId: featureId, Tags: []int64{1}, Content: dto.Content{Title: "t", Text: "t", Url: "u"}, CreatedAt: now, UpdatedAt: now}
+	}
+	rows := []dto.ImportRow{
+		{Line: 1, Record: dto.BannerRecord{Banner: banner(3)}},
+		{Line: 2, Record: dto.BannerRecord{Banner: banner(404)}},
+		{Line: 3, Record: dto.BannerRecord{BannerId: 7, Banner: banner(3)}},
+		{Line: 4, Record: dto.BannerRecord{BannerId: 99, Banner: banner(3)}},
+	}
+
+	repository := &importRepository{}
+	s := Server{Repository: repository}
+	dryRun, err := s.ImportBanners(context.Background(), rows, nil, true, false, dto.Actor{Username: "admin"})
+	require.NoError(t, err)
+	assert.Equal(t, 0, repository.committed, "a dry run commits nothing")
+
+	applied, err := s.ImportBanners(context.Background(), rows, nil, false, false, dto.Actor{Username: "admin"})
+	require.NoError(t, err)
+	assert.Equal(t, 2, repository.committed)
+
+	assert.Equal(t, 1, dryRun.Inserted)
+	assert.Equal(t, 1, dryRun.Updated)
+	assert.Equal(t, applied.Inserted, dryRun.Inserted)
+	assert.Equal(t, applied.Updated, dryRun.Updated)
+	assert.Equal(t, applied.Errors, dryRun.Errors)
+	if assert.Len(t, dryRun.Errors, 2) {
+		assert.Equal(t, 2, dryRun.Errors[0].Line, "unknown feature")
+		assert.Equal(t, 4, dryRun.Errors[1].Line, "unknown banner")
+	}
+}
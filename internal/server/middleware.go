@@ -2,18 +2,23 @@ package server
 
 import (
 	"context"
-	"fmt"
+	"github.com/Paincake/avito-tech/internal/database"
 	"github.com/Paincake/avito-tech/internal/dto"
-	"github.com/golang-jwt/jwt"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
 	"log/slog"
-	"net/http"
 	"os"
+	"sync/atomic"
 )
 
+// LogLevel is the level of the request and auth loggers; it can be changed
+// while the server runs.
+var LogLevel = new(slog.LevelVar)
+
 func Logger(next echo.HandlerFunc) echo.HandlerFunc {
-	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: LogLevel}))
 	f := middleware.RequestLoggerWithConfig(middleware.RequestLoggerConfig{
 		LogStatus:    true,
 		LogURI:       true,
@@ -40,40 +45,75 @@ func Logger(next echo.HandlerFunc) echo.HandlerFunc {
 	return f(next)
 }
 
+// CORS answers cross-origin requests from an allow list that can be replaced
+// while the server runs. An empty list allows no cross-origin requests.
+type CORS struct {
+	origins atomic.Pointer[map[string]bool]
+}
+
+func NewCORS(origins []string) *CORS {
+	c := &CORS{}
+	c.SetOrigins(origins)
+	return c
+}
+
+func (c *CORS) SetOrigins(origins []string) {
+	allowed := make(map[string]bool, len(origins))
+	for _, origin := range origins {
+		allowed[origin] = true
+	}
+	c.origins.Store(&allowed)
+}
+
+func (c *CORS) Middleware() echo.MiddlewareFunc {
+	return middleware.CORSWithConfig(middleware.CORSConfig{
+		AllowOriginFunc: func(origin string) (bool, error) {
+			allowed := *c.origins.Load()
+			return allowed["*"] || allowed[origin], nil
+		},
+	})
+}
+
+var tracer = otel.Tracer("github.com/Paincake/avito-tech/internal/server")
+
+// publicPaths are served without a token.
+var publicPaths = map[string]bool{
+	"/login":        true,
+	"/signup":       true,
+	"/metrics":      true,
+	"/healthz":      true,
+	"/readyz":       true,
+	"/openapi.json": true,
+	"/docs":         true,
+}
+
 func VerifyJWT(next echo.HandlerFunc) echo.HandlerFunc {
-	var role string
 	return func(c echo.Context) error {
-		if c.Request().RequestURI == "/login" || c.Request().RequestURI == "/signup" {
+		if publicPaths[c.Request().URL.Path] {
 			return next(c)
 		}
-		r := c.Request().Header
-		logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
-		if r.Get("Token") != "" {
-			token, err := jwt.Parse(r.Get("Token"), func(token *jwt.Token) (interface{}, error) {
-				return []byte(os.Getenv("JWT_SECRET_KEY")), nil
-			})
-			if err != nil {
-				logger.Debug("Request discarded: auth failed")
-				return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Request discarded: auth failed: %s", err))
-			}
-			if token.Valid {
-				claims, ok := token.Claims.(jwt.MapClaims)
-				if ok {
-					role = claims["role"].(string)
-					c.Set(dto.TokenRoleContextKey, role)
-					logger.Debug(fmt.Sprintf("User with claims %s authenticated", role))
-				} else {
-					logger.Debug("Request discarded: auth failed: required claim absent")
-					return echo.NewHTTPError(http.StatusUnauthorized, "Request discarded: auth failed: required claim absent")
-				}
-			} else {
-				logger.Debug("Request discarded: auth failed: invalid token")
-				return echo.NewHTTPError(http.StatusUnauthorized, "Request discarded: auth failed: invalid token")
-			}
-		} else {
-			logger.Debug("Request discarded: auth failed: token absent")
-			return echo.NewHTTPError(http.StatusBadRequest, "request discarded: Token header parameter absent")
+		_, span := tracer.Start(c.Request().Context(), "VerifyJWT")
+		err := verifyJWT(c)
+		if err != nil {
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+		if err != nil {
+			return err
 		}
 		return next(c)
 	}
 }
+
+func verifyJWT(c echo.Context) error {
+	claims, err := Authenticate(c.Request().Context(), c.Request().Header.Get("Token"), c.Request().Header.Get(APIKeyHeader))
+	if err != nil {
+		return err
+	}
+	c.Set(dto.TokenRoleContextKey, claims.Role)
+	if claims.Username != "" {
+		c.Set(dto.TokenUsernameContextKey, claims.Username)
+	}
+	c.SetRequest(c.Request().WithContext(database.WithTenant(c.Request().Context(), claims.Tenant)))
+	return nil
+}
@@ -0,0 +1,202 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/Paincake/avito-tech/internal/database"
+	"github.com/Paincake/avito-tech/internal/dto"
+	"github.com/labstack/echo/v4"
+	"github.com/puzpuzpuz/xsync"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Rate limit classes, each configured separately.
+const (
+	RateLimitLatest = "latest"
+	RateLimitAdmin  = "admin"
+	RateLimitLogin  = "login"
+)
+
+// RateLimit is a token bucket refilled at Rate tokens per second up to Burst
+// tokens. A zero Rate disables the limit.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// RateLimitStore keeps the token buckets. TakeToken refills the bucket of key
+// and takes a token from it if there is one, returning the tokens left.
+type RateLimitStore interface {
+	TakeToken(ctx context.Context, key string, rate float64, burst int) (bool, float64, error)
+}
+
+// RateLimiter limits GET /user_banner?use_last_revision=true, which bypasses
+// the cache, admin writes and login, per client. Clients are told apart by
+// the JWT subject, then by the token itself, and by IP when unauthenticated,
+// so it must run after VerifyJWT.
+type RateLimiter struct {
+	store  RateLimitStore
+	limits atomic.Pointer[map[string]RateLimit]
+	logger *slog.Logger
+}
+
+func NewRateLimiter(store RateLimitStore, limits map[string]RateLimit) *RateLimiter {
+	l := &RateLimiter{
+		store:  store,
+		logger: slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: LogLevel})),
+	}
+	l.SetLimits(limits)
+	return l
+}
+
+// SetLimits replaces the limits of every class while the server runs.
+func (l *RateLimiter) SetLimits(limits map[string]RateLimit) {
+	l.limits.Store(&limits)
+}
+
+func (l *RateLimiter) Middleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		limit, allowed, remaining, limited := l.take(c.Request().Context(), rateLimitClass(c), rateLimitKey(c))
+		if !limited {
+			return next(c)
+		}
+		header := c.Response().Header()
+		header.Set("RateLimit-Limit", strconv.Itoa(limit.Burst))
+		header.Set("RateLimit-Remaining", strconv.Itoa(int(remaining)))
+		header.Set("RateLimit-Reset", strconv.Itoa(secondsUntil(float64(limit.Burst)-remaining, limit.Rate)))
+		if !allowed {
+			header.Set("Retry-After", strconv.Itoa(secondsUntil(1-remaining, limit.Rate)))
+			return &APIError{Status: http.StatusTooManyRequests, Code: CodeRateLimited, Message: "rate limit exceeded"}
+		}
+		return next(c)
+	}
+}
+
+// Allow takes a token of class for the client key from the buckets REST
+// requests use, so that other APIs share their limits. When there is none
+// left it returns the seconds to wait before retrying.
+func (l *RateLimiter) Allow(ctx context.Context, class, key string) (bool, int) {
+	limit, allowed, remaining, limited := l.take(ctx, class, key)
+	if !limited || allowed {
+		return true, 0
+	}
+	return false, secondsUntil(1-remaining, limit.Rate)
+}
+
+// take takes a token of class for key. limited is false when the class has
+// no limit or the store failed, and the request is then let through.
+func (l *RateLimiter) take(ctx context.Context, class, key string) (limit RateLimit, allowed bool, remaining float64, limited bool) {
+	limit, ok := (*l.limits.Load())[class]
+	if class == "" || !ok || limit.Rate <= 0 || limit.Burst < 1 {
+		return limit, true, 0, false
+	}
+	allowed, remaining, err := l.store.TakeToken(ctx, class+":"+key, limit.Rate, limit.Burst)
+	if err != nil {
+		// an unreachable store must not take the service down with it
+		l.logger.Error("rate limit store failed, request let through", slog.String("err", err.Error()))
+		return limit, true, 0, false
+	}
+	return limit, allowed, remaining, true
+}
+
+// secondsUntil rounds up the time it takes to refill tokens at rate.
+func secondsUntil(tokens, rate float64) int {
+	if tokens <= 0 {
+		return 0
+	}
+	return int(math.Ceil(tokens / rate))
+}
+
+func rateLimitClass(c echo.Context) string {
+	method := c.Request().Method
+	switch {
+	case c.Path() == "/login" && method == http.MethodPost:
+		return RateLimitLogin
+	case c.Path() == "/user_banner" && method == http.MethodGet:
+		if latest, _ := strconv.ParseBool(c.QueryParam("use_last_revision")); latest {
+			return RateLimitLatest
+		}
+	case c.Get(dto.TokenRoleContextKey) == database.AdminRole && method != http.MethodGet && method != http.MethodHead:
+		return RateLimitAdmin
+	}
+	return ""
+}
+
+// SubjectRateLimitKey is the rate limit key of a user, whose username is only
+// unique within its tenant.
+func SubjectRateLimitKey(tenant int64, username string) string {
+	return "sub:" + strconv.FormatInt(tenant, 10) + ":" + username
+}
+
+func rateLimitKey(c echo.Context) string {
+	if username, ok := c.Get(dto.TokenUsernameContextKey).(string); ok && username != "" {
+		tenant, _ := database.TenantOf(c.Request().Context())
+		return SubjectRateLimitKey(tenant, username)
+	}
+	if token := c.Request().Header.Get("Token"); token != "" && c.Get(dto.TokenRoleContextKey) != nil {
+		sum := sha256.Sum256([]byte(token))
+		return "token:" + hex.EncodeToString(sum[:8])
+	}
+	return "ip:" + c.RealIP()
+}
+
+// MemoryRateLimitStore keeps the token buckets of a single replica. Buckets
+// that have been full for a minute are dropped.
+type MemoryRateLimitStore struct {
+	buckets   *xsync.Map
+	lastPrune atomic.Int64
+	now       func() time.Time
+}
+
+type tokenBucket struct {
+	mu      sync.Mutex
+	tokens  float64
+	updated time.Time
+	full    time.Time
+}
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{buckets: xsync.NewMap(), now: time.Now}
+}
+
+func (s *MemoryRateLimitStore) TakeToken(_ context.Context, key string, rate float64, burst int) (bool, float64, error) {
+	now := s.now()
+	s.prune(now)
+	value, _ := s.buckets.LoadOrStore(key, &tokenBucket{tokens: float64(burst), updated: now})
+	bucket := value.(*tokenBucket)
+	bucket.mu.Lock()
+	defer bucket.mu.Unlock()
+	bucket.tokens = math.Min(float64(burst), bucket.tokens+now.Sub(bucket.updated).Seconds()*rate)
+	bucket.updated = now
+	allowed := bucket.tokens >= 1
+	if allowed {
+		bucket.tokens--
+	}
+	bucket.full = now.Add(time.Duration((float64(burst) - bucket.tokens) / rate * float64(time.Second)))
+	return allowed, bucket.tokens, nil
+}
+
+func (s *MemoryRateLimitStore) prune(now time.Time) {
+	last := s.lastPrune.Load()
+	if now.UnixNano()-last < int64(time.Minute) || !s.lastPrune.CompareAndSwap(last, now.UnixNano()) {
+		return
+	}
+	s.buckets.Range(func(key string, value interface{}) bool {
+		bucket := value.(*tokenBucket)
+		bucket.mu.Lock()
+		idle := now.Sub(bucket.full) > time.Minute
+		bucket.mu.Unlock()
+		if idle {
+			s.buckets.Delete(key)
+		}
+		return true
+	})
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"github.com/Paincake/avito-tech/internal/database"
+	"github.com/Paincake/avito-tech/internal/dto"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMemoryRateLimitStore_ShouldRefillAtRate(t *testing.T) {
+	now := time.Unix(0, 0)
+	store := NewMemoryRateLimitStore()
+	store.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		allowed, _, _ := store.TakeToken(ctx, "a", 2, 3)
+		assert.True(t, allowed)
+	}
+	allowed, remaining, _ := store.TakeToken(ctx, "a", 2, 3)
+	assert.False(t, allowed)
+	assert.Zero(t, remaining)
+	allowed, _, _ = store.TakeToken(ctx, "b", 2, 3)
+	assert.True(t, allowed, "buckets are per key")
+
+	now = now.Add(500 * time.Millisecond)
+	allowed, _, _ = store.TakeToken(ctx, "a", 2, 3)
+	assert.True(t, allowed)
+	allowed, _, _ = store.TakeToken(ctx, "a", 2, 3)
+	assert.False(t, allowed)
+
+	now = now.Add(time.Hour)
+	_, remaining, _ = store.TakeToken(ctx, "a", 2, 3)
+	assert.Equal(t, 2.0, remaining, "refill is capped at the burst")
+}
+
+func rateLimited(limiter *RateLimiter, target, role, username string) *httptest.ResponseRecorder {
+	return tenantRateLimited(limiter, database.DefaultTenant, target, role, username)
+}
+
+func tenantRateLimited(limiter *RateLimiter, tenant int64, target, role, username string) *httptest.ResponseRecorder {
+	e := echo.New()
+	e.HTTPErrorHandler = ErrorHandler
+	e.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if role != "" {
+				c.Set(dto.TokenRoleContextKey, role)
+				c.Set(dto.TokenUsernameContextKey, username)
+				c.SetRequest(c.Request().WithContext(database.WithTenant(c.Request().Context(), tenant)))
+			}
+			return next(c)
+		}
+	}, limiter.Middleware)
+	ok := func(c echo.Context) error { return c.NoContent(http.StatusOK) }
+	e.GET("/user_banner", ok)
+	e.POST("/banner", ok)
+	method := http.MethodGet
+	if target == "/banner" {
+		method = http.MethodPost
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+	return rec
+}
+
+func TestRateLimiter_ShouldRejectOverLimitWithHeaders(t *testing.T) {
+	limiter := NewRateLimiter(NewMemoryRateLimitStore(), map[string]RateLimit{
+		RateLimitLatest: {Rate: 0.5, Burst: 2},
+	})
+	latest := "/user_banner?tag_id=1&feature_id=1&use_last_revision=true"
+
+	rec := rateLimited(limiter, latest, database.UserRole, "alice")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "2", rec.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "1", rec.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, http.StatusOK, rateLimited(limiter, latest, database.UserRole, "alice").Code)
+
+	rec = rateLimited(limiter, latest, database.UserRole, "alice")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "2", rec.Header().Get("Retry-After"))
+	assert.Equal(t, "4", rec.Header().Get("RateLimit-Reset"))
+	assert.Contains(t, rec.Body.String(), CodeRateLimited)
+
+	assert.Equal(t, http.StatusOK, rateLimited(limiter, latest, database.UserRole, "bob").Code, "limits are per subject")
+	assert.Equal(t, http.StatusOK, tenantRateLimited(limiter, 2, latest, database.UserRole, "alice").Code, "limits are per tenant")
+	rec = rateLimited(limiter, "/user_banner?tag_id=1&feature_id=1", database.UserRole, "alice")
+	assert.Equal(t, http.StatusOK, rec.Code, "cached reads are not limited")
+	assert.Empty(t, rec.Header().Get("RateLimit-Limit"))
+}
+
+func TestRateLimiter_ShouldApplyReloadedLimits(t *testing.T) {
+	limiter := NewRateLimiter(NewMemoryRateLimitStore(), map[string]RateLimit{})
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, http.StatusOK, rateLimited(limiter, "/banner", database.AdminRole, "admin").Code)
+	}
+	limiter.SetLimits(map[string]RateLimit{RateLimitAdmin: {Rate: 1, Burst: 1}})
+	assert.Equal(t, http.StatusOK, rateLimited(limiter, "/banner", database.AdminRole, "admin").Code)
+	assert.Equal(t, http.StatusTooManyRequests, rateLimited(limiter, "/banner", database.AdminRole, "admin").Code)
+}
+
+type failingRateLimitStore struct{}
+
+func (failingRateLimitStore) TakeToken(context.Context, string, float64, int) (bool, float64, error) {
+	return false, 0, errors.New("store unreachable")
+}
+
+func TestRateLimiter_ShouldLetRequestsThroughWhenStoreFails(t *testing.T) {
+	limiter := NewRateLimiter(failingRateLimitStore{}, map[string]RateLimit{RateLimitAdmin: {Rate: 1, Burst: 1}})
+	assert.Equal(t, http.StatusOK, rateLimited(limiter, "/banner", database.AdminRole, "admin").Code)
+}
@@ -1,94 +1,468 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"github.com/Paincake/avito-tech/internal/database"
 	"github.com/Paincake/avito-tech/internal/dto"
+	"gopkg.in/validator.v2"
+	"net/http"
 )
 
+// ServerInterface implements the operations of internal/openapi/openapi.yaml.
 type ServerInterface interface {
-	GetBanner(params dto.GetBannerParams) ([]dto.Banner, error)
+	// GetBanner Получение всех баннеров c фильтрацией по фиче и/или тегу
+	// (GET /banner)
+	GetBanner(ctx context.Context, params dto.GetBannerParams) (dto.BannerPage, error)
 	// PostBanner Создание нового баннера
 	// (POST /banner)
-	PostBanner(banner dto.Banner) (int64, error)
+	PostBanner(ctx context.Context, banner dto.Banner, actor dto.Actor) (int64, error)
 	// DeleteBannerID Удаление баннера по идентификатору
 	// (DELETE /banner/{id})
-	DeleteBannerID(params dto.DeleteBannerIdParams) error
+	DeleteBannerID(ctx context.Context, params dto.DeleteBannerIdParams, actor dto.Actor) error
 	// PatchBannerID Обновление содержимого баннера
 	// (PATCH /banner/{id})
-	PatchBannerID(params dto.PatchBannerIdParams, banner dto.Banner) error
+	PatchBannerID(ctx context.Context, params dto.PatchBannerIdParams, banner dto.Banner, actor dto.Actor) error
 	// GetUserBanner Получение баннера для пользователя
 	// (GET /user_banner)
-	GetUserBanner(params dto.GetUserBannerParams) (dto.Content, error)
-	Login(username, password string) (string, error)
-	Signup(username, password string) error
+	GetUserBanner(ctx context.Context, params dto.GetUserBannerParams) (dto.Content, error)
+	// GetUserBanners Получение баннеров для нескольких фич и тегов
+	// (POST /user_banner/batch)
+	GetUserBanners(ctx context.Context, params dto.GetUserBannersParams) ([]dto.UserBannerBatchResult, error)
+	// StreamUserBanner Подписка на изменения баннера для фичи и тега
+	// (GET /user_banner/stream)
+	StreamUserBanner(ctx context.Context, params dto.StreamUserBannerParams) (*BannerStream, error)
+	// ExportBanners Выгрузка всех баннеров, подходящих под фильтры
+	// (GET /banner/export)
+	ExportBanners(ctx context.Context, params dto.GetBannerParams, emit func(record dto.BannerRecord) error) error
+	// ImportBanners Загрузка баннеров из файла
+	// (POST /banner/import)
+	ImportBanners(ctx context.Context, rows []dto.ImportRow, rowErrors []dto.ImportRowError, dryRun, atomic bool, actor dto.Actor) (dto.ImportReport, error)
+	// TransitionBanner Перевод баннера в другой статус согласования
+	// (POST /banner/{id}/submit, /approve, /reject, /publish, /withdraw)
+	TransitionBanner(ctx context.Context, params dto.TransitionBannerParams) error
+	// GetBannerReviews История согласования баннера
+	// (GET /banner/{id}/reviews)
+	GetBannerReviews(ctx context.Context, bannerId int64) ([]dto.BannerReview, error)
+	// GetAudit Журнал действий администраторов
+	// (GET /audit)
+	GetAudit(ctx context.Context, params dto.GetAuditParams) ([]dto.AuditEntry, error)
+	// GetChanges Лента изменений баннеров
+	// (GET /changes)
+	GetChanges(ctx context.Context, params dto.GetChangesParams) (dto.BannerChangePage, error)
+	// PostWebhook Подписка URL на события баннеров
+	// (POST /webhooks)
+	PostWebhook(ctx context.Context, webhook dto.Webhook, actor dto.Actor) (int64, error)
+	// GetWebhooks Список подписок
+	// (GET /webhooks)
+	GetWebhooks(ctx context.Context) ([]dto.Webhook, error)
+	// DeleteWebhookID Удаление подписки
+	// (DELETE /webhooks/{id})
+	DeleteWebhookID(ctx context.Context, id int64, actor dto.Actor) error
+	// GetWebhookDeliveries Журнал доставок подписки
+	// (GET /webhooks/{id}/deliveries)
+	GetWebhookDeliveries(ctx context.Context, params dto.GetWebhookDeliveriesParams) ([]dto.WebhookDelivery, error)
+	// PostAPIKey Выпуск API-ключа
+	// (POST /api_keys)
+	PostAPIKey(ctx context.Context, key dto.APIKey, actor dto.Actor) (dto.APIKey, error)
+	// GetAPIKeys Список API-ключей
+	// (GET /api_keys)
+	GetAPIKeys(ctx context.Context) ([]dto.APIKey, error)
+	// DeleteAPIKeyID Отзыв API-ключа
+	// (DELETE /api_keys/{id})
+	DeleteAPIKeyID(ctx context.Context, id int64, actor dto.Actor) error
+	// GetCacheStats Состояние кэша баннеров
+	// (GET /cache/stats)
+	GetCacheStats(ctx context.Context) (dto.CacheStats, error)
+	// FlushCache Сброс кэша баннеров
+	// (DELETE /cache)
+	FlushCache(ctx context.Context, params dto.FlushCacheParams) (int, error)
+	// PostFeature Создание фичи
+	// (POST /features)
+	PostFeature(ctx context.Context, feature dto.Feature, actor dto.Actor) (int64, error)
+	// GetFeatures Список фич
+	// (GET /features)
+	GetFeatures(ctx context.Context) ([]dto.Feature, error)
+	// PostTag Создание тега
+	// (POST /tags)
+	PostTag(ctx context.Context, tag dto.Tag, actor dto.Actor) (int64, error)
+	// GetTags Список тегов
+	// (GET /tags)
+	GetTags(ctx context.Context) ([]dto.Tag, error)
+	// PostTenant Создание арендатора
+	// (POST /tenants)
+	PostTenant(ctx context.Context, tenant dto.Tenant, actor dto.Actor) (int64, error)
+	// GetTenants Список арендаторов
+	// (GET /tenants)
+	GetTenants(ctx context.Context) ([]dto.Tenant, error)
+	// PostTenantAdmin Создание администратора арендатора
+	// (POST /tenants/{id}/admins)
+	PostTenantAdmin(ctx context.Context, tenantId int64, username, password string, actor dto.Actor) error
+	Login(ctx context.Context, username, password string) (TokenClaims, error)
+	Signup(ctx context.Context, username, password string, actor dto.Actor) error
 }
 
 type Server struct {
 	Repository database.BannerRepository
 	Cache      BannerCache
+	// Bus receives the changes to banners once committed; it may be nil.
+	Bus *ChangeBus
 }
 
-func (s *Server) GetBanner(params dto.GetBannerParams) ([]dto.Banner, error) {
-	dbBanners, err := s.Repository.SelectBanners(params)
+func (s *Server) GetBanner(ctx context.Context, params dto.GetBannerParams) (dto.BannerPage, error) {
+	dbBanners, err := s.Repository.SelectBanners(ctx, params)
 	if err != nil {
-		return nil, err
+		return dto.BannerPage{}, err
+	}
+	total, err := s.Repository.CountBanners(ctx, params)
+	if err != nil {
+		return dto.BannerPage{}, err
 	}
-	var dtoBanners []dto.Banner
+	dtoBanners := make([]dto.Banner, 0, len(dbBanners))
 	for _, banner := range dbBanners {
 		dtoBanner, err := database.ConvertBannerToDto(banner)
 		if err != nil {
-			return nil, err
+			return dto.BannerPage{}, err
 		}
 		dtoBanners = append(dtoBanners, dtoBanner)
 	}
-	return dtoBanners, nil
+	var nextCursor string
+	if len(dbBanners) > 0 && len(dbBanners) == params.Limit {
+		last := dbBanners[len(dbBanners)-1]
+		nextCursor, err = dto.EncodeBannerCursor(database.BannerCursor(last, params.Sort, params.Order))
+		if err != nil {
+			return dto.BannerPage{}, err
+		}
+	}
+	return dto.BannerPage{
+		Banners:    dtoBanners,
+		Total:      total,
+		NextCursor: nextCursor,
+	}, nil
+}
 
+// publish delivers committed events to the subscribers of the caller's
+// tenant, which the repository required to commit them.
+func (s *Server) publish(ctx context.Context, events ...BannerEvent) {
+	tenant, _ := database.TenantOf(ctx)
+	s.Bus.Publish(tenant, events...)
 }
 
-func (s *Server) PostBanner(banner dto.Banner) (int64, error) {
-	id, err := s.Repository.InsertBanner(banner)
+// PostBanner stores a new banner as a draft, it is shown to users only after
+// it has been reviewed and published.
+func (s *Server) PostBanner(ctx context.Context, banner dto.Banner, actor dto.Actor) (int64, error) {
+	var id int64
+	var event BannerEvent
+	err := s.Repository.InTransaction(ctx, func(repository database.BannerRepository) error {
+		var err error
+		id, event, err = insertBanner(ctx, repository, banner, actor)
+		return err
+	})
 	if err != nil {
 		return -1, err
 	}
+	s.publish(ctx, event)
 	return id, nil
 }
 
-func (s *Server) DeleteBannerID(params dto.DeleteBannerIdParams) error {
-	err := s.Repository.DeleteBannerById(params.BannerId)
+func insertBanner(ctx context.Context, repository database.BannerRepository, banner dto.Banner, actor dto.Actor) (int64, BannerEvent, error) {
+	id, err := repository.InsertBanner(ctx, banner, actor.Username)
+	if err != nil {
+		return -1, BannerEvent{}, err
+	}
+	after, err := bannerSnapshot(ctx, repository, id)
+	if err != nil {
+		return -1, BannerEvent{}, err
+	}
+	if err = audit(ctx, repository, actor, AuditActionCreate, AuditTargetBanner, bannerTarget(id), nil, after); err != nil {
+		return -1, BannerEvent{}, err
+	}
+	event := newBannerEvent(nil, after)
+	return id, event, recordEvent(ctx, repository, event)
+}
+
+func (s *Server) DeleteBannerID(ctx context.Context, params dto.DeleteBannerIdParams, actor dto.Actor) error {
+	var event BannerEvent
+	err := s.Repository.InTransaction(ctx, func(repository database.BannerRepository) error {
+		before, err := bannerSnapshot(ctx, repository, params.BannerId)
+		if err != nil {
+			return err
+		}
+		if err = repository.DeleteBannerById(ctx, params.BannerId); err != nil {
+			return err
+		}
+		if err = audit(ctx, repository, actor, AuditActionDelete, AuditTargetBanner, bannerTarget(params.BannerId), before, nil); err != nil {
+			return err
+		}
+		event = newBannerEvent(before, nil)
+		return recordEvent(ctx, repository, event)
+	})
 	if err != nil {
 		return err
 	}
+	s.publish(ctx, event)
 	return nil
 }
 
-func (s *Server) PatchBannerID(params dto.PatchBannerIdParams, banner dto.Banner) error {
-	err := s.Repository.UpdateBannerById(params.BannerId, banner)
+// PatchBannerID replaces a banner and sends it back to draft: users are no
+// longer shown it until the new content is reviewed and published.
+func (s *Server) PatchBannerID(ctx context.Context, params dto.PatchBannerIdParams, banner dto.Banner, actor dto.Actor) error {
+	var event BannerEvent
+	err := s.Repository.InTransaction(ctx, func(repository database.BannerRepository) error {
+		var err error
+		event, err = updateBanner(ctx, repository, params.BannerId, banner, actor)
+		return err
+	})
 	if err != nil {
 		return err
 	}
+	s.publish(ctx, event)
 	return nil
 }
-func (s *Server) GetUserBanner(params dto.GetUserBannerParams) (dto.Content, error) {
+
+func updateBanner(ctx context.Context, repository database.BannerRepository, id int64, banner dto.Banner, actor dto.Actor) (BannerEvent, error) {
+	before, err := bannerSnapshot(ctx, repository, id)
+	if err != nil {
+		return BannerEvent{}, err
+	}
+	if err = repository.UpdateBannerById(ctx, id, banner, actor.Username); err != nil {
+		return BannerEvent{}, err
+	}
+	after, err := bannerSnapshot(ctx, repository, id)
+	if err != nil {
+		return BannerEvent{}, err
+	}
+	if err = audit(ctx, repository, actor, AuditActionUpdate, AuditTargetBanner, bannerTarget(id), before, after); err != nil {
+		return BannerEvent{}, err
+	}
+	event := newBannerEvent(before, after)
+	return event, recordEvent(ctx, repository, event)
+}
+func (s *Server) GetUserBanner(ctx context.Context, params dto.GetUserBannerParams) (dto.Content, error) {
 	var banner database.UserBanner
 	var err error
 	if params.LastRevision {
-		banner, err = s.Repository.SelectUserBanner(params)
+		banner, err = s.Repository.SelectUserBanner(ctx, params)
 	} else {
-		banner, err = s.Cache.GetBanner(params.FeatureId, params)
+		banner, err = s.Cache.GetBanner(ctx, params.FeatureId, params)
 	}
 	if err != nil {
 		return dto.Content{}, err
 	}
 	return database.ConvertUserBannerToDto(banner), nil
 }
-func (s *Server) Login(username, password string) (string, error) {
-	role, err := s.Repository.Login(username, password)
+
+// GetUserBanners answers every item of a batch in the order of the request.
+func (s *Server) GetUserBanners(ctx context.Context, params dto.GetUserBannersParams) ([]dto.UserBannerBatchResult, error) {
+	banners, err := s.Cache.GetBanners(ctx, params)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	return role, nil
+	results := make([]dto.UserBannerBatchResult, 0, len(params.Items))
+	for _, item := range params.Items {
+		result := dto.UserBannerBatchResult{FeatureId: item.FeatureId, TagId: item.TagId}
+		if banner, ok := banners[dto.UserBannerKey{FeatureId: item.FeatureId, TagId: item.TagId}]; ok {
+			content := database.ConvertUserBannerToDto(banner)
+			result.Found = true
+			result.Content = &content
+		}
+		results = append(results, result)
+	}
+	return results, nil
 }
 
-func (s *Server) Signup(username, password string) error {
-	return s.Repository.Signup(username, password)
+func (s *Server) GetCacheStats(ctx context.Context) (dto.CacheStats, error) {
+	return s.Cache.Stats(), nil
+}
+
+// FlushCache drops the selected banners of the caller's tenant from the
+// cache of this replica, returning how many were dropped.
+func (s *Server) FlushCache(ctx context.Context, params dto.FlushCacheParams) (int, error) {
+	tenant, err := database.TenantOf(ctx)
+	if err != nil {
+		return 0, err
+	}
+	params.TenantId = tenant
+	return s.Cache.Flush(params), nil
+}
+
+// BannerStream is a subscription to the changes of a banner along with the
+// events missed since the Last-Event-ID. Complete is false when some of them
+// are no longer known.
+type BannerStream struct {
+	*Subscription
+	Replay   []BannerEvent
+	Complete bool
+}
+
+func (s *Server) StreamUserBanner(ctx context.Context, params dto.StreamUserBannerParams) (*BannerStream, error) {
+	if s.Bus == nil {
+		return nil, &APIError{Status: http.StatusServiceUnavailable, Code: CodeUnavailable, Message: "banner streaming is disabled"}
+	}
+	tenant, err := database.TenantOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+	subscription, replay, complete := s.Bus.Subscribe(tenant, params.Key, params.LastEventId)
+	return &BannerStream{Subscription: subscription, Replay: replay, Complete: complete}, nil
+}
+
+func (s *Server) ExportBanners(ctx context.Context, params dto.GetBannerParams, emit func(record dto.BannerRecord) error) error {
+	params.Limit = dto.MaxLimit
+	params.Offset = 0
+	params.Sort = dto.SortById
+	params.Order = dto.OrderAsc
+	params.Cursor = nil
+	for {
+		dbBanners, err := s.Repository.SelectBanners(ctx, params)
+		if err != nil {
+			return err
+		}
+		for _, banner := range dbBanners {
+			dtoBanner, err := database.ConvertBannerToDto(banner)
+			if err != nil {
+				return err
+			}
+			if err = emit(dto.BannerRecord{BannerId: banner.BannerID, Banner: dtoBanner}); err != nil {
+				return err
+			}
+		}
+		if len(dbBanners) < params.Limit {
+			return nil
+		}
+		cursor := database.BannerCursor(dbBanners[len(dbBanners)-1], params.Sort, params.Order)
+		params.Cursor = &cursor
+	}
+}
+
+// ImportBanners validates every row and then writes the valid ones through the
+// repository insert and update paths. rowErrors are the rows that could not be
+// decoded. An atomic import writes nothing unless every row is valid and
+// stored successfully. A dry run performs the same writes and rolls them back,
+// so its report matches the one of a real run.
+func (s *Server) ImportBanners(ctx context.Context, rows []dto.ImportRow, rowErrors []dto.ImportRowError, dryRun, atomic bool, actor dto.Actor) (dto.ImportReport, error) {
+	report := dto.ImportReport{DryRun: dryRun, Errors: append(make([]dto.ImportRowError, 0), rowErrors...)}
+	valid := make([]dto.ImportRow, 0, len(rows))
+	for _, row := range rows {
+		if err := validator.Validate(row.Record.Banner); err != nil {
+			report.Errors = append(report.Errors, dto.ImportRowError{Line: row.Line, Error: err.Error()})
+			continue
+		}
+		valid = append(valid, row)
+	}
+	if atomic && len(report.Errors) > 0 {
+		return report, nil
+	}
+
+	// events are published once the transaction storing them commits
+	var events []BannerEvent
+	store := func(repository database.BannerRepository, row dto.ImportRow) error {
+		if row.Record.BannerId == 0 {
+			_, event, err := insertBanner(ctx, repository, row.Record.Banner, actor)
+			if err != nil {
+				return err
+			}
+			events = append(events, event)
+			report.Inserted++
+			return nil
+		}
+		event, err := updateBanner(ctx, repository, row.Record.BannerId, row.Record.Banner, actor)
+		if err != nil {
+			return err
+		}
+		events = append(events, event)
+		report.Updated++
+		return nil
+	}
+
+	// inTransaction runs fn in a transaction, rolled back on a dry run
+	inTransaction := func(fn func(repository database.BannerRepository) error) error {
+		err := s.Repository.InTransaction(ctx, func(repository database.BannerRepository) error {
+			if err := fn(repository); err != nil {
+				return err
+			}
+			if dryRun {
+				return errDryRun
+			}
+			return nil
+		})
+		if errors.Is(err, errDryRun) {
+			return nil
+		}
+		return err
+	}
+
+	if !atomic {
+		for _, row := range valid {
+			events = events[:0]
+			err := inTransaction(func(repository database.BannerRepository) error {
+				return store(repository, row)
+			})
+			if err != nil {
+				report.Errors = append(report.Errors, dto.ImportRowError{Line: row.Line, Error: importError(err)})
+				continue
+			}
+			if !dryRun {
+				s.publish(ctx, events...)
+			}
+		}
+		return report, nil
+	}
+
+	var failed dto.ImportRowError
+	err := inTransaction(func(repository database.BannerRepository) error {
+		for _, row := range valid {
+			if err := store(repository, row); err != nil {
+				failed = dto.ImportRowError{Line: row.Line, Error: importError(err)}
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		report.Inserted, report.Updated = 0, 0
+		if failed.Line == 0 {
+			return dto.ImportReport{}, err
+		}
+		report.Errors = append(report.Errors, failed)
+		return report, nil
+	}
+	if !dryRun {
+		s.publish(ctx, events...)
+	}
+	return report, nil
+}
+
+// errDryRun rolls back the transactions of a dry run import.
+var errDryRun = errors.New("dry run")
+
+// importError is the message reported for a row that could not be stored;
+// like error responses it does not expose internal errors.
+func importError(err error) string {
+	return ToAPIError(err).Message
+}
+
+// Login checks the credentials of a user of the tenant of ctx.
+func (s *Server) Login(ctx context.Context, username, password string) (TokenClaims, error) {
+	user, err := s.Repository.Login(ctx, username, password)
+	if err != nil {
+		return TokenClaims{}, err
+	}
+	return TokenClaims{Role: user.Role, Username: user.Username, Tenant: user.TenantID}, nil
+}
+
+// Signup registers a user of the default tenant, whatever the tenant of ctx:
+// users of other tenants are only created by PostTenantAdmin.
+func (s *Server) Signup(ctx context.Context, username, password string, actor dto.Actor) error {
+	ctx = database.WithTenant(ctx, database.DefaultTenant)
+	return s.Repository.InTransaction(ctx, func(repository database.BannerRepository) error {
+		if err := repository.Signup(ctx, username, password); err != nil {
+			return err
+		}
+		if actor.Username == "" {
+			actor.Username = username
+		}
+		after := map[string]string{"username": username, "role": database.UserRole}
+		return audit(ctx, repository, actor, AuditActionCreate, AuditTargetUser, username, nil, after)
+	})
 }
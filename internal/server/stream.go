@@ -0,0 +1,109 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Paincake/avito-tech/internal/database"
+	"github.com/Paincake/avito-tech/internal/dto"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"time"
+)
+
+const (
+	// sseReset tells the client it missed events and must reload the banner.
+	sseReset = "reset"
+	// sseLagged tells the client it was too slow and must reconnect with
+	// Last-Event-ID.
+	sseLagged = "lagged"
+)
+
+// StreamUserBanner pushes the changes to the banner of a feature and tag as
+// server-sent events, with a comment line every Options.StreamHeartbeatInterval
+// so that proxies keep the connection open.
+func (w *ServerInterfaceWrapper) StreamUserBanner(ctx echo.Context) error {
+	role := ctx.Get(dto.TokenRoleContextKey)
+	if role != database.AdminRole && role != database.UserRole {
+		return errForbidden
+	}
+	params, err := dto.NewStreamUserBannerParams(ctx)
+	if err != nil {
+		return invalidParameter(err)
+	}
+	stream, err := w.Handler.StreamUserBanner(ctx.Request().Context(), *params)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	response := ctx.Response()
+	response.Header().Set(echo.HeaderContentType, "text/event-stream")
+	response.Header().Set(echo.HeaderCacheControl, "no-cache")
+	response.Header().Set(echo.HeaderConnection, "keep-alive")
+	response.WriteHeader(http.StatusOK)
+	// the server write timeout is meant for regular responses
+	_ = http.NewResponseController(response.Writer).SetWriteDeadline(time.Time{})
+
+	if !stream.Complete {
+		if writeEvent(response, "", sseReset, struct{}{}) != nil {
+			return nil
+		}
+	}
+	for _, event := range stream.Replay {
+		if writeEvent(response, fmt.Sprint(event.Id), event.Type, visibleTo(role, event)) != nil {
+			return nil
+		}
+	}
+	response.Flush()
+
+	heartbeat := time.NewTicker(w.Options.StreamHeartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-ctx.Request().Context().Done():
+			return nil
+		case event, ok := <-stream.Events():
+			if !ok {
+				if stream.Lagged() {
+					_ = writeEvent(response, "", sseLagged, struct{}{})
+					response.Flush()
+				}
+				return nil
+			}
+			if writeEvent(response, fmt.Sprint(event.Id), event.Type, visibleTo(role, event)) != nil {
+				return nil
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(response, ": heartbeat\n\n"); err != nil {
+				return nil
+			}
+		}
+		response.Flush()
+	}
+}
+
+// visibleTo drops the content of inactive or unpublished banners from the
+// events sent to users, as GET /user_banner would not serve it to them either.
+func visibleTo(role any, event BannerEvent) BannerEvent {
+	if role == database.AdminRole || event.Banner == nil {
+		return event
+	}
+	if !event.Banner.IsActive || event.Banner.Status != dto.StatusPublished {
+		event.Banner = nil
+	}
+	return event
+}
+
+func writeEvent(response *echo.Response, id, name string, data any) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if id != "" {
+		if _, err = fmt.Fprintf(response, "id: %s\n", id); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(response, "event: %s\ndata: %s\n\n", name, payload)
+	return err
+}
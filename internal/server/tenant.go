@@ -0,0 +1,68 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"github.com/Paincake/avito-tech/internal/database"
+	"github.com/Paincake/avito-tech/internal/database/postgres"
+	"github.com/Paincake/avito-tech/internal/dto"
+	"net/http"
+	"strconv"
+)
+
+func tenantNotFound(err error) error {
+	var notFound postgres.EntityNotFound
+	if errors.As(err, &notFound) {
+		return &APIError{Status: http.StatusNotFound, Code: CodeTenantNotFound, Message: "tenant not found", Err: err}
+	}
+	return err
+}
+
+func (s *Server) PostTenant(ctx context.Context, tenant dto.Tenant, actor dto.Actor) (int64, error) {
+	var id int64
+	err := s.Repository.InTransaction(ctx, func(repository database.BannerRepository) error {
+		var err error
+		id, err = repository.InsertTenant(ctx, tenant.Name)
+		if err != nil {
+			return err
+		}
+		after, err := repository.SelectTenantById(ctx, id)
+		if err != nil {
+			return err
+		}
+		snapshot := database.ConvertTenantToDto(after)
+		return audit(ctx, repository, actor, AuditActionCreate, AuditTargetTenant, strconv.FormatInt(id, 10), nil, &snapshot)
+	})
+	if err != nil {
+		return -1, err
+	}
+	return id, nil
+}
+
+func (s *Server) GetTenants(ctx context.Context) ([]dto.Tenant, error) {
+	tenants, err := s.Repository.SelectTenants(ctx)
+	if err != nil {
+		return nil, err
+	}
+	dtoTenants := make([]dto.Tenant, 0, len(tenants))
+	for _, tenant := range tenants {
+		dtoTenants = append(dtoTenants, database.ConvertTenantToDto(tenant))
+	}
+	return dtoTenants, nil
+}
+
+// PostTenantAdmin adds an admin to a tenant. The entry auditing it belongs
+// to that tenant, so its admins can tell who created their account.
+func (s *Server) PostTenantAdmin(ctx context.Context, tenantId int64, username, password string, actor dto.Actor) error {
+	return s.Repository.InTransaction(ctx, func(repository database.BannerRepository) error {
+		if _, err := repository.SelectTenantById(ctx, tenantId); err != nil {
+			return tenantNotFound(err)
+		}
+		ctx := database.WithTenant(ctx, tenantId)
+		if err := repository.InsertUser(ctx, username, password, database.AdminRole); err != nil {
+			return err
+		}
+		after := map[string]string{"username": username, "role": database.AdminRole}
+		return audit(ctx, repository, actor, AuditActionCreate, AuditTargetUser, username, nil, after)
+	})
+}
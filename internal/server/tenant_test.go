@@ -0,0 +1,146 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/Paincake/avito-tech/internal/config"
+	"github.com/Paincake/avito-tech/internal/database"
+	"github.com/Paincake/avito-tech/internal/database/postgres"
+	"github.com/Paincake/avito-tech/internal/dto"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// userRepository keeps the users of tenants 1 and 2, the same username
+// possibly belonging to both.
+type userRepository struct {
+	outboxRepository
+	users map[int64]map[string]database.User
+}
+
+func (r *userRepository) InTransaction(ctx context.Context, fn func(repository database.BannerRepository) error) error {
+	return r.outboxRepository.InTransaction(ctx, func(database.BannerRepository) error { return fn(r) })
+}
+
+func (r *userRepository) SelectTenantById(_ context.Context, id int64) (database.Tenant, error) {
+	if id != database.DefaultTenant && id != 2 {
+		return database.Tenant{}, postgres.EntityNotFound{Err: errors.New("tenant not found")}
+	}
+	return database.Tenant{ID: id}, nil
+}
+
+func (r *userRepository) Signup(ctx context.Context, username string, password string) error {
+	return r.InsertUser(ctx, username, password, database.UserRole)
+}
+
+func (r *userRepository) InsertUser(ctx context.Context, username string, password string, role string) error {
+	tenant, err := database.TenantOf(ctx)
+	if err != nil {
+		return err
+	}
+	if _, ok := r.users[tenant][username]; ok {
+		return postgres.EntityConflict{Err: errors.New("user exists")}
+	}
+	if r.users[tenant] == nil {
+		r.users[tenant] = map[string]database.User{}
+	}
+	r.users[tenant][username] = database.User{Username: username, Password: password, Role: role, TenantID: tenant}
+	return nil
+}
+
+func (r *userRepository) Login(ctx context.Context, username string, password string) (database.User, error) {
+	tenant, err := database.TenantOf(ctx)
+	if err != nil {
+		return database.User{}, err
+	}
+	user, ok := r.users[tenant][username]
+	if !ok || bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) != nil {
+		return database.User{}, postgres.InvalidCredentials{Err: errors.New("wrong credentials")}
+	}
+	return user, nil
+}
+
+func tenantWrapper() (*ServerInterfaceWrapper, *userRepository) {
+	ConfigureAuth("test-secret", time.Hour)
+	repository := &userRepository{users: map[int64]map[string]database.User{}}
+	return &ServerInterfaceWrapper{
+		Handler: &Server{Repository: repository},
+		Options: config.Config{BcryptCost: bcrypt.MinCost},
+	}, repository
+}
+
+func TestSignup_ShouldNotLandInAnotherTenant(t *testing.T) {
+	wrapper, repository := tenantWrapper()
+	req := httptest.NewRequest(http.MethodPost, "/signup", strings.NewReader(`{"username": "mallory", "password": "secret", "tenant_id": 2}`))
+	req = req.WithContext(database.WithTenant(req.Context(), 2))
+	rec := httptest.NewRecorder()
+
+	require.NoError(t, wrapper.Signup(echo.New().NewContext(req, rec)))
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.Contains(t, repository.users[database.DefaultTenant], "mallory")
+	assert.Empty(t, repository.users[2])
+}
+
+func login(wrapper *ServerInterfaceWrapper, tenant, username string) (*httptest.ResponseRecorder, error) {
+	req := httptest.NewRequest(http.MethodPost, "/login", nil)
+	req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(username+":secret")))
+	if tenant != "" {
+		req.Header.Set(TenantHeader, tenant)
+	}
+	rec := httptest.NewRecorder()
+	return rec, wrapper.Login(echo.New().NewContext(req, rec))
+}
+
+func TestLogin_ShouldTellUsersOfTenantsApart(t *testing.T) {
+	wrapper, _ := tenantWrapper()
+	password, _ := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	ctx := context.Background()
+	require.NoError(t, wrapper.Handler.Signup(ctx, "alice", string(password), dto.Actor{Username: "alice"}))
+	require.NoError(t, wrapper.Handler.PostTenantAdmin(ctx, 2, "alice", string(password), dto.Actor{Username: "root"}))
+
+	for tenant, want := range map[string]TokenClaims{
+		"":  {Username: "alice", Role: database.UserRole, Tenant: database.DefaultTenant},
+		"2": {Username: "alice", Role: database.AdminRole, Tenant: 2},
+	} {
+		rec, err := login(wrapper, tenant, "alice")
+		require.NoError(t, err)
+		var body struct {
+			Token string `json:"token"`
+		}
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&body))
+		claims, err := ParseToken(body.Token)
+		require.NoError(t, err)
+		assert.Equal(t, want, claims, "tenant %q", tenant)
+	}
+
+	_, err := login(wrapper, "3", "alice")
+	assert.Equal(t, CodeInvalidCredentials, ToAPIError(err).Code, "alice is unknown to tenant 3")
+	_, err = login(wrapper, "0", "alice")
+	assert.Equal(t, http.StatusBadRequest, ToAPIError(err).Status)
+}
+
+func TestGetCacheStats_ShouldBeSuperAdminOnly(t *testing.T) {
+	done := make(chan bool)
+	defer close(done)
+	wrapper := &ServerInterfaceWrapper{Handler: &Server{Cache: NewMemoryCache(nil, 5, 1, done)}}
+	for role, want := range map[string]int{database.AdminRole: http.StatusForbidden, database.SuperAdminRole: http.StatusOK} {
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/cache/stats", nil), rec)
+		c.Set(dto.TokenRoleContextKey, role)
+		err := wrapper.GetCacheStats(c)
+		if err != nil {
+			assert.Equal(t, want, ToAPIError(err).Status, role)
+			continue
+		}
+		assert.Equal(t, want, rec.Code, role)
+	}
+}
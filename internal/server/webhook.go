@@ -0,0 +1,283 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Paincake/avito-tech/internal/config"
+	"github.com/Paincake/avito-tech/internal/database"
+	"github.com/Paincake/avito-tech/internal/database/postgres"
+	"github.com/Paincake/avito-tech/internal/dto"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	WebhookEventHeader     = "X-Webhook-Event"
+	WebhookDeliveryHeader  = "X-Webhook-Delivery"
+	WebhookTimestampHeader = "X-Webhook-Timestamp"
+	WebhookSignatureHeader = "X-Webhook-Signature"
+)
+
+// webhookEvents are the event types a webhook may subscribe to.
+var webhookEvents = []string{BannerEventCreated, BannerEventUpdated, BannerEventDeactivated, BannerEventDeleted,
+	BannerEventSubmitted, BannerEventApproved, BannerEventRejected, BannerEventPublished, BannerEventWithdrawn}
+
+// SignWebhook returns the signature of a delivery sent in the
+// X-Webhook-Signature header: sha256= followed by the hex HMAC-SHA256, keyed
+// with the webhook secret, of the X-Webhook-Timestamp value, a dot and the
+// body. Receivers should reject timestamps too far from their clock.
+func SignWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func validateWebhook(webhook dto.Webhook) error {
+	target, err := url.Parse(webhook.Url)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return errors.New("url must be an absolute http or https URL")
+	}
+	if webhook.Secret == "" {
+		return errors.New("secret must not be empty")
+	}
+	for _, event := range webhook.Events {
+		if !slices.Contains(webhookEvents, event) {
+			return fmt.Errorf("unknown event %q", event)
+		}
+	}
+	return nil
+}
+
+// enqueueWebhooks adds the deliveries of event to the outbox.
+func enqueueWebhooks(ctx context.Context, repository database.BannerRepository, event BannerEvent) error {
+	payload, err := json.Marshal(dto.WebhookPayload{
+		Event:      event.Type,
+		BannerId:   event.BannerId,
+		Banner:     event.Banner,
+		OccurredAt: time.Now().UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		return err
+	}
+	return repository.InsertWebhookDeliveries(ctx, event.Type, payload)
+}
+
+func webhookNotFound(err error) error {
+	var notFound postgres.EntityNotFound
+	if errors.As(err, &notFound) {
+		return &APIError{Status: http.StatusNotFound, Code: CodeWebhookNotFound, Message: "webhook not found", Err: err}
+	}
+	return err
+}
+
+func (s *Server) PostWebhook(ctx context.Context, webhook dto.Webhook, actor dto.Actor) (int64, error) {
+	var id int64
+	err := s.Repository.InTransaction(ctx, func(repository database.BannerRepository) error {
+		var err error
+		id, err = repository.InsertWebhook(ctx, webhook, actor.Username)
+		if err != nil {
+			return err
+		}
+		after, err := repository.SelectWebhookById(ctx, id)
+		if err != nil {
+			return err
+		}
+		snapshot := database.ConvertWebhookToDto(after)
+		return audit(ctx, repository, actor, AuditActionCreate, AuditTargetWebhook, strconv.FormatInt(id, 10), nil, &snapshot)
+	})
+	if err != nil {
+		return -1, err
+	}
+	return id, nil
+}
+
+func (s *Server) GetWebhooks(ctx context.Context) ([]dto.Webhook, error) {
+	webhooks, err := s.Repository.SelectWebhooks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	dtoWebhooks := make([]dto.Webhook, 0, len(webhooks))
+	for _, webhook := range webhooks {
+		dtoWebhooks = append(dtoWebhooks, database.ConvertWebhookToDto(webhook))
+	}
+	return dtoWebhooks, nil
+}
+
+// DeleteWebhookID removes a webhook along with its pending deliveries and
+// delivery log.
+func (s *Server) DeleteWebhookID(ctx context.Context, id int64, actor dto.Actor) error {
+	err := s.Repository.InTransaction(ctx, func(repository database.BannerRepository) error {
+		before, err := repository.SelectWebhookById(ctx, id)
+		if err != nil {
+			return err
+		}
+		if err = repository.DeleteWebhookById(ctx, id); err != nil {
+			return err
+		}
+		snapshot := database.ConvertWebhookToDto(before)
+		return audit(ctx, repository, actor, AuditActionDelete, AuditTargetWebhook, strconv.FormatInt(id, 10), &snapshot, nil)
+	})
+	return webhookNotFound(err)
+}
+
+func (s *Server) GetWebhookDeliveries(ctx context.Context, params dto.GetWebhookDeliveriesParams) ([]dto.WebhookDelivery, error) {
+	if _, err := s.Repository.SelectWebhookById(ctx, params.WebhookId); err != nil {
+		return nil, webhookNotFound(err)
+	}
+	deliveries, err := s.Repository.SelectWebhookDeliveries(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	dtoDeliveries := make([]dto.WebhookDelivery, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		dtoDeliveries = append(dtoDeliveries, database.ConvertWebhookDeliveryToDto(delivery))
+	}
+	return dtoDeliveries, nil
+}
+
+// WebhookStore holds the webhook outbox the dispatcher sends from.
+type WebhookStore interface {
+	ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]database.WebhookDelivery, error)
+	UpdateWebhookDelivery(ctx context.Context, delivery database.WebhookDelivery) error
+}
+
+// WebhookDispatcher sends the deliveries of the outbox, retrying failed ones
+// with exponential backoff until Options.WebhookMaxAttempts is reached.
+// Several replicas may run one against the same store.
+type WebhookDispatcher struct {
+	store   WebhookStore
+	client  *http.Client
+	options config.Config
+	now     func() time.Time
+}
+
+func NewWebhookDispatcher(store WebhookStore, options config.Config) *WebhookDispatcher {
+	return &WebhookDispatcher{
+		store:   store,
+		client:  &http.Client{Timeout: options.WebhookTimeout},
+		options: options,
+		now:     time.Now,
+	}
+}
+
+// Run dispatches every Options.WebhookPollInterval until ctx is done.
+func (d *WebhookDispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.options.WebhookPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		// keep going while full batches are due
+		for {
+			sent, err := d.Dispatch(ctx)
+			if err != nil {
+				errorLogger.Error("WEBHOOK_DISPATCH_FAILED", slog.String("err", err.Error()))
+			}
+			if err != nil || sent < d.options.WebhookBatch {
+				break
+			}
+		}
+	}
+}
+
+// Dispatch sends a batch of due deliveries concurrently and records the
+// outcome of each, returning how many were attempted.
+func (d *WebhookDispatcher) Dispatch(ctx context.Context) (int, error) {
+	// a claimed delivery is retried once the lease expires if its outcome
+	// is never recorded
+	lease := 2*d.options.WebhookTimeout + d.options.WebhookPollInterval
+	deliveries, err := d.store.ClaimWebhookDeliveries(ctx, d.options.WebhookBatch, lease)
+	if err != nil {
+		return 0, err
+	}
+	var wg sync.WaitGroup
+	for _, delivery := range deliveries {
+		wg.Add(1)
+		go func(delivery database.WebhookDelivery) {
+			defer wg.Done()
+			delivery = d.attempt(ctx, delivery)
+			// record the outcome even when shutting down mid-attempt
+			if err := d.store.UpdateWebhookDelivery(context.WithoutCancel(ctx), delivery); err != nil {
+				errorLogger.Error("WEBHOOK_DISPATCH_FAILED",
+					slog.Int64("delivery_id", delivery.ID),
+					slog.String("err", err.Error()))
+			}
+		}(delivery)
+	}
+	wg.Wait()
+	return len(deliveries), nil
+}
+
+// attempt sends delivery, whose Attempts already counts this attempt, and
+// returns it updated with the outcome.
+func (d *WebhookDispatcher) attempt(ctx context.Context, delivery database.WebhookDelivery) database.WebhookDelivery {
+	status, err := d.send(ctx, delivery)
+	now := d.now()
+	delivery.ResponseStatus = status
+	if err == nil {
+		delivery.Status = dto.DeliveryDelivered
+		delivery.LastError = ""
+		delivery.DeliveredAt = &now
+		return delivery
+	}
+	delivery.LastError = err.Error()
+	if delivery.Attempts >= d.options.WebhookMaxAttempts {
+		delivery.Status = dto.DeliveryFailed
+		return delivery
+	}
+	delivery.Status = dto.DeliveryPending
+	delivery.NextAttemptAt = now.Add(d.backoff(delivery.Attempts))
+	return delivery
+}
+
+// backoff is the delay after the given failed attempt, doubling from
+// Options.WebhookBackoff up to Options.WebhookMaxBackoff.
+func (d *WebhookDispatcher) backoff(attempts int) time.Duration {
+	delay := d.options.WebhookBackoff
+	for i := 1; i < attempts && delay < d.options.WebhookMaxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, d.options.WebhookMaxBackoff)
+}
+
+// send posts the payload of delivery; any status but 2xx is a failure.
+func (d *WebhookDispatcher) send(ctx context.Context, delivery database.WebhookDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := d.now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "banner-service-webhooks")
+	req.Header.Set(WebhookEventHeader, delivery.Event)
+	req.Header.Set(WebhookDeliveryHeader, strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set(WebhookTimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(delivery.Secret, timestamp, delivery.Payload))
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
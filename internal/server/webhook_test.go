@@ -0,0 +1,189 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/Paincake/avito-tech/internal/config"
+	"github.com/Paincake/avito-tech/internal/database"
+	"github.com/Paincake/avito-tech/internal/dto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryWebhookStore hands out its deliveries once each and keeps the
+// recorded outcomes.
+type memoryWebhookStore struct {
+	mu       sync.Mutex
+	pending  []database.WebhookDelivery
+	recorded []database.WebhookDelivery
+}
+
+func (s *memoryWebhookStore) ClaimWebhookDeliveries(_ context.Context, limit int, _ time.Duration) ([]database.WebhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	claimed := s.pending[:min(limit, len(s.pending))]
+	s.pending = s.pending[len(claimed):]
+	for i := range claimed {
+		claimed[i].Attempts++
+	}
+	return claimed, nil
+}
+
+func (s *memoryWebhookStore) UpdateWebhookDelivery(_ context.Context, delivery database.WebhookDelivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.recorded = append(s.recorded, delivery)
+	return nil
+}
+
+func webhookOptions() config.Config {
+	return config.Config{
+		WebhookTimeout:     time.Second,
+		WebhookMaxAttempts: 3,
+		WebhookBackoff:     time.Second,
+		WebhookMaxBackoff:  3 * time.Second,
+		WebhookBatch:       10,
+	}
+}
+
+func TestWebhookDispatcher_ShouldSignDeliveries(t *testing.T) {
+	received := make(chan *http.Request, 1)
+	bodies := make(chan []byte, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- r
+		bodies <- body
+	}))
+	defer receiver.Close()
+	payload := []byte(`{"event":"created","banner_id":7}`)
+	store := &memoryWebhookStore{pending: []database.WebhookDelivery{
+		{ID: 1, Event: BannerEventCreated, Payload: payload, Status: dto.DeliveryPending, URL: receiver.URL, Secret: "s3cret"},
+	}}
+
+	sent, err := NewWebhookDispatcher(store, webhookOptions()).Dispatch(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, sent)
+
+	req, body := <-received, <-bodies
+	assert.Equal(t, payload, body)
+	assert.Equal(t, BannerEventCreated, req.Header.Get(WebhookEventHeader))
+	assert.Equal(t, "1", req.Header.Get(WebhookDeliveryHeader))
+	timestamp, err := strconv.ParseInt(req.Header.Get(WebhookTimestampHeader), 10, 64)
+	require.NoError(t, err)
+	assert.Equal(t, SignWebhook("s3cret", timestamp, body), req.Header.Get(WebhookSignatureHeader))
+	assert.NotEqual(t, SignWebhook("other", timestamp, body), req.Header.Get(WebhookSignatureHeader))
+
+	require.Len(t, store.recorded, 1)
+	assert.Equal(t, dto.DeliveryDelivered, store.recorded[0].Status)
+	assert.Equal(t, http.StatusOK, store.recorded[0].ResponseStatus)
+	assert.NotNil(t, store.recorded[0].DeliveredAt)
+}
+
+func TestWebhookDispatcher_ShouldRetryWithBackoffThenGiveUp(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer receiver.Close()
+	now := time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)
+	store := &memoryWebhookStore{}
+	dispatcher := NewWebhookDispatcher(store, webhookOptions())
+	dispatcher.now = func() time.Time { return now }
+
+	delivery := database.WebhookDelivery{ID: 1, Payload: []byte(`{}`), Status: dto.DeliveryPending, URL: receiver.URL, Secret: "s"}
+	var delays []time.Duration
+	for attempt := 0; attempt < 3; attempt++ {
+		store.pending = append(store.pending, delivery)
+		_, err := dispatcher.Dispatch(context.Background())
+		require.NoError(t, err)
+		delivery = store.recorded[len(store.recorded)-1]
+		assert.Equal(t, http.StatusBadGateway, delivery.ResponseStatus)
+		assert.Equal(t, "unexpected status 502", delivery.LastError)
+		if delivery.Status == dto.DeliveryPending {
+			delays = append(delays, delivery.NextAttemptAt.Sub(now))
+		}
+	}
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second}, delays)
+	assert.Equal(t, dto.DeliveryFailed, delivery.Status)
+	assert.Equal(t, 3, delivery.Attempts)
+	assert.Equal(t, 3*time.Second, dispatcher.backoff(5), "backoff is capped")
+}
+
+// outboxRepository records the change feed and webhook deliveries, and
+// whether they were written in the transaction of the banner change.
+type outboxRepository struct {
+	database.BannerRepository
+	inTransaction bool
+	changes       []database.BannerChange
+	deliveries    []dto.WebhookPayload
+	transactional []bool
+}
+
+func (r *outboxRepository) InTransaction(_ context.Context, fn func(repository database.BannerRepository) error) error {
+	r.inTransaction = true
+	defer func() { r.inTransaction = false }()
+	return fn(r)
+}
+
+func (r *outboxRepository) InsertBanner(context.Context, dto.Banner, string) (int64, error) {
+	return 7, nil
+}
+
+func (r *outboxRepository) DeleteBannerById(context.Context, int64) error {
+	return nil
+}
+
+func (r *outboxRepository) SelectBannerById(_ context.Context, id int64) (database.Banner, error) {
+	return database.Banner{BannerID: id, TagIDs: "{1,2}", FeatureID: 3, IsActive: true}, nil
+}
+
+func (r *outboxRepository) InsertAuditEntry(context.Context, database.AuditEntry) error {
+	return nil
+}
+
+func (r *outboxRepository) InsertBannerChange(_ context.Context, change database.BannerChange) error {
+	r.changes = append(r.changes, change)
+	r.transactional = append(r.transactional, r.inTransaction)
+	return nil
+}
+
+func (r *outboxRepository) InsertWebhookDeliveries(_ context.Context, event string, payload []byte) error {
+	var decoded dto.WebhookPayload
+	if err := json.Unmarshal(payload, &decoded); err != nil {
+		return err
+	}
+	r.deliveries = append(r.deliveries, decoded)
+	r.transactional = append(r.transactional, r.inTransaction)
+	return nil
+}
+
+func TestPostBanner_ShouldEnqueueWebhooksInTransaction(t *testing.T) {
+	repository := &outboxRepository{}
+	s := Server{Repository: repository}
+	id, err := s.PostBanner(context.Background(), dto.Banner{FeatureId: 3, Tags: []int64{1, 2}}, dto.Actor{Username: "admin"})
+	require.NoError(t, err)
+
+	require.Len(t, repository.deliveries, 1)
+	assert.Equal(t, []bool{true, true}, repository.transactional)
+	assert.Equal(t, BannerEventCreated, repository.deliveries[0].Event)
+	assert.Equal(t, id, repository.deliveries[0].BannerId)
+	assert.Equal(t, []int64{1, 2}, repository.deliveries[0].Banner.Tags)
+}
+
+func TestValidateWebhook_ShouldRejectBadUrlsAndEvents(t *testing.T) {
+	valid := dto.Webhook{Url: "https://cdn.example.com/purge", Secret: "s", Events: []string{BannerEventUpdated}}
+	assert.NoError(t, validateWebhook(valid))
+
+	invalid := valid
+	invalid.Url = "ftp://cdn.example.com"
+	assert.Error(t, validateWebhook(invalid))
+	invalid = valid
+	invalid.Events = []string{"archived"}
+	assert.ErrorContains(t, validateWebhook(invalid), `unknown event "archived"`)
+}
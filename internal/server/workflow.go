@@ -0,0 +1,116 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Paincake/avito-tech/internal/database"
+	"github.com/Paincake/avito-tech/internal/database/postgres"
+	"github.com/Paincake/avito-tech/internal/dto"
+)
+
+// WorkflowError is returned when a banner status transition is not allowed.
+type WorkflowError struct {
+	Err error
+}
+
+func (e WorkflowError) Error() string {
+	return e.Err.Error()
+}
+
+// transition moves a banner from one status to another; each transition is
+// audited as its own action and sent as its own event.
+type transition struct {
+	from, to string
+	event    string
+}
+
+// transitions are the steps of the review of a banner: banners go draft ->
+// in_review -> approved -> published, a reviewer may reject a banner in
+// review and a published banner may be withdrawn, both back to draft.
+var transitions = map[string]transition{
+	dto.TransitionSubmit:   {from: dto.StatusDraft, to: dto.StatusInReview, event: BannerEventSubmitted},
+	dto.TransitionApprove:  {from: dto.StatusInReview, to: dto.StatusApproved, event: BannerEventApproved},
+	dto.TransitionReject:   {from: dto.StatusInReview, to: dto.StatusDraft, event: BannerEventRejected},
+	dto.TransitionPublish:  {from: dto.StatusApproved, to: dto.StatusPublished, event: BannerEventPublished},
+	dto.TransitionWithdraw: {from: dto.StatusPublished, to: dto.StatusDraft, event: BannerEventWithdrawn},
+}
+
+// TransitionBanner applies params.Transition to a banner, which must be in
+// the status the transition starts from. Approving and rejecting a banner
+// must be done by someone other than its author and its last editor, and
+// rejections must carry a comment.
+func (s *Server) TransitionBanner(ctx context.Context, params dto.TransitionBannerParams) error {
+	step, ok := transitions[params.Transition]
+	if !ok {
+		return fmt.Errorf("unknown transition %q", params.Transition)
+	}
+	var event BannerEvent
+	err := s.Repository.InTransaction(ctx, func(repository database.BannerRepository) error {
+		banner, err := repository.SelectBannerById(ctx, params.BannerId)
+		if err != nil {
+			return err
+		}
+		if banner.Status != step.from {
+			return WorkflowError{Err: fmt.Errorf("banner can not %s: it is %s, not %s", params.Transition, banner.Status, step.from)}
+		}
+		if step.from == dto.StatusInReview {
+			reviewer := params.Actor.Username
+			if reviewer == "" || reviewer == banner.Author || reviewer == banner.Editor {
+				return WorkflowError{Err: fmt.Errorf("banner must be reviewed by a user other than its author and last editor")}
+			}
+			if params.Transition == dto.TransitionReject && params.Comment == "" {
+				return WorkflowError{Err: fmt.Errorf("rejection requires a comment")}
+			}
+		}
+		err = repository.UpdateBannerStatus(ctx, params.BannerId, database.BannerReview{
+			FromStatus: step.from,
+			ToStatus:   step.to,
+			Reviewer:   params.Actor.Username,
+			Comment:    params.Comment,
+		})
+		var notFound postgres.EntityNotFound
+		if errors.As(err, &notFound) {
+			// the banner read above moved to another status meanwhile
+			return WorkflowError{Err: fmt.Errorf("banner status changed concurrently: %w", err)}
+		}
+		if err != nil {
+			return err
+		}
+		err = audit(ctx, repository, params.Actor, params.Transition, AuditTargetBanner, bannerTarget(params.BannerId),
+			map[string]string{"status": step.from},
+			map[string]string{"status": step.to, "comment": params.Comment})
+		if err != nil {
+			return err
+		}
+		// the status is part of the banner state replicated from the feed
+		after, err := bannerSnapshot(ctx, repository, params.BannerId)
+		if err != nil {
+			return err
+		}
+		// a transition does not change the tags, so after addresses the banner
+		event = newBannerEvent(nil, after)
+		event.Type = step.event
+		return recordEvent(ctx, repository, event)
+	})
+	if err != nil {
+		return err
+	}
+	s.publish(ctx, event)
+	return nil
+}
+
+func (s *Server) GetBannerReviews(ctx context.Context, bannerId int64) ([]dto.BannerReview, error) {
+	if _, err := s.Repository.SelectBannerById(ctx, bannerId); err != nil {
+		return nil, err
+	}
+	reviews, err := s.Repository.SelectBannerReviews(ctx, bannerId)
+	if err != nil {
+		return nil, err
+	}
+	dtoReviews := make([]dto.BannerReview, 0, len(reviews))
+	for _, review := range reviews {
+		dtoReviews = append(dtoReviews, database.ConvertBannerReviewToDto(review))
+	}
+	return dtoReviews, nil
+}
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"github.com/Paincake/avito-tech/internal/database"
+	"github.com/Paincake/avito-tech/internal/database/postgres"
+	"github.com/Paincake/avito-tech/internal/dto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"testing"
+)
+
+// racingRepository serves an approved banner whose status is changed by
+// someone else before it is updated.
+type racingRepository struct {
+	database.BannerRepository
+}
+
+func (r racingRepository) InTransaction(_ context.Context, fn func(repository database.BannerRepository) error) error {
+	return fn(r)
+}
+
+func (racingRepository) SelectBannerById(_ context.Context, id int64) (database.Banner, error) {
+	return database.Banner{BannerID: id, TagIDs: "{1}", FeatureID: 1, Status: dto.StatusApproved, Author: "alice"}, nil
+}
+
+func (racingRepository) UpdateBannerStatus(context.Context, int64, database.BannerReview) error {
+	return postgres.EntityNotFound{Err: errors.New("banner 1 not found in status approved")}
+}
+
+func TestTransitionBanner_ShouldConflictWhenStatusChangedConcurrently(t *testing.T) {
+	s := Server{Repository: racingRepository{}}
+	err := s.TransitionBanner(context.Background(), dto.TransitionBannerParams{
+		BannerId:   1,
+		Transition: dto.TransitionPublish,
+		Actor:      dto.Actor{Username: "alice"},
+	})
+	apiErr := ToAPIError(err)
+	assert.Equal(t, http.StatusConflict, apiErr.Status)
+	assert.Equal(t, CodeInvalidTransition, apiErr.Code)
+}
+
+// reviewRepository keeps the status and last editor of banners of feature 3
+// and tags 1 and 2, all written by alice, and the actions audited.
+type reviewRepository struct {
+	outboxRepository
+	status  string
+	editor  string
+	actions []string
+}
+
+func (r *reviewRepository) InsertAuditEntry(_ context.Context, entry database.AuditEntry) error {
+	r.actions = append(r.actions, entry.Action)
+	return nil
+}
+
+func (r *reviewRepository) InTransaction(ctx context.Context, fn func(repository database.BannerRepository) error) error {
+	return r.outboxRepository.InTransaction(ctx, func(database.BannerRepository) error { return fn(r) })
+}
+
+func (r *reviewRepository) SelectBannerById(ctx context.Context, id int64) (database.Banner, error) {
+	banner, err := r.outboxRepository.SelectBannerById(ctx, id)
+	banner.Status, banner.Author, banner.Editor = r.status, "alice", r.editor
+	return banner, err
+}
+
+func (r *reviewRepository) UpdateBannerById(_ context.Context, _ int64, _ dto.Banner, editor string) error {
+	r.status, r.editor = dto.StatusDraft, editor
+	return nil
+}
+
+func (r *reviewRepository) UpdateBannerStatus(_ context.Context, _ int64, review database.BannerReview) error {
+	r.status = review.ToStatus
+	if review.ToStatus == dto.StatusInReview {
+		r.editor = review.Reviewer
+	}
+	return nil
+}
+
+func TestTransitionBanner_ShouldRecordAndPublishItsEvent(t *testing.T) {
+	repository := &reviewRepository{status: dto.StatusDraft, editor: "alice"}
+	bus := NewChangeBus(8, 8)
+	s := Server{Repository: repository, Bus: bus}
+	ctx := database.WithTenant(context.Background(), database.DefaultTenant)
+	subscription, _, _ := bus.Subscribe(database.DefaultTenant, dto.UserBannerKey{FeatureId: 3, TagId: 2}, 0)
+	defer subscription.Close()
+
+	steps := []struct {
+		transition, actor, event string
+	}{
+		{dto.TransitionSubmit, "alice", BannerEventSubmitted},
+		{dto.TransitionReject, "bob", BannerEventRejected},
+		{dto.TransitionSubmit, "alice", BannerEventSubmitted},
+		{dto.TransitionApprove, "bob", BannerEventApproved},
+		{dto.TransitionPublish, "bob", BannerEventPublished},
+		{dto.TransitionWithdraw, "bob", BannerEventWithdrawn},
+	}
+	for i, step := range steps {
+		err := s.TransitionBanner(ctx, dto.TransitionBannerParams{BannerId: 7, Transition: step.transition, Actor: dto.Actor{Username: step.actor}, Comment: "ok"})
+		require.NoError(t, err, step.transition)
+		assert.Equal(t, step.transition, repository.actions[i], "audited as its own action")
+		assert.Equal(t, step.event, repository.changes[i].Event)
+		assert.Equal(t, step.event, repository.deliveries[i].Event, "webhooks are enqueued")
+		event := <-subscription.Events()
+		assert.Equal(t, step.event, event.Type)
+		assert.Equal(t, int64(7), event.BannerId)
+	}
+	assert.Equal(t, dto.StatusDraft, repository.deliveries[5].Banner.Status)
+}
+
+func TestTransitionBanner_ShouldOnlyApplyFromItsStatus(t *testing.T) {
+	for _, tc := range []struct {
+		status, transition string
+	}{
+		{dto.StatusPublished, dto.TransitionReject},
+		{dto.StatusApproved, dto.TransitionReject},
+		{dto.StatusInReview, dto.TransitionWithdraw},
+		{dto.StatusDraft, dto.TransitionPublish},
+	} {
+		repository := &reviewRepository{status: tc.status}
+		s := Server{Repository: repository}
+		err := s.TransitionBanner(database.WithTenant(context.Background(), database.DefaultTenant),
+			dto.TransitionBannerParams{BannerId: 7, Transition: tc.transition, Actor: dto.Actor{Username: "bob"}, Comment: "no"})
+		apiErr := ToAPIError(err)
+		assert.Equal(t, http.StatusConflict, apiErr.Status, "%s a banner %s", tc.transition, tc.status)
+		assert.Equal(t, CodeInvalidTransition, apiErr.Code)
+		assert.Equal(t, tc.status, repository.status)
+	}
+}
+
+func TestTransitionBanner_ShouldNotLetAnEditorApproveTheirOwnChanges(t *testing.T) {
+	repository := &reviewRepository{status: dto.StatusPublished, editor: "alice"}
+	s := Server{Repository: repository}
+	ctx := database.WithTenant(context.Background(), database.DefaultTenant)
+	transition := func(name, username string) error {
+		return s.TransitionBanner(ctx, dto.TransitionBannerParams{BannerId: 7, Transition: name, Actor: dto.Actor{Username: username}, Comment: "ok"})
+	}
+
+	// bob rewrites alice's banner and submits it himself
+	require.NoError(t, s.PatchBannerID(ctx, dto.PatchBannerIdParams{BannerId: 7}, dto.Banner{FeatureId: 3, Tags: []int64{1}}, dto.Actor{Username: "bob"}))
+	require.NoError(t, transition(dto.TransitionSubmit, "bob"))
+	for _, name := range []string{dto.TransitionApprove, dto.TransitionReject} {
+		apiErr := ToAPIError(transition(name, "bob"))
+		assert.Equal(t, http.StatusConflict, apiErr.Status)
+		assert.Equal(t, CodeInvalidTransition, apiErr.Code)
+	}
+	assert.Error(t, transition(dto.TransitionApprove, "alice"), "alice still wrote the banner")
+	assert.NoError(t, transition(dto.TransitionApprove, "carol"))
+}
@@ -7,59 +7,72 @@ import (
 	"fmt"
 	"github.com/Paincake/avito-tech/internal/config"
 	"github.com/Paincake/avito-tech/internal/database"
-	"github.com/Paincake/avito-tech/internal/database/postgres"
 	"github.com/Paincake/avito-tech/internal/dto"
 	"github.com/labstack/echo/v4"
 	"golang.org/x/crypto/bcrypt"
 	"gopkg.in/validator.v2"
 	"net/http"
+	"strconv"
 	"strings"
 )
 
+const (
+	TotalCountHeader = "X-Total-Count"
+	NextCursorHeader = "X-Next-Cursor"
+	// TenantHeader names the tenant of the user logging in, the default one
+	// when absent.
+	TenantHeader = "X-Tenant-Id"
+)
+
 type ServerInterfaceWrapper struct {
 	Handler ServerInterface
 	Options config.Config
 }
 
+func (w *ServerInterfaceWrapper) pageLimits() dto.PageLimits {
+	return dto.PageLimits{Default: w.Options.DefaultPageLimit, Max: w.Options.MaxPageLimit}
+}
+
 func (w *ServerInterfaceWrapper) GetBanner(ctx echo.Context) error {
 	role := ctx.Get(dto.TokenRoleContextKey)
 	if role != database.AdminRole {
-		return echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("Forbidden"))
+		return errForbidden
 	}
-	var err error
-	params, err := dto.NewGetBannerParams(ctx)
+	params, err := dto.NewGetBannerParams(ctx, w.pageLimits())
 	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter feature_id: %s", err))
+		return invalidParameter(err)
 	}
-	banners := make([]dto.Banner, 0)
-	banners, err = w.Handler.GetBanner(*params)
+	page, err := w.Handler.GetBanner(ctx.Request().Context(), *params)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("internal server error: %s", err))
+		return err
 	}
-
-	return ctx.JSON(http.StatusOK, banners)
+	ctx.Response().Header().Set(TotalCountHeader, strconv.FormatInt(page.Total, 10))
+	if page.NextCursor != "" {
+		ctx.Response().Header().Set(NextCursorHeader, page.NextCursor)
+	}
+	return ctx.JSON(http.StatusOK, page.Banners)
 }
 
 // PostBanner converts echo context to params.
 func (w *ServerInterfaceWrapper) PostBanner(ctx echo.Context) error {
 	role := ctx.Get(dto.TokenRoleContextKey)
 	if role != database.AdminRole {
-		return echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("Forbidden"))
+		return errForbidden
 	}
 	var banner dto.Banner
 	body := ctx.Request().Body
 	decoder := json.NewDecoder(body)
 	err := decoder.Decode(&banner)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid request body"))
+		return invalidBody(err)
 	}
 	err = validator.Validate(banner)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid request body"))
+		return invalidBody(err)
 	}
-	id, err := w.Handler.PostBanner(banner)
+	id, err := w.Handler.PostBanner(ctx.Request().Context(), banner, dto.NewActor(ctx))
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("internal server error: %s", err))
+		return err
 	}
 	return ctx.JSON(http.StatusCreated, struct {
 		BannerID int64 `json:"banner_id"`
@@ -70,15 +83,15 @@ func (w *ServerInterfaceWrapper) PostBanner(ctx echo.Context) error {
 func (w *ServerInterfaceWrapper) DeleteBannerID(ctx echo.Context) error {
 	role := ctx.Get(dto.TokenRoleContextKey)
 	if role != database.AdminRole {
-		return echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("Forbidden"))
+		return errForbidden
 	}
 	params, err := dto.NewDeleteBannerIdParams(ctx)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid request parameter: %s", err))
+		return invalidParameter(err)
 	}
-	err = w.Handler.DeleteBannerID(*params)
+	err = w.Handler.DeleteBannerID(ctx.Request().Context(), *params, dto.NewActor(ctx))
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("internal server error: %s", err))
+		return err
 	}
 	return ctx.NoContent(http.StatusNoContent)
 }
@@ -87,7 +100,7 @@ func (w *ServerInterfaceWrapper) DeleteBannerID(ctx echo.Context) error {
 func (w *ServerInterfaceWrapper) PatchBannerID(ctx echo.Context) error {
 	role := ctx.Get(dto.TokenRoleContextKey)
 	if role != database.AdminRole {
-		return echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("Forbidden"))
+		return errForbidden
 	}
 	var banner dto.Banner
 	body := ctx.Request().Body
@@ -95,25 +108,20 @@ func (w *ServerInterfaceWrapper) PatchBannerID(ctx echo.Context) error {
 	err := decoder.Decode(&banner)
 
 	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid request body"))
+		return invalidBody(err)
 	}
 	err = validator.Validate(banner)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid request body"))
+		return invalidBody(err)
 	}
 
 	params, err := dto.NewPatchBannerIdParams(ctx)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid request parameter: %s", err))
+		return invalidParameter(err)
 	}
-	err = w.Handler.PatchBannerID(*params, banner)
+	err = w.Handler.PatchBannerID(ctx.Request().Context(), *params, banner, dto.NewActor(ctx))
 	if err != nil {
-		var entityErr postgres.EntityNotFound
-		ok := errors.As(err, &entityErr)
-		if ok {
-			return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("no banner for given tag"))
-		}
-		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("internal server error: %s", err))
+		return err
 	}
 	return ctx.NoContent(http.StatusOK)
 }
@@ -122,45 +130,499 @@ func (w *ServerInterfaceWrapper) PatchBannerID(ctx echo.Context) error {
 func (w *ServerInterfaceWrapper) GetUserBanner(ctx echo.Context) error {
 	role := ctx.Get(dto.TokenRoleContextKey)
 	if role != database.AdminRole && role != database.UserRole {
-		return echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("Forbidden"))
+		return errForbidden
 	}
 	params, err := dto.NewGetUserBannerParams(ctx)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid request parameter: %s", err))
+		return invalidParameter(err)
 	}
-	banner, err := w.Handler.GetUserBanner(*params)
+	requestCtx, degraded := WithDegradation(ctx.Request().Context())
+	banner, err := w.Handler.GetUserBanner(requestCtx, *params)
 	if err != nil {
-		var entityErr postgres.EntityNotFound
-		ok := errors.As(err, &entityErr)
-		if ok {
-			return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("no banner found"))
-		}
-		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("internal server error: %s", err))
+		return err
+	}
+	if degraded() {
+		setDegraded(ctx.Response().Header())
 	}
 	return ctx.JSON(http.StatusOK, banner)
 }
 
+// GetUserBanners converts echo context to params.
+func (w *ServerInterfaceWrapper) GetUserBanners(ctx echo.Context) error {
+	role := ctx.Get(dto.TokenRoleContextKey)
+	if role != database.AdminRole && role != database.UserRole {
+		return errForbidden
+	}
+	var batch dto.UserBannerBatch
+	if err := json.NewDecoder(ctx.Request().Body).Decode(&batch); err != nil {
+		return invalidBody(err)
+	}
+	params, err := dto.NewGetUserBannersParams(ctx, batch)
+	if err != nil {
+		return invalidBody(err)
+	}
+	requestCtx, degraded := WithDegradation(ctx.Request().Context())
+	results, err := w.Handler.GetUserBanners(requestCtx, *params)
+	if err != nil {
+		return err
+	}
+	if degraded() {
+		setDegraded(ctx.Response().Header())
+	}
+	return ctx.JSON(http.StatusOK, struct {
+		Items []dto.UserBannerBatchResult `json:"items"`
+	}{Items: results})
+}
+
+// ExportBanners streams every banner matching the filters as csv or ndjson.
+func (w *ServerInterfaceWrapper) ExportBanners(ctx echo.Context) error {
+	role := ctx.Get(dto.TokenRoleContextKey)
+	if role != database.AdminRole {
+		return errForbidden
+	}
+	format := ctx.QueryParams().Get("format")
+	if !dto.ValidFormat(format) {
+		return invalidParameter(errors.New("format must be csv or ndjson"))
+	}
+	params, err := dto.NewGetBannerParams(ctx, w.pageLimits())
+	if err != nil {
+		return invalidParameter(err)
+	}
+	contentType := "application/x-ndjson"
+	if format == dto.FormatCSV {
+		contentType = "text/csv"
+	}
+	response := ctx.Response()
+	response.Header().Set(echo.HeaderContentType, contentType)
+	response.Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=banners.%s", format))
+	encoder, err := dto.NewBannerEncoder(format, response)
+	if err != nil {
+		return err
+	}
+	err = w.Handler.ExportBanners(ctx.Request().Context(), *params, func(record dto.BannerRecord) error {
+		return encoder.Encode(record)
+	})
+	if err != nil {
+		if !response.Committed {
+			return err
+		}
+		// the status line is already sent, all we can do is cut the stream short
+		return nil
+	}
+	if err = encoder.Flush(); err == nil {
+		response.Flush()
+	}
+	return nil
+}
+
+// ImportBanners converts echo context to params.
+func (w *ServerInterfaceWrapper) ImportBanners(ctx echo.Context) error {
+	role := ctx.Get(dto.TokenRoleContextKey)
+	if role != database.AdminRole {
+		return errForbidden
+	}
+	format := ctx.QueryParams().Get("format")
+	if !dto.ValidFormat(format) {
+		return invalidParameter(errors.New("format must be csv or ndjson"))
+	}
+	var dryRun, atomic bool
+	var err error
+	if param := ctx.QueryParams().Get("dry_run"); param != "" {
+		dryRun, err = strconv.ParseBool(param)
+		if err != nil {
+			return invalidParameter(errors.New("invalid dry_run format"))
+		}
+	}
+	if param := ctx.QueryParams().Get("atomic"); param != "" {
+		atomic, err = strconv.ParseBool(param)
+		if err != nil {
+			return invalidParameter(errors.New("invalid atomic format"))
+		}
+	}
+	rows, rowErrors, err := dto.DecodeBannerRecords(format, ctx.Request().Body)
+	if err != nil {
+		return invalidBody(err)
+	}
+	report, err := w.Handler.ImportBanners(ctx.Request().Context(), rows, rowErrors, dryRun, atomic, dto.NewActor(ctx))
+	if err != nil {
+		return err
+	}
+	if atomic && len(report.Errors) > 0 {
+		return ctx.JSON(http.StatusUnprocessableEntity, report)
+	}
+	return ctx.JSON(http.StatusOK, report)
+}
+
+// TransitionBanner returns a handler applying the given transition to a banner.
+func (w *ServerInterfaceWrapper) TransitionBanner(transition string) echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		role := ctx.Get(dto.TokenRoleContextKey)
+		if role != database.AdminRole {
+			return errForbidden
+		}
+		params, err := dto.NewTransitionBannerParams(ctx, transition)
+		if err != nil {
+			return invalidParameter(err)
+		}
+		err = w.Handler.TransitionBanner(ctx.Request().Context(), *params)
+		if err != nil {
+			return err
+		}
+		return ctx.NoContent(http.StatusOK)
+	}
+}
+
+// GetBannerReviews converts echo context to params.
+func (w *ServerInterfaceWrapper) GetBannerReviews(ctx echo.Context) error {
+	role := ctx.Get(dto.TokenRoleContextKey)
+	if role != database.AdminRole {
+		return errForbidden
+	}
+	bannerId, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+	if err != nil {
+		return invalidParameter(fmt.Errorf("invalid banner_id format: %s", err))
+	}
+	reviews, err := w.Handler.GetBannerReviews(ctx.Request().Context(), bannerId)
+	if err != nil {
+		return err
+	}
+	return ctx.JSON(http.StatusOK, reviews)
+}
+
+// GetAudit converts echo context to params.
+func (w *ServerInterfaceWrapper) GetAudit(ctx echo.Context) error {
+	role := ctx.Get(dto.TokenRoleContextKey)
+	if role != database.AdminRole {
+		return errForbidden
+	}
+	params, err := dto.NewGetAuditParams(ctx, w.pageLimits())
+	if err != nil {
+		return invalidParameter(err)
+	}
+	entries, err := w.Handler.GetAudit(ctx.Request().Context(), *params)
+	if err != nil {
+		return err
+	}
+	return ctx.JSON(http.StatusOK, entries)
+}
+
+// GetChanges converts echo context to params.
+func (w *ServerInterfaceWrapper) GetChanges(ctx echo.Context) error {
+	role := ctx.Get(dto.TokenRoleContextKey)
+	if role != database.AdminRole {
+		return errForbidden
+	}
+	params, err := dto.NewGetChangesParams(ctx, w.pageLimits())
+	if err != nil {
+		return invalidParameter(err)
+	}
+	page, err := w.Handler.GetChanges(ctx.Request().Context(), *params)
+	if err != nil {
+		return err
+	}
+	return ctx.JSON(http.StatusOK, page)
+}
+
+// GetCacheStats is reserved to super admins, as the cache is shared by the
+// tenants.
+func (w *ServerInterfaceWrapper) GetCacheStats(ctx echo.Context) error {
+	role := ctx.Get(dto.TokenRoleContextKey)
+	if role != database.SuperAdminRole {
+		return errForbidden
+	}
+	stats, err := w.Handler.GetCacheStats(ctx.Request().Context())
+	if err != nil {
+		return err
+	}
+	return ctx.JSON(http.StatusOK, stats)
+}
+
+// FlushCache converts echo context to params.
+func (w *ServerInterfaceWrapper) FlushCache(ctx echo.Context) error {
+	role := ctx.Get(dto.TokenRoleContextKey)
+	if role != database.AdminRole {
+		return errForbidden
+	}
+	params, err := dto.NewFlushCacheParams(ctx)
+	if err != nil {
+		return invalidParameter(err)
+	}
+	flushed, err := w.Handler.FlushCache(ctx.Request().Context(), *params)
+	if err != nil {
+		return err
+	}
+	return ctx.JSON(http.StatusOK, struct {
+		Flushed int `json:"flushed"`
+	}{Flushed: flushed})
+}
+
+func (w *ServerInterfaceWrapper) PostFeature(ctx echo.Context) error {
+	role := ctx.Get(dto.TokenRoleContextKey)
+	if role != database.AdminRole {
+		return errForbidden
+	}
+	var feature dto.Feature
+	if err := json.NewDecoder(ctx.Request().Body).Decode(&feature); err != nil {
+		return invalidBody(err)
+	}
+	id, err := w.Handler.PostFeature(ctx.Request().Context(), feature, dto.NewActor(ctx))
+	if err != nil {
+		return err
+	}
+	return ctx.JSON(http.StatusCreated, struct {
+		FeatureID int64 `json:"feature_id"`
+	}{FeatureID: id})
+}
+
+func (w *ServerInterfaceWrapper) GetFeatures(ctx echo.Context) error {
+	role := ctx.Get(dto.TokenRoleContextKey)
+	if role != database.AdminRole {
+		return errForbidden
+	}
+	features, err := w.Handler.GetFeatures(ctx.Request().Context())
+	if err != nil {
+		return err
+	}
+	return ctx.JSON(http.StatusOK, features)
+}
+
+func (w *ServerInterfaceWrapper) PostTag(ctx echo.Context) error {
+	role := ctx.Get(dto.TokenRoleContextKey)
+	if role != database.AdminRole {
+		return errForbidden
+	}
+	var tag dto.Tag
+	if err := json.NewDecoder(ctx.Request().Body).Decode(&tag); err != nil {
+		return invalidBody(err)
+	}
+	id, err := w.Handler.PostTag(ctx.Request().Context(), tag, dto.NewActor(ctx))
+	if err != nil {
+		return err
+	}
+	return ctx.JSON(http.StatusCreated, struct {
+		TagID int64 `json:"tag_id"`
+	}{TagID: id})
+}
+
+func (w *ServerInterfaceWrapper) GetTags(ctx echo.Context) error {
+	role := ctx.Get(dto.TokenRoleContextKey)
+	if role != database.AdminRole {
+		return errForbidden
+	}
+	tags, err := w.Handler.GetTags(ctx.Request().Context())
+	if err != nil {
+		return err
+	}
+	return ctx.JSON(http.StatusOK, tags)
+}
+
+func (w *ServerInterfaceWrapper) PostTenant(ctx echo.Context) error {
+	role := ctx.Get(dto.TokenRoleContextKey)
+	if role != database.SuperAdminRole {
+		return errForbidden
+	}
+	var tenant dto.Tenant
+	if err := json.NewDecoder(ctx.Request().Body).Decode(&tenant); err != nil {
+		return invalidBody(err)
+	}
+	if err := validator.Validate(tenant); err != nil {
+		return invalidBody(err)
+	}
+	id, err := w.Handler.PostTenant(ctx.Request().Context(), tenant, dto.NewActor(ctx))
+	if err != nil {
+		return err
+	}
+	return ctx.JSON(http.StatusCreated, struct {
+		TenantID int64 `json:"tenant_id"`
+	}{TenantID: id})
+}
+
+func (w *ServerInterfaceWrapper) GetTenants(ctx echo.Context) error {
+	role := ctx.Get(dto.TokenRoleContextKey)
+	if role != database.SuperAdminRole {
+		return errForbidden
+	}
+	tenants, err := w.Handler.GetTenants(ctx.Request().Context())
+	if err != nil {
+		return err
+	}
+	return ctx.JSON(http.StatusOK, tenants)
+}
+
+func (w *ServerInterfaceWrapper) PostTenantAdmin(ctx echo.Context) error {
+	role := ctx.Get(dto.TokenRoleContextKey)
+	if role != database.SuperAdminRole {
+		return errForbidden
+	}
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+	if err != nil {
+		return invalidParameter(fmt.Errorf("invalid id format: %s", err))
+	}
+	var user dto.User
+	if err = json.NewDecoder(ctx.Request().Body).Decode(&user); err != nil {
+		return invalidBody(err)
+	}
+	if err = validator.Validate(user); err != nil {
+		return invalidBody(err)
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), w.Options.BcryptCost)
+	if err != nil {
+		return fmt.Errorf("error hashing password: %w", err)
+	}
+	err = w.Handler.PostTenantAdmin(ctx.Request().Context(), id, user.Username, string(hashedPassword), dto.NewActor(ctx))
+	if err != nil {
+		return err
+	}
+	return ctx.NoContent(http.StatusCreated)
+}
+
+func (w *ServerInterfaceWrapper) PostWebhook(ctx echo.Context) error {
+	role := ctx.Get(dto.TokenRoleContextKey)
+	if role != database.AdminRole {
+		return errForbidden
+	}
+	var webhook dto.Webhook
+	if err := json.NewDecoder(ctx.Request().Body).Decode(&webhook); err != nil {
+		return invalidBody(err)
+	}
+	if err := validator.Validate(webhook); err != nil {
+		return invalidBody(err)
+	}
+	if err := validateWebhook(webhook); err != nil {
+		return invalidBody(err)
+	}
+	id, err := w.Handler.PostWebhook(ctx.Request().Context(), webhook, dto.NewActor(ctx))
+	if err != nil {
+		return err
+	}
+	return ctx.JSON(http.StatusCreated, struct {
+		WebhookID int64 `json:"webhook_id"`
+	}{WebhookID: id})
+}
+
+func (w *ServerInterfaceWrapper) GetWebhooks(ctx echo.Context) error {
+	role := ctx.Get(dto.TokenRoleContextKey)
+	if role != database.AdminRole {
+		return errForbidden
+	}
+	webhooks, err := w.Handler.GetWebhooks(ctx.Request().Context())
+	if err != nil {
+		return err
+	}
+	return ctx.JSON(http.StatusOK, webhooks)
+}
+
+func (w *ServerInterfaceWrapper) DeleteWebhookID(ctx echo.Context) error {
+	role := ctx.Get(dto.TokenRoleContextKey)
+	if role != database.AdminRole {
+		return errForbidden
+	}
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+	if err != nil {
+		return invalidParameter(fmt.Errorf("invalid id format: %s", err))
+	}
+	if err = w.Handler.DeleteWebhookID(ctx.Request().Context(), id, dto.NewActor(ctx)); err != nil {
+		return err
+	}
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+func (w *ServerInterfaceWrapper) PostAPIKey(ctx echo.Context) error {
+	role := ctx.Get(dto.TokenRoleContextKey)
+	if role != database.AdminRole {
+		return errForbidden
+	}
+	var key dto.APIKey
+	if err := json.NewDecoder(ctx.Request().Body).Decode(&key); err != nil {
+		return invalidBody(err)
+	}
+	if err := validator.Validate(key); err != nil {
+		return invalidBody(err)
+	}
+	if err := validateAPIKey(key); err != nil {
+		return invalidBody(err)
+	}
+	created, err := w.Handler.PostAPIKey(ctx.Request().Context(), key, dto.NewActor(ctx))
+	if err != nil {
+		return err
+	}
+	return ctx.JSON(http.StatusCreated, created)
+}
+
+func (w *ServerInterfaceWrapper) GetAPIKeys(ctx echo.Context) error {
+	role := ctx.Get(dto.TokenRoleContextKey)
+	if role != database.AdminRole {
+		return errForbidden
+	}
+	keys, err := w.Handler.GetAPIKeys(ctx.Request().Context())
+	if err != nil {
+		return err
+	}
+	return ctx.JSON(http.StatusOK, keys)
+}
+
+func (w *ServerInterfaceWrapper) DeleteAPIKeyID(ctx echo.Context) error {
+	role := ctx.Get(dto.TokenRoleContextKey)
+	if role != database.AdminRole {
+		return errForbidden
+	}
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+	if err != nil {
+		return invalidParameter(fmt.Errorf("invalid id format: %s", err))
+	}
+	if err = w.Handler.DeleteAPIKeyID(ctx.Request().Context(), id, dto.NewActor(ctx)); err != nil {
+		return err
+	}
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+func (w *ServerInterfaceWrapper) GetWebhookDeliveries(ctx echo.Context) error {
+	role := ctx.Get(dto.TokenRoleContextKey)
+	if role != database.AdminRole {
+		return errForbidden
+	}
+	params, err := dto.NewGetWebhookDeliveriesParams(ctx, w.pageLimits())
+	if err != nil {
+		return invalidParameter(err)
+	}
+	deliveries, err := w.Handler.GetWebhookDeliveries(ctx.Request().Context(), *params)
+	if err != nil {
+		return err
+	}
+	return ctx.JSON(http.StatusOK, deliveries)
+}
+
 func (w *ServerInterfaceWrapper) Login(ctx echo.Context) error {
 	creds := ctx.Request().Header.Get("Authorization")
 	if creds == "" || len(strings.Split(creds, " ")) < 2 {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Required Authorization header missing"))
+		return invalidParameter(errors.New("required Authorization header missing"))
 	}
 	creds = strings.Split(creds, " ")[1]
 	raw, err := base64.StdEncoding.DecodeString(creds)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusUnauthorized, fmt.Sprintf("Auth failed: %s", err))
+		return &APIError{Status: http.StatusUnauthorized, Code: CodeInvalidCredentials, Message: "credentials are not valid base64", Err: err}
+	}
+	username, password, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return &APIError{Status: http.StatusUnauthorized, Code: CodeInvalidCredentials, Message: "credentials must be username:password"}
+	}
+	tenant := database.DefaultTenant
+	if header := ctx.Request().Header.Get(TenantHeader); header != "" {
+		tenant, err = strconv.ParseInt(header, 10, 64)
+		if err != nil || tenant < 1 {
+			return invalidParameter(fmt.Errorf("%s must be a positive integer", TenantHeader))
+		}
 	}
-	decodedCreds := strings.Split(string(raw), ":")
-	role, err := w.Handler.Login(decodedCreds[0], decodedCreds[1])
+	claims, err := w.Handler.Login(database.WithTenant(ctx.Request().Context(), tenant), username, password)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusUnauthorized, fmt.Sprintf("Auth failed: %s", err))
+		return err
 	}
-	token, err := CreateJWT(role)
+	token, err := CreateTenantJWT(claims.Tenant, claims.Username, claims.Role)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("Failure during JWT generation: %s", err))
+		return fmt.Errorf("error generating token: %w", err)
 	}
 	return ctx.JSON(http.StatusOK, struct {
-		Token string
+		Token string `json:"token"`
 	}{
 		Token: token,
 	})
@@ -171,19 +633,19 @@ func (w *ServerInterfaceWrapper) Signup(ctx echo.Context) error {
 	var user dto.User
 	err := decoder.Decode(&user)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid request body: %s", err))
+		return invalidBody(err)
 	}
 	err = validator.Validate(user)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid request body: %s", err))
+		return invalidBody(err)
 	}
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), 8)
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), w.Options.BcryptCost)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("Failure during password encryption: %s", err))
+		return fmt.Errorf("error hashing password: %w", err)
 	}
-	err = w.Handler.Signup(user.Username, string(hashedPassword))
+	err = w.Handler.Signup(ctx.Request().Context(), user.Username, string(hashedPassword), dto.NewActor(ctx))
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("Failure during registration: %s", err))
+		return err
 	}
 	return ctx.NoContent(http.StatusCreated)
 }
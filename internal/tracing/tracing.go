@@ -0,0 +1,87 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+)
+
+const ServiceName = "banner-service"
+
+var tracer = otel.Tracer("github.com/Paincake/avito-tech/internal/tracing")
+
+// Setup installs the global tracer provider exporting spans over OTLP/HTTP to
+// endpointURL (e.g. http://collector:4318/v1/traces), together with the W3C
+// trace context propagator. With an empty endpointURL only the propagator is
+// installed and spans are dropped. The returned function flushes and stops
+// the exporter.
+func Setup(ctx context.Context, endpointURL string) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	if endpointURL == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(endpointURL))
+	if err != nil {
+		return nil, fmt.Errorf("error creating trace exporter: %s", err)
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(ServiceName))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// Middleware starts a server span for every request, continuing the trace of
+// an incoming traceparent header, and returns the traceparent of the span in
+// the response so callers can find the trace.
+func Middleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		request := c.Request()
+		propagator := otel.GetTextMapPropagator()
+		ctx := propagator.Extract(request.Context(), propagation.HeaderCarrier(request.Header))
+		route := c.Path()
+		if route == "" {
+			route = request.URL.Path
+		}
+		ctx, span := tracer.Start(ctx, request.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(request.Method),
+				semconv.HTTPRoute(route),
+				semconv.URLPath(request.URL.Path),
+			))
+		defer span.End()
+		c.SetRequest(request.WithContext(ctx))
+		propagator.Inject(ctx, propagation.HeaderCarrier(c.Response().Header()))
+
+		err := next(c)
+		status := c.Response().Status
+		if err != nil {
+			status = http.StatusInternalServerError
+			var httpErr *echo.HTTPError
+			var statusErr interface{ HTTPStatus() int }
+			if errors.As(err, &httpErr) {
+				status = httpErr.Code
+			} else if errors.As(err, &statusErr) {
+				status = statusErr.HTTPStatus()
+			}
+		}
+		span.SetAttributes(attribute.Int("http.response.status_code", status))
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+		return err
+	}
+}
@@ -0,0 +1,80 @@
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	collectortrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/protobuf/proto"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const (
+	traceID     = "4bf92f3577b34da6a3ce929d0e0e4736"
+	traceParent = "00-" + traceID + "-00f067aa0ba902b7-01"
+)
+
+// collectorStub is an OTLP/HTTP trace receiver recording the span names by
+// trace id.
+type collectorStub struct {
+	mu    sync.Mutex
+	spans map[string][]string
+}
+
+func (s *collectorStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	var request collectortrace.ExportTraceServiceRequest
+	if err := proto.Unmarshal(body, &request); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, resourceSpans := range request.ResourceSpans {
+		for _, scopeSpans := range resourceSpans.ScopeSpans {
+			for _, span := range scopeSpans.Spans {
+				id := hex.EncodeToString(span.TraceId)
+				s.spans[id] = append(s.spans[id], span.Name)
+			}
+		}
+	}
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	response, _ := proto.Marshal(&collectortrace.ExportTraceServiceResponse{})
+	w.Write(response)
+}
+
+func TestMiddleware_ShouldContinueTraceAndExport(t *testing.T) {
+	stub := &collectorStub{spans: make(map[string][]string)}
+	collector := httptest.NewServer(stub)
+	defer collector.Close()
+	shutdown, err := Setup(context.Background(), collector.URL+"/v1/traces")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	e := echo.New()
+	e.Use(Middleware)
+	e.GET("/banner/:id", func(c echo.Context) error {
+		_, span := tracer.Start(c.Request().Context(), "handler")
+		span.End()
+		return c.NoContent(http.StatusOK)
+	})
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/banner/1", nil)
+	req.Header.Set("traceparent", traceParent)
+	e.ServeHTTP(recorder, req)
+
+	assert.True(t, strings.HasPrefix(recorder.Header().Get("traceparent"), "00-"+traceID+"-"))
+	if err = shutdown(context.Background()); err != nil {
+		t.Fatalf("%s", err)
+	}
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+	assert.ElementsMatch(t, []string{"GET /banner/:id", "handler"}, stub.spans[traceID])
+}